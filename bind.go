@@ -0,0 +1,122 @@
+package script_engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// BindFunction 将脚本函数 name 绑定到 fnPtr 指向的 Go 函数变量，例如：
+//
+//	var calcPrice func(ctx context.Context, order Order) (Price, error)
+//	err := BindFunction(eng, "calcPrice", &calcPrice)
+//
+// Go 函数的第一个参数可以是 context.Context，作为每次调用的 ctx，其余参数依次传给脚本函数；
+// 最后一个返回值必须是 error，其余返回值按 Decode 的规则依次从脚本函数的返回值解码。
+// 脚本函数使用 CallFunctionMulti 调用，脚本按惯例返回的错误（例如 Lua 的 `return nil, err`）作为调用的错误返回；
+// 启用 SpreadArrayResults 时 JavaScript 函数返回的数组被展开为多个返回值。
+//
+// 函数签名不合法、脚本函数不存在或不是函数、参数个数与脚本函数不符时在绑定时返回错误，
+// 参数个数不符的错误包装 ErrArityMismatch。
+func BindFunction(eng FunctionBinder, name string, fnPtr any) error {
+	ptr := reflect.ValueOf(fnPtr)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("bind %s: target must be a non-nil pointer to a func, got %T", name, fnPtr)
+	}
+	typ := ptr.Elem().Type()
+
+	if typ.NumOut() == 0 || typ.Out(typ.NumOut()-1) != errorType {
+		return fmt.Errorf("bind %s: the last result of %s must be error", name, typ)
+	}
+	results := typ.NumOut() - 1
+
+	withContext := typ.NumIn() > 0 && typ.In(0) == contextType
+	first := 0
+	if withContext {
+		first = 1
+	}
+
+	sig, err := eng.InspectFunction(name)
+	if err != nil {
+		return fmt.Errorf("bind %s: %w", name, err)
+	}
+	if err = checkArity(typ, typ.NumIn()-first, sig); err != nil {
+		return fmt.Errorf("bind %s: %w", name, err)
+	}
+
+	fn := reflect.MakeFunc(typ, func(in []reflect.Value) []reflect.Value {
+		ctx := context.Background()
+		if withContext && !in[0].IsNil() {
+			ctx = in[0].Interface().(context.Context)
+		}
+
+		args := make([]any, 0, len(in)-first)
+		for i, arg := range in[first:] {
+			if typ.IsVariadic() && first+i == typ.NumIn()-1 {
+				for j := 0; j < arg.Len(); j++ {
+					args = append(args, arg.Index(j).Interface())
+				}
+				break
+			}
+			args = append(args, arg.Interface())
+		}
+
+		out := make([]reflect.Value, typ.NumOut())
+		for i := 0; i < results; i++ {
+			out[i] = reflect.New(typ.Out(i)).Elem()
+		}
+
+		fail := func(err error) []reflect.Value {
+			out[results] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+
+		res, err := eng.CallFunctionMulti(ctx, name, args...)
+		if err != nil {
+			return fail(err)
+		}
+		if res.Error != nil {
+			return fail(res.Error)
+		}
+
+		for i := 0; i < results && i < len(res.Values); i++ {
+			path := ""
+			if results > 1 {
+				path = indexPath(path, i)
+			}
+			if err := decode(path, res.Values[i], out[i]); err != nil {
+				return fail(err)
+			}
+		}
+		out[results] = reflect.Zero(errorType)
+		return out
+	})
+
+	ptr.Elem().Set(fn)
+	return nil
+}
+
+// checkArity 检查 Go 函数 typ 传给脚本函数的 params 个参数是否与脚本函数的签名相符
+func checkArity(typ reflect.Type, params int, sig FunctionSignature) error {
+	fixed := params
+	if typ.IsVariadic() {
+		fixed--
+	}
+
+	switch {
+	case fixed < sig.Params-sig.Optional:
+		return fmt.Errorf("%w: %s passes %d arguments, script function expects at least %d", ErrArityMismatch, typ, fixed, sig.Params-sig.Optional)
+	case sig.Variadic:
+		return nil
+	case typ.IsVariadic():
+		return fmt.Errorf("%w: %s is variadic, script function expects %d arguments", ErrArityMismatch, typ, sig.Params)
+	case fixed > sig.Params:
+		return fmt.Errorf("%w: %s passes %d arguments, script function expects %d", ErrArityMismatch, typ, fixed, sig.Params)
+	}
+	return nil
+}
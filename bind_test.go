@@ -0,0 +1,43 @@
+package script_engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckArity(t *testing.T) {
+	var (
+		two      = reflect.TypeOf(func(int, int) {})
+		variadic = reflect.TypeOf(func(int, ...int) {})
+	)
+
+	for _, tt := range []struct {
+		name string
+		typ  reflect.Type
+		sig  FunctionSignature
+		err  string
+	}{
+		{"exact", two, FunctionSignature{Params: 2}, ""},
+		{"too few", two, FunctionSignature{Params: 3}, "passes 2 arguments, script function expects at least 3"},
+		{"too many", two, FunctionSignature{Params: 1}, "passes 2 arguments, script function expects 1"},
+		{"optional omitted", two, FunctionSignature{Params: 3, Optional: 1}, ""},
+		{"optional not enough", two, FunctionSignature{Params: 4, Optional: 1}, "expects at least 3"},
+		{"script variadic", two, FunctionSignature{Params: 1, Variadic: true}, ""},
+		{"script variadic too few", two, FunctionSignature{Params: 3, Variadic: true}, "expects at least 3"},
+		{"go variadic", variadic, FunctionSignature{Params: 1, Variadic: true}, ""},
+		{"go variadic fixed script", variadic, FunctionSignature{Params: 1}, "is variadic, script function expects 1 arguments"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkArity(tt.typ, tt.typ.NumIn(), tt.sig)
+			if tt.err == "" {
+				assert.Nil(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, ErrArityMismatch))
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
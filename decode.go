@@ -0,0 +1,445 @@
+package script_engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	bigIntType   = reflect.TypeOf((*big.Int)(nil))
+)
+
+// DecodeError 脚本值解码失败，Path 为出错字段的路径，例如 `user.tags[1]`，解码值本身出错时为空
+type DecodeError struct {
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return "decode: " + e.Err.Error()
+	}
+	return fmt.Sprintf("decode %s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// GetGlobalAs 读取全局变量 name 并解码为 T
+func GetGlobalAs[T any](eng GlobalGetter, name string) (T, error) {
+	var out T
+	err := GetGlobalInto(eng, name, &out)
+	return out, err
+}
+
+// GetGlobalInto 读取全局变量 name 并解码到 out 指向的值
+func GetGlobalInto(eng GlobalGetter, name string, out any) error {
+	value, err := eng.GetGlobal(name)
+	if err != nil {
+		return err
+	}
+	return Decode(value, out)
+}
+
+// CallAs 调用脚本函数 name 并将其返回值解码为 T
+func CallAs[T any](ctx context.Context, eng FunctionCaller, name string, args ...any) (T, error) {
+	var out T
+	err := CallFunctionInto(ctx, eng, name, &out, args...)
+	return out, err
+}
+
+// CallFunctionInto 调用脚本函数 name 并将其返回值解码到 out 指向的值
+func CallFunctionInto(ctx context.Context, eng FunctionCaller, name string, out any, args ...any) error {
+	value, err := eng.CallFunction(ctx, name, args...)
+	if err != nil {
+		return err
+	}
+	return Decode(value, out)
+}
+
+// Decode 将引擎返回的脚本值解码到 out 指向的 Go 值，out 必须是非 nil 指针。
+//   - 数字按目标类型转换，整数类型不接受带小数或越界的数字，
+//     也接受十进制字符串与 *big.Int，以便精确解码 Int64AsString、Int64AsObject 模式下的 64 位整数；
+//   - 数组解码为 slice 或 array，对象（map）解码为 map 或 struct；
+//   - struct 字段名依次取 script 标签、json 标签与字段名，匹配时先精确匹配再忽略大小写与下划线，
+//     标签为 "-" 的字段被忽略，匿名嵌入的 struct 从同一个对象中解码；
+//   - time.Time 接受 time.Time、Unix 时间戳（秒）与 RFC 3339 字符串，
+//     time.Duration 接受秒数与 time.ParseDuration 格式的字符串。
+//
+// 失败时返回 *DecodeError，其中的路径指出出错的字段。
+func Decode(value any, out any) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &DecodeError{Err: fmt.Errorf("target must be a non-nil pointer, got %T", out)}
+	}
+	return decode("", value, rv.Elem())
+}
+
+// decode 将 value 解码到 dst，path 为 dst 的路径
+func decode(path string, value any, dst reflect.Value) error {
+	if value == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	src := reflect.ValueOf(value)
+	switch dst.Type() {
+	case timeType:
+		t, err := decodeTime(value)
+		if err != nil {
+			return &DecodeError{Path: path, Err: err}
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := decodeDuration(value)
+		if err != nil {
+			return &DecodeError{Path: path, Err: err}
+		}
+		dst.Set(reflect.ValueOf(d))
+		return nil
+	}
+
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+
+	mismatch := func() error {
+		return &DecodeError{Path: path, Err: fmt.Errorf("cannot decode %T into %s", value, dst.Type())}
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		// 与 encoding/json 一致，指针不为 nil 时解码到其指向的值
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return decode(path, value, dst.Elem())
+
+	case reflect.Bool:
+		if src.Kind() != reflect.Bool {
+			return mismatch()
+		}
+		dst.SetBool(src.Bool())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok, exact := toInt64(src)
+		if !ok {
+			return mismatch()
+		}
+		if !exact || dst.OverflowInt(n) {
+			return &DecodeError{Path: path, Err: fmt.Errorf("number %v overflows %s", value, dst.Type())}
+		}
+		dst.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok, exact := toUint64(src)
+		if !ok {
+			return mismatch()
+		}
+		if !exact || dst.OverflowUint(n) {
+			return &DecodeError{Path: path, Err: fmt.Errorf("number %v overflows %s", value, dst.Type())}
+		}
+		dst.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(src)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetFloat(f)
+
+	case reflect.String:
+		if src.Kind() != reflect.String {
+			return mismatch()
+		}
+		dst.SetString(src.String())
+
+	case reflect.Slice:
+		if src.Kind() == reflect.String && dst.Type().Elem().Kind() == reflect.Uint8 {
+			dst.SetBytes([]byte(src.String()))
+			return nil
+		}
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := decode(indexPath(path, i), src.Index(i).Interface(), slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+
+	case reflect.Array:
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			return mismatch()
+		}
+		if src.Len() > dst.Len() {
+			return &DecodeError{Path: path, Err: fmt.Errorf("%d elements do not fit in %s", src.Len(), dst.Type())}
+		}
+		for i := 0; i < dst.Len(); i++ {
+			var elem any
+			if i < src.Len() {
+				elem = src.Index(i).Interface()
+			}
+			if err := decode(indexPath(path, i), elem, dst.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if src.Kind() != reflect.Map {
+			return mismatch()
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			keyPath := fmt.Sprintf("%s[%v]", path, iter.Key().Interface())
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := decodeKey(keyPath, iter.Key().Interface(), key); err != nil {
+				return err
+			}
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := decode(keyPath, iter.Value().Interface(), elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		dst.Set(m)
+
+	case reflect.Struct:
+		if src.Kind() != reflect.Map || src.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		return decodeStruct(path, src, dst)
+
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// decodeStruct 从键为字符串的 map 解码 struct 的导出字段
+func decodeStruct(path string, src, dst reflect.Value) error {
+	typ := dst.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, ok := structFieldName(field)
+		if !ok {
+			continue
+		}
+
+		// 匿名嵌入且未指定名称的 struct 从同一个对象中解码
+		if field.Anonymous && name == field.Name {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fv := dst.Field(i)
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						if !fv.CanSet() {
+							continue
+						}
+						fv.Set(reflect.New(ft))
+					}
+					fv = fv.Elem()
+				}
+				if !fv.CanSet() {
+					continue
+				}
+				if err := decodeStruct(path, src, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() || !dst.Field(i).CanSet() {
+			continue
+		}
+
+		value, ok := lookupKey(src, name)
+		if !ok && name != field.Name {
+			// 引擎按 FieldNaming 命名时，字段名可能由 Go 名称转换而来
+			value, ok = lookupKey(src, field.Name)
+		}
+		if !ok {
+			continue
+		}
+		if err := decode(fieldPath(path, name), value.Interface(), dst.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structFieldName 返回字段对应的脚本键名：依次取 script 标签、json 标签与字段名，字段被忽略时 ok 为 false
+func structFieldName(field reflect.StructField) (name string, ok bool) {
+	if !field.IsExported() && !field.Anonymous {
+		return "", false
+	}
+	for _, key := range []string{"script", "json"} {
+		tag, found := field.Tag.Lookup(key)
+		if !found {
+			continue
+		}
+		if tag == "-" {
+			return "", false
+		}
+		if name, _, _ = strings.Cut(tag, ","); name != "" {
+			return name, true
+		}
+	}
+	return field.Name, true
+}
+
+// lookupKey 在 map 中查找 name，先精确匹配，再按 MatchFieldName 忽略大小写与下划线匹配
+func lookupKey(m reflect.Value, name string) (reflect.Value, bool) {
+	key := reflect.ValueOf(name).Convert(m.Type().Key())
+	if v := m.MapIndex(key); v.IsValid() {
+		return v, true
+	}
+
+	iter := m.MapRange()
+	for iter.Next() {
+		if MatchFieldName(iter.Key().String(), name) {
+			return iter.Value(), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// decodeKey 解码 map 的键。脚本对象的键总是字符串，目标为数字类型时按数字解析。
+func decodeKey(path string, key any, dst reflect.Value) error {
+	if s, ok := key.(string); ok {
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+			reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return &DecodeError{Path: path, Err: fmt.Errorf("cannot decode key %q into %s", s, dst.Type())}
+			}
+			return decode(path, f, dst)
+		}
+	}
+	return decode(path, key, dst)
+}
+
+// toFloat 将任意数字类型的值转换为 float64
+func toFloat(v reflect.Value) (float64, bool) {
+	switch {
+	case v.CanInt():
+		return float64(v.Int()), true
+	case v.CanUint():
+		return float64(v.Uint()), true
+	case v.CanFloat():
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// toInt64 将整数、整数值的浮点数、十进制字符串与 *big.Int 精确转换为 int64。
+// v 不是数字时 ok 为 false，不是整数或超出 int64 范围时 exact 为 false。
+func toInt64(v reflect.Value) (n int64, ok, exact bool) {
+	switch {
+	case v.CanInt():
+		return v.Int(), true, true
+	case v.CanUint():
+		return int64(v.Uint()), true, v.Uint() <= math.MaxInt64
+	case v.Kind() == reflect.String:
+		n, err := strconv.ParseInt(v.String(), 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, true, false
+		}
+		return n, err == nil, err == nil
+	case v.Type() == bigIntType:
+		b := v.Interface().(*big.Int)
+		return b.Int64(), true, b.IsInt64()
+	}
+
+	f, ok := toFloat(v)
+	if !ok {
+		return 0, false, false
+	}
+	return int64(f), true, f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+}
+
+// toUint64 将整数、整数值的浮点数、十进制字符串与 *big.Int 精确转换为 uint64，返回值的含义与 toInt64 相同
+func toUint64(v reflect.Value) (n uint64, ok, exact bool) {
+	switch {
+	case v.CanUint():
+		return v.Uint(), true, true
+	case v.CanInt():
+		return uint64(v.Int()), true, v.Int() >= 0
+	case v.Kind() == reflect.String:
+		n, err := strconv.ParseUint(v.String(), 10, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, true, false
+		}
+		return n, err == nil, err == nil
+	case v.Type() == bigIntType:
+		b := v.Interface().(*big.Int)
+		return b.Uint64(), true, b.IsUint64()
+	}
+
+	f, ok := toFloat(v)
+	if !ok {
+		return 0, false, false
+	}
+	return uint64(f), true, f == math.Trunc(f) && f >= 0 && f < math.MaxUint64
+}
+
+// decodeTime 将 time.Time、Unix 时间戳（秒）或 RFC 3339 字符串解码为 time.Time
+func decodeTime(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	}
+	if f, ok := toFloat(reflect.ValueOf(value)); ok {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("cannot decode %T into time.Time", value)
+}
+
+// decodeDuration 将秒数或 time.ParseDuration 格式的字符串解码为 time.Duration
+func decodeDuration(value any) (time.Duration, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+	if f, ok := toFloat(reflect.ValueOf(value)); ok {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("cannot decode %T into time.Duration", value)
+}
+
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
@@ -0,0 +1,134 @@
+package script_engine
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	type Address struct {
+		City string `json:"city"`
+	}
+	type User struct {
+		Address
+		ID      string         `script:"id" json:"user_id"`
+		Name    string         `json:"name"`
+		Age     int8           `json:"age"`
+		Score   uint16         `json:"score"`
+		Tags    []string       `json:"tags"`
+		Pair    [2]int         `json:"pair"`
+		Counts  map[int]int64  `json:"counts"`
+		Born    time.Time      `json:"born"`
+		Timeout time.Duration  `json:"timeout"`
+		Manager *User          `json:"manager"`
+		Data    []byte         `json:"data"`
+		Secret  string         `script:"-" json:"secret"`
+		Extra   map[string]any `json:"extra"`
+	}
+
+	born := time.Unix(946782245, 0)
+	var user User
+	err := Decode(map[string]any{
+		"id":      "u1",
+		"user_id": "ignored",
+		"Name":    "tom",
+		"city":    "paris",
+		"age":     int64(42),
+		"score":   float64(100),
+		"tags":    []any{"a", "b"},
+		"pair":    []any{int64(1)},
+		"counts":  map[string]any{"7": "9007199254740993"},
+		"born":    born.Unix(),
+		"timeout": "1m30s",
+		"manager": map[string]any{"name": "bob"},
+		"data":    "bytes",
+		"secret":  "hidden",
+		"extra":   map[string]any{"n": nil},
+	}, &user)
+	assert.Nil(t, err)
+	assert.Equal(t, User{
+		Address: Address{City: "paris"},
+		ID:      "u1",
+		Name:    "tom",
+		Age:     42,
+		Score:   100,
+		Tags:    []string{"a", "b"},
+		Pair:    [2]int{1, 0},
+		Counts:  map[int]int64{7: 9007199254740993},
+		Born:    born,
+		Timeout: 90 * time.Second,
+		Manager: &User{Name: "bob"},
+		Data:    []byte("bytes"),
+		Extra:   map[string]any{"n": nil},
+	}, user)
+
+	// 64 位整数可以从十进制字符串与 *big.Int 精确解码
+	var n uint64
+	assert.Nil(t, Decode(new(big.Int).SetUint64(1<<63+1), &n))
+	assert.Equal(t, uint64(1<<63+1), n)
+
+	for _, tt := range []struct {
+		name  string
+		value any
+		out   any
+		path  string
+		err   string
+	}{
+		{"not a pointer", 1, User{}, "", "target must be a non-nil pointer"},
+		{"type mismatch", "x", new(int), "", "cannot decode string into int"},
+		{"overflow", int64(128), new(int8), "", "number 128 overflows int8"},
+		{"fraction", 1.5, new(int), "", "number 1.5 overflows int"},
+		{"negative unsigned", int64(-1), new(uint), "", "number -1 overflows uint"},
+		{"string overflow", "18446744073709551616", new(uint64), "", "overflows uint64"},
+		{"nested field", map[string]any{"manager": map[string]any{"tags": []any{"a", 1}}}, new(User), "manager.tags[1]", "cannot decode int into string"},
+		{"script tag path", map[string]any{"id": 1}, new(User), "id", "cannot decode int into string"},
+		{"map key", map[string]any{"counts": map[string]any{"x": 1}}, new(User), "counts[x]", `cannot decode key "x" into int`},
+		{"array length", []any{1, 2, 3}, new([2]int), "", "3 elements do not fit in [2]int"},
+		{"time", true, new(time.Time), "", "cannot decode bool into time.Time"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Decode(tt.value, tt.out)
+			var de *DecodeError
+			assert.True(t, errors.As(err, &de), err)
+			assert.Equal(t, tt.path, de.Path)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestStructFieldName(t *testing.T) {
+	type tagged struct {
+		Script   string `script:"s" json:"js"`
+		JSON     string `json:"j,omitempty"`
+		Plain    string
+		Skipped  string `script:"-" json:"shown"`
+		Hidden   string `json:"-"`
+		Fallback string `script:",omitempty" json:"fallback"`
+		private  string
+	}
+
+	typ := reflect.TypeOf(tagged{})
+	for _, tt := range []struct {
+		field string
+		name  string
+		ok    bool
+	}{
+		{"Script", "s", true},
+		{"JSON", "j", true},
+		{"Plain", "Plain", true},
+		{"Skipped", "", false},
+		{"Hidden", "", false},
+		{"Fallback", "fallback", true},
+		{"private", "", false},
+	} {
+		field, _ := typ.FieldByName(tt.field)
+		name, ok := structFieldName(field)
+		assert.Equal(t, tt.ok, ok, tt.field)
+		assert.Equal(t, tt.name, name, tt.field)
+	}
+}
@@ -11,15 +11,73 @@ import (
 // EnginePool 管理多个独立 Engine 实例以支持并发执行。
 // NewEnginePool 需要提供一个 factory 用于创建单个 Engine 实例。
 type EnginePool struct {
-	pool   chan Engine
-	size   int
-	mu     sync.Mutex
-	closed bool
+	pool    chan Engine
+	size    int
+	mu      sync.Mutex
+	closed  bool
+	modules poolModules
+}
+
+// poolModules 记录引擎池通过 RegisterModule 注册的模块。引擎被取出时补充注册尚未注册的模块，
+// 使已借出或按需创建的引擎同样获得之后注册的模块。
+type poolModules struct {
+	mu      sync.Mutex
+	modules []poolModule
+	applied map[Engine]int // 各引擎已注册的模块数
+}
+
+type poolModule struct {
+	name   string
+	module any
+}
+
+// add 记录模块，eng 为已注册该模块的引擎
+func (m *poolModules) add(eng Engine, name string, module any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.applied == nil {
+		m.applied = make(map[Engine]int)
+	}
+	m.modules = append(m.modules, poolModule{name: name, module: module})
+	if m.applied[eng] == len(m.modules)-1 {
+		m.applied[eng] = len(m.modules)
+	}
+}
+
+// sync 为 eng 注册尚未注册的模块
+func (m *poolModules) sync(eng Engine) error {
+	m.mu.Lock()
+	applied := m.applied[eng]
+	pending := m.modules[applied:]
+	m.mu.Unlock()
+
+	for i, pm := range pending {
+		if err := eng.RegisterModule(pm.name, pm.module); err != nil {
+			return err
+		}
+		m.mu.Lock()
+		m.applied[eng] = applied + i + 1
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+// forget 移除已关闭引擎的记录
+func (m *poolModules) forget(eng Engine) {
+	m.mu.Lock()
+	delete(m.applied, eng)
+	m.mu.Unlock()
+}
+
+// closeEngine 关闭引擎并移除其模块记录
+func (m *poolModules) closeEngine(eng Engine) error {
+	m.forget(eng)
+	return eng.Close()
 }
 
 // NewEnginePool 创建并初始化一个包含 size 个 Engine 的池。
-// factory 用于创建单个 Engine（例如 newLuaEngine）。
-func NewEnginePool(size int, typ Type) (*EnginePool, error) {
+// typ 为引擎类型，opts 为创建每个 Engine 时使用的选项。
+func NewEnginePool(size int, typ Type, opts ...Option) (*EnginePool, error) {
 	if size < 1 {
 		return nil, errors.New("pool size must be >= 1")
 	}
@@ -32,7 +90,7 @@ func NewEnginePool(size int, typ Type) (*EnginePool, error) {
 	// 创建并初始化子 engine
 	created := make([]Engine, 0, size)
 	for i := 0; i < size; i++ {
-		eng, err := NewScriptEngine(typ)
+		eng, err := NewScriptEngine(typ, opts...)
 		if err != nil {
 			// 清理已创建的 engines
 			for _, e := range created {
@@ -65,13 +123,17 @@ func (p *EnginePool) Acquire() (Engine, error) {
 	p.mu.Lock()
 	if p.closed {
 		p.mu.Unlock()
-		return nil, errors.New("engine pool closed")
+		return nil, ErrPoolClosed
 	}
 	p.mu.Unlock()
 
 	eng, ok := <-p.pool
 	if !ok {
-		return nil, errors.New("engine pool closed")
+		return nil, ErrPoolClosed
+	}
+	if err := p.modules.sync(eng); err != nil {
+		p.Release(eng)
+		return nil, err
 	}
 	return eng, nil
 }
@@ -86,21 +148,21 @@ func (p *EnginePool) Release(e Engine) {
 	p.mu.Unlock()
 
 	if closed {
-		_ = e.Close()
+		_ = p.modules.closeEngine(e)
 		return
 	}
 
 	// 捕获并发 Close 导致的 send-on-closed panic
 	defer func() {
 		if r := recover(); r != nil {
-			_ = e.Close()
+			_ = p.modules.closeEngine(e)
 		}
 	}()
 
 	select {
 	case p.pool <- e:
 	default:
-		_ = e.Close()
+		_ = p.modules.closeEngine(e)
 	}
 }
 
@@ -117,7 +179,7 @@ func (p *EnginePool) Close() error {
 
 	var lastErr error
 	for eng := range p.pool {
-		if err := eng.Close(); err != nil {
+		if err := p.modules.closeEngine(eng); err != nil {
 			lastErr = err
 		}
 	}
@@ -228,6 +290,15 @@ func (p *EnginePool) ExecuteString(ctx context.Context, source string) (any, err
 	return eng.ExecuteString(ctx, source)
 }
 
+func (p *EnginePool) ExecuteStringWithOptions(ctx context.Context, source string, opts ExecuteOptions) (any, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release(eng)
+	return eng.ExecuteStringWithOptions(ctx, source, opts)
+}
+
 func (p *EnginePool) ExecuteFile(ctx context.Context, filePath string) (any, error) {
 	eng, err := p.Acquire()
 	if err != nil {
@@ -291,13 +362,54 @@ func (p *EnginePool) CallFunction(ctx context.Context, name string, args ...any)
 	return eng.CallFunction(ctx, name, args...)
 }
 
+func (p *EnginePool) CallFunctionWithOptions(ctx context.Context, name string, opts ExecuteOptions, args ...any) (any, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release(eng)
+	return eng.CallFunctionWithOptions(ctx, name, opts, args...)
+}
+
+func (p *EnginePool) CallFunctionMulti(ctx context.Context, name string, args ...any) (CallResult, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return CallResult{}, err
+	}
+	defer p.Release(eng)
+	return eng.CallFunctionMulti(ctx, name, args...)
+}
+
+func (p *EnginePool) InspectFunction(name string) (FunctionSignature, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return FunctionSignature{}, err
+	}
+	defer p.Release(eng)
+	return eng.InspectFunction(name)
+}
+
+// RegisterModule 将模块注册到池中的所有引擎：先注册到一个引擎以检查模块，
+// 其余引擎（包括已借出的引擎）在下一次被取出时注册。
 func (p *EnginePool) RegisterModule(name string, module any) error {
 	eng, err := p.Acquire()
 	if err != nil {
 		return err
 	}
 	defer p.Release(eng)
-	return eng.RegisterModule(name, module)
+	if err = eng.RegisterModule(name, module); err != nil {
+		return err
+	}
+	p.modules.add(eng, name, module)
+	return nil
+}
+
+// RegisterNativeModule 以 module.Name 将 module 注册到池中的所有引擎，参见 RegisterModule。
+func (p *EnginePool) RegisterNativeModule(module *NativeModule) error {
+	if err := module.Validate(); err != nil {
+		return err
+	}
+	return p.RegisterModule(module.Name, module)
 }
 
 func (p *EnginePool) GetLastError() error {
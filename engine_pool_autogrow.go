@@ -12,17 +12,21 @@ import (
 type AutoGrowEnginePool struct {
 	pool chan Engine
 	typ  Type
+	opts []Option
 
 	mu     sync.Mutex
 	total  int // 当前已创建的实例数
 	max    int
 	closed bool
+
+	modules poolModules
 }
 
 // NewAutoGrowEnginePool 创建一个可自增长的池。
 // initialSize: 初始创建数量（>=0）
 // maxSize: 池允许的最大实例数（必须 >= initialSize && >=1）
-func NewAutoGrowEnginePool(initialSize, maxSize int, typ Type) (*AutoGrowEnginePool, error) {
+// opts: 创建每个 Engine 时使用的选项，按需扩容时同样生效
+func NewAutoGrowEnginePool(initialSize, maxSize int, typ Type, opts ...Option) (*AutoGrowEnginePool, error) {
 	if maxSize < 1 || initialSize < 0 || initialSize > maxSize {
 		return nil, fmt.Errorf("invalid sizes: initial=%d max=%d", initialSize, maxSize)
 	}
@@ -33,6 +37,7 @@ func NewAutoGrowEnginePool(initialSize, maxSize int, typ Type) (*AutoGrowEngineP
 	p := &AutoGrowEnginePool{
 		pool:  make(chan Engine, maxSize), // 通道容量设为 maxSize
 		typ:   typ,
+		opts:  opts,
 		total: 0,
 		max:   maxSize,
 	}
@@ -40,7 +45,7 @@ func NewAutoGrowEnginePool(initialSize, maxSize int, typ Type) (*AutoGrowEngineP
 	// 先全部创建并初始化到切片中，失败时统一清理
 	created := make([]Engine, 0, initialSize)
 	for i := 0; i < initialSize; i++ {
-		eng, err := NewScriptEngine(typ)
+		eng, err := NewScriptEngine(typ, opts...)
 		if err != nil {
 			for _, e := range created {
 				_ = e.Close()
@@ -73,14 +78,14 @@ func (p *AutoGrowEnginePool) Acquire() (Engine, error) {
 	p.mu.Lock()
 	if p.closed {
 		p.mu.Unlock()
-		return nil, errors.New("script engine: engine pool closed")
+		return nil, ErrPoolClosed
 	}
 	p.mu.Unlock()
 
 	// 尝试立即取一个空闲实例
 	select {
 	case eng := <-p.pool:
-		return eng, nil
+		return p.syncModules(eng)
 	default:
 	}
 
@@ -89,7 +94,7 @@ func (p *AutoGrowEnginePool) Acquire() (Engine, error) {
 	if p.total < p.max {
 		p.total++
 		p.mu.Unlock()
-		eng, err := NewScriptEngine(p.typ)
+		eng, err := NewScriptEngine(p.typ, p.opts...)
 		if err != nil {
 			// 创建失败，回退计数
 			p.mu.Lock()
@@ -107,16 +112,25 @@ func (p *AutoGrowEnginePool) Acquire() (Engine, error) {
 			return nil, initErr
 		}
 
-		return eng, nil
+		return p.syncModules(eng)
 	}
 	// 已到上限，必须阻塞等待空闲实例
 	p.mu.Unlock()
 
 	eng, ok := <-p.pool
 	if !ok {
-		return nil, errors.New("script engine: engine pool closed")
+		return nil, ErrPoolClosed
 	}
 
+	return p.syncModules(eng)
+}
+
+// syncModules 为取出的 eng 补充注册 RegisterModule 注册的模块，失败时归还 eng
+func (p *AutoGrowEnginePool) syncModules(eng Engine) (Engine, error) {
+	if err := p.modules.sync(eng); err != nil {
+		p.Release(eng)
+		return nil, err
+	}
 	return eng, nil
 }
 
@@ -130,7 +144,7 @@ func (p *AutoGrowEnginePool) Release(e Engine) {
 	p.mu.Unlock()
 
 	if closed {
-		_ = e.Close()
+		_ = p.modules.closeEngine(e)
 		// 可选：根据语义决定是否在这里调整 total
 		return
 	}
@@ -138,7 +152,7 @@ func (p *AutoGrowEnginePool) Release(e Engine) {
 	// 捕获 send-on-closed 的 panic，发生时安全关闭并尝试调整计数
 	defer func() {
 		if r := recover(); r != nil {
-			_ = e.Close()
+			_ = p.modules.closeEngine(e)
 			p.mu.Lock()
 			if p.total > 0 {
 				p.total--
@@ -150,7 +164,7 @@ func (p *AutoGrowEnginePool) Release(e Engine) {
 	select {
 	case p.pool <- e:
 	default:
-		_ = e.Close()
+		_ = p.modules.closeEngine(e)
 		p.mu.Lock()
 		if p.total > 0 {
 			p.total--
@@ -172,7 +186,7 @@ func (p *AutoGrowEnginePool) Close() error {
 
 	var lastErr error
 	for eng := range p.pool {
-		if err := eng.Close(); err != nil {
+		if err := p.modules.closeEngine(eng); err != nil {
 			lastErr = err
 		}
 	}
@@ -242,6 +256,15 @@ func (p *AutoGrowEnginePool) ExecuteString(ctx context.Context, source string) (
 	return eng.ExecuteString(ctx, source)
 }
 
+func (p *AutoGrowEnginePool) ExecuteStringWithOptions(ctx context.Context, source string, opts ExecuteOptions) (any, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release(eng)
+	return eng.ExecuteStringWithOptions(ctx, source, opts)
+}
+
 func (p *AutoGrowEnginePool) ExecuteFile(ctx context.Context, filePath string) (any, error) {
 	eng, err := p.Acquire()
 	if err != nil {
@@ -305,13 +328,54 @@ func (p *AutoGrowEnginePool) CallFunction(ctx context.Context, name string, args
 	return eng.CallFunction(ctx, name, args...)
 }
 
+func (p *AutoGrowEnginePool) CallFunctionWithOptions(ctx context.Context, name string, opts ExecuteOptions, args ...any) (any, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release(eng)
+	return eng.CallFunctionWithOptions(ctx, name, opts, args...)
+}
+
+func (p *AutoGrowEnginePool) CallFunctionMulti(ctx context.Context, name string, args ...any) (CallResult, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return CallResult{}, err
+	}
+	defer p.Release(eng)
+	return eng.CallFunctionMulti(ctx, name, args...)
+}
+
+func (p *AutoGrowEnginePool) InspectFunction(name string) (FunctionSignature, error) {
+	eng, err := p.Acquire()
+	if err != nil {
+		return FunctionSignature{}, err
+	}
+	defer p.Release(eng)
+	return eng.InspectFunction(name)
+}
+
+// RegisterModule 将模块注册到池中的所有引擎：先注册到一个引擎以检查模块，
+// 其余引擎（包括已借出与之后按需创建的引擎）在被取出时注册。
 func (p *AutoGrowEnginePool) RegisterModule(name string, module any) error {
 	eng, err := p.Acquire()
 	if err != nil {
 		return err
 	}
 	defer p.Release(eng)
-	return eng.RegisterModule(name, module)
+	if err = eng.RegisterModule(name, module); err != nil {
+		return err
+	}
+	p.modules.add(eng, name, module)
+	return nil
+}
+
+// RegisterNativeModule 以 module.Name 将 module 注册到池中的所有引擎，参见 RegisterModule。
+func (p *AutoGrowEnginePool) RegisterNativeModule(module *NativeModule) error {
+	if err := module.Validate(); err != nil {
+		return err
+	}
+	return p.RegisterModule(module.Name, module)
 }
 
 func (p *AutoGrowEnginePool) GetLastError() error {
@@ -0,0 +1,53 @@
+package script_engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// acquireAll 依次取出池中的 n 个引擎，再全部放回
+func acquireAll(t *testing.T, p *EnginePool, n int) []*fakeEngine {
+	engines := make([]*fakeEngine, 0, n)
+	for i := 0; i < n; i++ {
+		eng, err := p.Acquire()
+		assert.Nil(t, err)
+		engines = append(engines, eng.(*fakeEngine))
+	}
+	for _, eng := range engines {
+		p.Release(eng)
+	}
+	return engines
+}
+
+func TestEnginePoolModules(t *testing.T) {
+	const size = 4
+
+	p, err := NewEnginePool(size, fakeType)
+	assert.Nil(t, err)
+	defer p.Close()
+
+	// 借出的引擎在下次取出时补充注册，每个引擎的每个模块只注册一次
+	borrowed, err := p.Acquire()
+	assert.Nil(t, err)
+	assert.Nil(t, p.RegisterModule("a", testModule("a")))
+	p.Release(borrowed)
+	assert.Nil(t, p.RegisterNativeModule(testModule("b")))
+	assert.Nil(t, p.RegisterModule("c", testModule("c")))
+
+	engines := acquireAll(t, p, size)
+	seen := make(map[*fakeEngine]bool)
+	for _, eng := range engines {
+		seen[eng] = true
+		assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, eng.registered())
+	}
+	assert.Len(t, seen, size)
+
+	// 再次取出不会重复注册
+	for _, eng := range acquireAll(t, p, size) {
+		assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, eng.registered())
+	}
+
+	// 无效的模块不注册
+	assert.ErrorIs(t, p.RegisterNativeModule(&NativeModule{}), ErrInvalidModule)
+}
@@ -0,0 +1,207 @@
+package script_engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 各引擎共用的错误。引擎自身定义的错误包装这些错误，可通过 errors.Is 统一判断。
+var (
+	// ErrNotInitialized 引擎未初始化错误
+	ErrNotInitialized = errors.New("not initialized")
+
+	// ErrAlreadyInitialized 引擎已初始化错误
+	ErrAlreadyInitialized = errors.New("already initialized")
+
+	// ErrFunctionNotFound 调用的函数不存在
+	ErrFunctionNotFound = errors.New("function not found")
+
+	// ErrNotAFunction 调用的全局变量不是函数
+	ErrNotAFunction = errors.New("not a function")
+
+	// ErrArityMismatch Go 函数的参数个数与脚本函数的参数个数不符
+	ErrArityMismatch = errors.New("function arity mismatch")
+
+	// ErrNoProgramLoaded 没有已加载的脚本
+	ErrNoProgramLoaded = errors.New("no program loaded")
+
+	// ErrTimeout 脚本执行超时
+	ErrTimeout = errors.New("script execution timed out")
+
+	// ErrCancelled 脚本执行被取消
+	ErrCancelled = errors.New("script execution cancelled")
+
+	// ErrPoolClosed 引擎池已关闭
+	ErrPoolClosed = errors.New("engine pool closed")
+
+	// ErrBudgetExceeded 脚本执行预算耗尽错误
+	ErrBudgetExceeded = errors.New("script execution budget exceeded")
+
+	// ErrInvalidModule NativeModule 的定义无效
+	ErrInvalidModule = errors.New("invalid native module")
+)
+
+// BudgetExceededError 脚本执行预算耗尽时返回的错误，记录预算上限与已使用的预算。
+// 可通过 errors.Is(err, ErrBudgetExceeded) 判断。
+type BudgetExceededError struct {
+	// Limit 预算上限
+	Limit int64
+	// Used 中止时已使用的预算
+	Used int64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s: used %d of %d", ErrBudgetExceeded.Error(), e.Used, e.Limit)
+}
+
+// Is 使 errors.Is(err, ErrBudgetExceeded) 成立
+func (e *BudgetExceededError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// ErrorCoder 由带错误码的错误实现。宿主函数返回的错误链中存在 ErrorCoder 时，脚本捕获的异常带有对应的 code 字段
+type ErrorCoder interface {
+	ErrorCode() string
+}
+
+// ErrorCode 返回 err 的错误链中第一个 ErrorCoder 的错误码，没有时返回空字符串
+func ErrorCode(err error) string {
+	var coder ErrorCoder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode()
+	}
+	return ""
+}
+
+// ThrownError 脚本抛出且未被捕获的值，作为运行时错误的 ScriptError.Cause 返回：
+//   - 抛出的是 table 或对象时，Code 与 Message 取其 code 与 message 字段，Value 为其转换得到的 Go 值；
+//   - 抛出的是其他值时，Message 为其字符串形式；
+//   - 抛出的是宿主函数返回的错误，或通过 cause 字段包装了该错误时，Cause 为原始的 Go 错误，
+//     因此 errors.As、errors.Is 可以穿过脚本取回原始错误。
+//
+// 宿主函数返回的错误在脚本中是可捕获的异常，其 message 为 err.Error()，code 为 ErrorCode(err)，
+// cause 为 errors.Unwrap(err) 对应的异常，value 承载原始错误。
+type ThrownError struct {
+	// Code 错误码，没有时为空
+	Code string
+	// Message 错误消息
+	Message string
+	// Value 抛出的值转换得到的 Go 值
+	Value any
+	// Cause 抛出的值所承载的原始 Go 错误，没有时为 nil
+	Cause error
+}
+
+func (e *ThrownError) Error() string {
+	if e.Code != "" {
+		return e.Code + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (e *ThrownError) Unwrap() error {
+	return e.Cause
+}
+
+// ErrorCode 返回抛出的值中的错误码
+func (e *ThrownError) ErrorCode() string {
+	return e.Code
+}
+
+// Decode 将抛出的值解码到 out 指向的 Go 值，规则与 Decode 相同
+func (e *ThrownError) Decode(out any) error {
+	return Decode(e.Value, out)
+}
+
+// ErrorKind 脚本错误类别
+type ErrorKind string
+
+const (
+	// ErrorKindCompile 编译（语法）错误
+	ErrorKindCompile ErrorKind = "compile"
+	// ErrorKindRuntime 脚本运行时抛出的错误
+	ErrorKindRuntime ErrorKind = "runtime"
+	// ErrorKindTimeout 执行超时、被取消或预算耗尽
+	ErrorKindTimeout ErrorKind = "timeout"
+	// ErrorKindHost 宿主（Go）侧产生的错误，例如 Go 函数返回的错误、函数不存在、引擎未初始化
+	ErrorKindHost ErrorKind = "host"
+)
+
+// StackFrame 脚本调用栈中的一帧
+type StackFrame struct {
+	// Function 函数名，无法确定时为空
+	Function string
+	// File 代码块或文件名
+	File string
+	// Line 行号，从 1 开始；0 表示未知
+	Line int
+	// Column 列号，从 1 开始；0 表示未知
+	Column int
+}
+
+// ScriptError 脚本引擎返回的结构化错误。
+// 引擎方法（初始化、关闭、注册全局变量、函数与模块、加载、执行与调用脚本、检查函数）返回的错误均为 *ScriptError，
+// 可通过 errors.As 获取；宿主侧产生的错误（例如引擎未初始化、Go 值无法转换）的类别为 ErrorKindHost。
+// 原始错误保存在 Cause 中，errors.Is 可继续匹配 ErrNotInitialized、context.DeadlineExceeded、ErrBudgetExceeded 等错误。
+type ScriptError struct {
+	// Engine 产生错误的引擎类型
+	Engine Type
+	// Kind 错误类别
+	Kind ErrorKind
+	// File 出错的代码块或文件名
+	File string
+	// Line 出错的行号，从 1 开始；0 表示未知
+	Line int
+	// Column 出错的列号，从 1 开始；0 表示未知
+	Column int
+	// Message 不含位置信息的错误消息
+	Message string
+	// Stack 出错时的脚本调用栈，最内层的帧在前
+	Stack []StackFrame
+	// Cause 引擎原始错误
+	Cause error
+}
+
+func (e *ScriptError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s error", e.Engine, e.Kind)
+	if loc := e.Location(); loc != "" {
+		b.WriteString(" at ")
+		b.WriteString(loc)
+	}
+	if e.Message != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Message)
+	}
+	return b.String()
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Cause
+}
+
+// Is 使因 ctx 超时或取消而中止的错误可以通过 ErrTimeout、ErrCancelled 判断
+func (e *ScriptError) Is(target error) bool {
+	switch target {
+	case ErrTimeout:
+		return errors.Is(e.Cause, context.DeadlineExceeded)
+	case ErrCancelled:
+		return errors.Is(e.Cause, context.Canceled)
+	}
+	return false
+}
+
+// Location 以 file:line:column 的形式返回出错位置，未知的部分被省略
+func (e *ScriptError) Location() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			loc += ":" + strconv.Itoa(e.Column)
+		}
+	}
+	return loc
+}
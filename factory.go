@@ -5,8 +5,8 @@ import (
 	"sync"
 )
 
-// FactoryFunc 是用于创建 Engine 实例的工厂函数类型。
-type FactoryFunc func() (Engine, error)
+// FactoryFunc 是用于创建 Engine 实例的工厂函数类型，opts 为引擎创建选项。
+type FactoryFunc func(opts ...Option) (Engine, error)
 
 var (
 	factoryMu sync.RWMutex
@@ -14,12 +14,12 @@ var (
 )
 
 // NewScriptEngine 使用已注册的工厂函数创建一个 Engine 实例。
-func NewScriptEngine(typ Type) (Engine, error) {
+func NewScriptEngine(typ Type, opts ...Option) (Engine, error) {
 	f, ok := GetFactory(typ)
 	if !ok {
 		return nil, fmt.Errorf("script engine factory %s not registered", typ)
 	}
-	return f()
+	return f(opts...)
 }
 
 // Register registers a FactoryFunc for a given Type.
@@ -5,6 +5,27 @@ import (
 	"io"
 )
 
+// GlobalGetter is implemented by engines and engine pools that can read global variables
+type GlobalGetter interface {
+	// GetGlobal get a global variable
+	GetGlobal(name string) (any, error)
+}
+
+// FunctionCaller is implemented by engines and engine pools that can call script functions
+type FunctionCaller interface {
+	// CallFunction call a function with the given name and arguments
+	CallFunction(ctx context.Context, name string, args ...any) (any, error)
+}
+
+// FunctionBinder is implemented by engines and engine pools whose script functions can be bound to Go functions
+type FunctionBinder interface {
+	FunctionCaller
+	// CallFunctionMulti call a function with the given name and arguments, returning all of its results
+	CallFunctionMulti(ctx context.Context, name string, args ...any) (CallResult, error)
+	// InspectFunction describe the parameters of the global function with the given name
+	InspectFunction(name string) (FunctionSignature, error)
+}
+
 // Engine Define the interface for script engines
 type Engine interface {
 	// GetType get the type of the script engine
@@ -48,6 +69,8 @@ type Engine interface {
 	ExecuteFiles(ctx context.Context, filePaths []string) ([]any, error)
 	// ExecuteString execute script from string source
 	ExecuteString(ctx context.Context, source string) (any, error)
+	// ExecuteStringWithOptions execute script from string source, applying opts to this execution only
+	ExecuteStringWithOptions(ctx context.Context, source string, opts ExecuteOptions) (any, error)
 	// ExecuteFile execute script from file path
 	ExecuteFile(ctx context.Context, filePath string) (any, error)
 
@@ -68,12 +91,20 @@ type Engine interface {
 	RegisterFunction(name string, fn any) error
 	// CallFunction call a function with the given name and arguments
 	CallFunction(ctx context.Context, name string, args ...any) (any, error)
+	// CallFunctionWithOptions call a function with the given name and arguments, applying opts to this call only
+	CallFunctionWithOptions(ctx context.Context, name string, opts ExecuteOptions, args ...any) (any, error)
+	// CallFunctionMulti call a function with the given name and arguments, returning all of its results
+	CallFunctionMulti(ctx context.Context, name string, args ...any) (CallResult, error)
+	// InspectFunction describe the parameters of the global function with the given name
+	InspectFunction(name string) (FunctionSignature, error)
 
 	//////////////////////////////////////////////////////////////////////////////////////////
 	// Module Management
 	//////////////////////////////////////////////////////////////////////////////////////////
 
-	// RegisterModule register a module with the given name
+	// RegisterModule register a module with the given name.
+	// A module is a named set of Go functions and values, e.g. a map[string]any or a struct;
+	// scripts load it with require(name) instead of finding it in the global namespace.
 	RegisterModule(name string, module any) error
 
 	//////////////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,219 @@
+package js
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/unistring"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+const (
+	// budgetFunctionName 预算计量函数的全局名称，插入计量代码的脚本中不允许出现同名标识符
+	budgetFunctionName = "__scriptEngineBudget__"
+
+	// moduleFunctionName 返回已插入计量代码的模块函数的全局名称，require 加载的模块通过它执行
+	moduleFunctionName = "__scriptEngineModule__"
+)
+
+// astPkgPath goja AST 节点所在的包路径
+var astPkgPath = reflect.TypeOf(ast.Program{}).PkgPath()
+
+// budget 单次执行的预算计数器
+type budget struct {
+	limit int64
+	used  int64
+}
+
+// compileProgram 编译脚本，并在循环体与函数体开头插入预算计量调用，脚本每次循环迭代或函数调用消耗一个单位的预算。
+// 所有脚本都插入计量代码，之后任何一次执行设置的预算都对其生效；没有预算的执行中计量调用不做任何事。
+// 注意：通过 eval 或 Function 构造函数动态生成的代码不计量。
+func compileProgram(name, source string, strict bool) (*goja.Program, error) {
+	prg, err := goja.Parse(name, source)
+	if err != nil {
+		return nil, err
+	}
+	if err = instrumentBudget(prg); err != nil {
+		return nil, err
+	}
+	return goja.CompileAST(prg, strict)
+}
+
+// instrumentBudget 遍历 AST，在每个循环体与函数体开头插入预算计量调用
+func instrumentBudget(prg *ast.Program) error {
+	visited := make(map[any]bool)
+
+	var walk func(v reflect.Value) error
+	walk = func(v reflect.Value) error {
+		switch v.Kind() {
+		case reflect.Interface:
+			if v.IsNil() {
+				return nil
+			}
+			return walk(v.Elem())
+
+		case reflect.Ptr:
+			if v.IsNil() || v.Type().Elem().Kind() != reflect.Struct || v.Type().Elem().PkgPath() != astPkgPath {
+				return nil
+			}
+			if visited[v.Interface()] {
+				return nil
+			}
+			visited[v.Interface()] = true
+
+			if id, ok := v.Interface().(*ast.Identifier); ok && (id.Name == budgetFunctionName || id.Name == moduleFunctionName) {
+				return &goja.CompilerSyntaxError{CompilerError: goja.CompilerError{
+					Message: fmt.Sprintf("identifier %s is reserved", id.Name),
+					File:    prg.File,
+					Offset:  int(id.Idx) - 1,
+				}}
+			}
+			instrumentNode(v.Interface(), visited)
+			return walk(v.Elem())
+
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if !v.Type().Field(i).IsExported() {
+					continue
+				}
+				if err := walk(v.Field(i)); err != nil {
+					return err
+				}
+			}
+
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				if err := walk(v.Index(i)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	return walk(reflect.ValueOf(prg))
+}
+
+// instrumentNode 为循环与函数节点插入预算计量调用，插入的节点记入 visited 以免被误判为脚本中的标识符
+func instrumentNode(node any, visited map[any]bool) {
+	call := func(idx file.Idx) ast.Statement {
+		callee := &ast.Identifier{Name: unistring.String(budgetFunctionName), Idx: idx}
+		visited[callee] = true
+		return &ast.ExpressionStatement{Expression: &ast.CallExpression{
+			Callee:           callee,
+			LeftParenthesis:  idx,
+			RightParenthesis: idx,
+		}}
+	}
+
+	loopBody := func(body ast.Statement, idx file.Idx) ast.Statement {
+		return &ast.BlockStatement{
+			LeftBrace:  body.Idx0(),
+			List:       []ast.Statement{call(idx), body},
+			RightBrace: body.Idx1(),
+		}
+	}
+
+	funcBody := func(body *ast.BlockStatement, idx file.Idx) {
+		// 保留 "use strict" 等指令序言
+		i := 0
+		for ; i < len(body.List); i++ {
+			stmt, ok := body.List[i].(*ast.ExpressionStatement)
+			if !ok {
+				break
+			}
+			if _, ok = stmt.Expression.(*ast.StringLiteral); !ok {
+				break
+			}
+		}
+		list := make([]ast.Statement, 0, len(body.List)+1)
+		list = append(list, body.List[:i]...)
+		list = append(list, call(idx))
+		body.List = append(list, body.List[i:]...)
+	}
+
+	switch n := node.(type) {
+	case *ast.ForStatement:
+		n.Body = loopBody(n.Body, n.For)
+	case *ast.ForInStatement:
+		n.Body = loopBody(n.Body, n.For)
+	case *ast.ForOfStatement:
+		n.Body = loopBody(n.Body, n.For)
+	case *ast.WhileStatement:
+		n.Body = loopBody(n.Body, n.While)
+	case *ast.DoWhileStatement:
+		n.Body = loopBody(n.Body, n.Do)
+	case *ast.FunctionLiteral:
+		if n.Body != nil {
+			funcBody(n.Body, n.Function)
+		}
+	case *ast.ArrowFunctionLiteral:
+		switch body := n.Body.(type) {
+		case *ast.BlockStatement:
+			funcBody(body, n.Start)
+		case *ast.ExpressionBody:
+			n.Body = &ast.BlockStatement{
+				LeftBrace: body.Expression.Idx0(),
+				List: []ast.Statement{
+					call(n.Start),
+					&ast.ReturnStatement{Return: body.Expression.Idx0(), Argument: body.Expression},
+				},
+				RightBrace: body.Expression.Idx1(),
+			}
+		}
+	}
+}
+
+// consume 消耗一个单位的预算，预算耗尽时中断 runtime
+func (b *budget) consume(rt *goja.Runtime) {
+	b.used++
+	if b.used > b.limit {
+		rt.Interrupt(&scriptEngine.BudgetExceededError{Limit: b.limit, Used: b.used})
+	}
+}
+
+// defineBudget 在 runtime 中定义计量函数与模块函数，在 Init 中调用
+func (e *engine) defineBudget(rt *goja.Runtime) {
+	_ = rt.GlobalObject().DefineDataProperty(budgetFunctionName, rt.ToValue(func(goja.FunctionCall) goja.Value {
+		// 脚本运行期间调用方持有 execMu
+		if e.budget != nil {
+			e.budget.consume(rt)
+		}
+		return goja.Undefined()
+	}), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+
+	_ = rt.GlobalObject().DefineDataProperty(moduleFunctionName, rt.ToValue(func(call goja.FunctionCall) goja.Value {
+		prg, ok := e.modulePrograms[call.Argument(0).String()]
+		if !ok {
+			panic(rt.NewTypeError("module %s is not loaded", call.Argument(0).String()))
+		}
+		fn, err := rt.RunProgram(prg)
+		if err != nil {
+			panic(err)
+		}
+		return fn
+	}), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE)
+}
+
+// instrumentModule 为 require 加载的模块文件 p 插入计量代码。
+// 模块注册表自行编译模块源码，因此这里编译插入了计量代码的模块函数，并返回通过模块函数执行它的源码。调用方需持有 runtime。
+func (e *engine) instrumentModule(p string, source []byte) ([]byte, error) {
+	if path.Ext(p) == ".json" {
+		return source, nil
+	}
+
+	// 与模块注册表相同的包装函数，源码的行号保持不变
+	code := "(function(exports,require,module,__filename,__dirname){" + string(source) + "\n})"
+	prg, err := compileProgram(p, code, false)
+	if err != nil {
+		return nil, err
+	}
+	e.modulePrograms[p] = prg
+	return []byte("return " + moduleFunctionName + "(" + strconv.Quote(p) + ").apply(this, arguments)"), nil
+}
@@ -0,0 +1,44 @@
+package js
+
+import (
+	"context"
+
+	"github.com/dop251/goja"
+)
+
+// context 返回当前执行的 context，不在执行时返回 context.Background()。调用方需持有 runtime。
+func (e *engine) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// registerContext 注册全局对象 ctx，脚本通过它读取当前执行的 context：
+//   - ctx.value(key) 返回 ctx.Value(key)，包括 ExecuteOptions.Values 中的值，不存在时返回 undefined；
+//   - ctx.deadline() 返回截止时间的 Unix 毫秒数（整数，与 Lua 引擎相同），没有截止时间时返回 null；
+//   - ctx.err() 返回 context 结束的原因，尚未结束时返回 null。
+func (e *engine) registerContext(rt *goja.Runtime) {
+	obj := rt.NewObject()
+	_ = obj.Set("value", func(call goja.FunctionCall) goja.Value {
+		v := e.context().Value(call.Argument(0).String())
+		if v == nil {
+			return goja.Undefined()
+		}
+		return e.toValue(rt, v)
+	})
+	_ = obj.Set("deadline", func(goja.FunctionCall) goja.Value {
+		deadline, ok := e.context().Deadline()
+		if !ok {
+			return goja.Null()
+		}
+		return rt.ToValue(deadline.UnixMilli())
+	})
+	_ = obj.Set("err", func(goja.FunctionCall) goja.Value {
+		if err := e.context().Err(); err != nil {
+			return rt.ToValue(err.Error())
+		}
+		return goja.Null()
+	})
+	_ = rt.Set("ctx", obj)
+}
@@ -0,0 +1,474 @@
+package js
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+
+	"github.com/dop251/goja"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+var (
+	durationType     = reflect.TypeOf(time.Duration(0))
+	structObjectType = reflect.TypeOf((*structObject)(nil))
+	valueType        = reflect.TypeOf((*goja.Value)(nil)).Elem()
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+	contextType      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	gojaPkgPath      = valueType.PkgPath()
+)
+
+// toValue 将 Go 值转换为 JavaScript 值。
+// []byte 转换为与其共享数据的 Uint8Array；Int64Mode 不为 Int64AsNumber 时 int64、uint64 转换为 BigInt 或十进制字符串。
+// 包含这些值的 struct 与 struct 指针包装为读取字段时才转换的对象（见 structObject），脚本对字段的写入写回 struct；
+// 包含这些值的 slice、map 复制为数组与对象；参数或返回值可能包含这些值的 Go 函数
+// 包装为按同样规则转换参数与返回值的函数，其他值交给 goja 转换。调用方需持有 runtime。
+func (e *engine) toValue(rt *goja.Runtime, v any) goja.Value {
+	return e.convertValue(rt, reflect.ValueOf(v), make(map[uintptr]bool))
+}
+
+// convertValue 按 toValue 的规则转换 rv，visiting 记录正在复制的引用，遇到循环引用时交给 goja 转换
+func (e *engine) convertValue(rt *goja.Runtime, rv reflect.Value, visiting map[uintptr]bool) goja.Value {
+	if !rv.IsValid() {
+		return goja.Null()
+	}
+	if !e.needsConversion(rv, maxConversionDepth) {
+		return rt.ToValue(rv.Interface())
+	}
+
+	switch rv.Kind() {
+	case reflect.Int64:
+		return e.int64Value(rt, rv.Int())
+	case reflect.Uint64:
+		return e.int64Value(rt, rv.Uint())
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return goja.Null()
+		}
+		return e.convertValue(rt, rv.Elem(), visiting)
+
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if rv.IsNil() {
+			return goja.Null()
+		}
+		if isBytes(rv.Type()) {
+			return bytesValue(rt, rv.Bytes())
+		}
+		if rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Struct {
+			return e.newStructObject(rt, rv)
+		}
+		ptr := rv.Pointer()
+		if visiting[ptr] {
+			return rt.ToValue(rv.Interface())
+		}
+		visiting[ptr] = true
+		defer delete(visiting, ptr)
+
+		switch rv.Kind() {
+		case reflect.Ptr:
+			return e.convertValue(rt, rv.Elem(), visiting)
+		case reflect.Slice:
+			return e.arrayToValue(rt, rv, visiting)
+		default:
+			obj := rt.NewObject()
+			iter := rv.MapRange()
+			for iter.Next() {
+				_ = obj.Set(fmt.Sprint(iter.Key().Interface()), e.convertValue(rt, iter.Value(), visiting))
+			}
+			return obj
+		}
+
+	case reflect.Array:
+		return e.arrayToValue(rt, rv, visiting)
+
+	case reflect.Struct:
+		return e.newStructObject(rt, rv)
+
+	case reflect.Func:
+		if rv.IsNil() {
+			return goja.Null()
+		}
+		return e.wrapFunction(rt, rv)
+	}
+
+	return rt.ToValue(rv.Interface())
+}
+
+// bytesValue 创建与 b 共享数据的 Uint8Array
+func bytesValue(rt *goja.Runtime, b []byte) goja.Value {
+	array, err := rt.New(rt.Get("Uint8Array"), rt.ToValue(rt.NewArrayBuffer(b)))
+	if err != nil {
+		panic(err)
+	}
+	return array
+}
+
+// int64Value 按 Int64Mode 将 64 位整数 v（int64 或 uint64）转换为 BigInt 或十进制字符串
+func (e *engine) int64Value(rt *goja.Runtime, v any) goja.Value {
+	var b big.Int
+	switch n := v.(type) {
+	case int64:
+		b.SetInt64(n)
+	case uint64:
+		b.SetUint64(n)
+	}
+
+	if e.options.Int64Mode == scriptEngine.Int64AsString {
+		return rt.ToValue(b.String())
+	}
+	return rt.ToValue(&b)
+}
+
+// arrayToValue 将 slice 或 array 复制为数组
+func (e *engine) arrayToValue(rt *goja.Runtime, rv reflect.Value, visiting map[uintptr]bool) goja.Value {
+	values := make([]any, rv.Len())
+	for i := range values {
+		values[i] = e.convertValue(rt, rv.Index(i), visiting)
+	}
+	return rt.NewArray(values...)
+}
+
+// structObject 以 goja.DynamicObject 暴露 struct 的导出字段与方法，字段名按 FieldNaming 转换，默认使用 Go 名称。
+// 读取字段时按 toValue 的规则转换，struct 类型的字段以其地址转换，写入字段时按 fromValue 的规则转换后写回 struct，
+// 因此经由 struct 指针转换的对象与 goja 的反射包装一样，脚本的修改对 Go 可见；struct 值写入其副本。
+// 与 goja 的反射包装相同，对象不能添加或删除属性。导出时得到原来的 struct 指针或修改后的副本。
+type structObject struct {
+	e  *engine
+	rt *goja.Runtime
+	// target 转换前的 struct 指针或 struct 副本，rv 为可寻址的 struct
+	target reflect.Value
+	rv     reflect.Value
+
+	names   []string
+	fields  map[string][]int
+	methods map[string]int
+}
+
+// newStructObject 将 struct 或 struct 指针 rv 包装为 structObject
+func (e *engine) newStructObject(rt *goja.Runtime, rv reflect.Value) goja.Value {
+	o := &structObject{e: e, rt: rt, target: rv, fields: make(map[string][]int), methods: make(map[string]int)}
+	if rv.Kind() == reflect.Ptr {
+		o.rv = rv.Elem()
+	} else {
+		o.rv = reflect.New(rv.Type()).Elem()
+		o.rv.Set(rv)
+		o.target = o.rv
+	}
+
+	naming := e.options.FieldNaming
+	if naming == scriptEngine.FieldNamingDefault {
+		naming = scriptEngine.FieldNamingGo
+	}
+
+	var addFields func(rv reflect.Value, index []int)
+	addFields = func(rv reflect.Value, index []int) {
+		for i := 0; i < rv.NumField(); i++ {
+			field := rv.Type().Field(i)
+			fieldIndex := append(index[:len(index):len(index)], i)
+			if field.Anonymous {
+				if fv := reflect.Indirect(rv.Field(i)); fv.Kind() == reflect.Struct {
+					addFields(fv, fieldIndex)
+					continue
+				}
+			}
+			name, ok := naming.FieldName(field)
+			if !ok || !field.IsExported() {
+				continue
+			}
+			if _, exists := o.fields[name]; !exists {
+				o.names = append(o.names, name)
+			}
+			o.fields[name] = fieldIndex
+		}
+	}
+	addFields(o.rv, nil)
+
+	for i := 0; i < o.target.NumMethod(); i++ {
+		o.methods[naming.MethodName(o.target.Type().Method(i).Name)] = i
+	}
+	return rt.NewDynamicObject(o)
+}
+
+// field 返回名为 key 的字段
+func (o *structObject) field(key string) (reflect.Value, bool) {
+	index, ok := o.fields[key]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	fv, err := o.rv.FieldByIndexErr(index)
+	return fv, err == nil
+}
+
+func (o *structObject) Get(key string) goja.Value {
+	if fv, ok := o.field(key); ok {
+		if fv.Kind() == reflect.Struct {
+			fv = fv.Addr()
+		}
+		return o.e.convertValue(o.rt, fv, make(map[uintptr]bool))
+	}
+	if i, ok := o.methods[key]; ok {
+		return o.e.convertValue(o.rt, o.target.Method(i), make(map[uintptr]bool))
+	}
+	return nil
+}
+
+func (o *structObject) Set(key string, val goja.Value) bool {
+	fv, ok := o.field(key)
+	if !ok {
+		return false
+	}
+	fv.Set(o.e.fromValue(o.rt, val, fv.Type()))
+	return true
+}
+
+func (o *structObject) Has(key string) bool {
+	if _, ok := o.fields[key]; ok {
+		return true
+	}
+	_, ok := o.methods[key]
+	return ok
+}
+
+func (o *structObject) Delete(string) bool {
+	return false
+}
+
+func (o *structObject) Keys() []string {
+	return o.names
+}
+
+// wrapFunction 将 Go 函数包装为 JavaScript 函数，参数通过 fromValue 转换，返回值通过 toValue 转换。
+// 第一个参数为 context.Context 时传入当前执行的 context，脚本的参数从第二个参数开始对应。
+// 与 goja 一致，最后一个返回值为 error 且不为 nil 时抛出异常，多个返回值以数组返回。
+func (e *engine) wrapFunction(rt *goja.Runtime, fn reflect.Value) goja.Value {
+	typ := fn.Type()
+	offset := 0
+	if typ.NumIn() > 0 && typ.In(0) == contextType {
+		offset = 1
+	}
+	return rt.ToValue(func(call goja.FunctionCall) goja.Value {
+		args := make([]reflect.Value, 0, len(call.Arguments)+offset)
+		if offset > 0 {
+			args = append(args, reflect.ValueOf(e.context()))
+		}
+		for i := offset; i < typ.NumIn(); i++ {
+			if typ.IsVariadic() && i == typ.NumIn()-1 {
+				for j := i - offset; j < len(call.Arguments); j++ {
+					args = append(args, e.fromValue(rt, call.Argument(j), typ.In(i).Elem()))
+				}
+				break
+			}
+			args = append(args, e.fromValue(rt, call.Argument(i-offset), typ.In(i)))
+		}
+
+		results := fn.Call(args)
+		if n := len(results); n > 0 && typ.Out(n-1) == errorType {
+			if err, _ := results[n-1].Interface().(error); err != nil {
+				panic(e.goError(rt, err))
+			}
+			results = results[:n-1]
+		}
+
+		switch len(results) {
+		case 0:
+			return goja.Undefined()
+		case 1:
+			return e.convertValue(rt, results[0], make(map[uintptr]bool))
+		}
+		values := make([]any, len(results))
+		for i, result := range results {
+			values[i] = e.convertValue(rt, result, make(map[uintptr]bool))
+		}
+		return rt.NewArray(values...)
+	})
+}
+
+// fromValue 将 JavaScript 值转换为 typ 类型的 Go 值，无法转换时抛出 TypeError。
+// 接口类型接收 exportValue 导出的值；Int64Mode 不为 Int64AsNumber 时，包含 64 位整数的类型
+// 通过 exportValue 与 Decode 转换，以接受 BigInt 与十进制字符串；其他类型由 goja 转换，
+// []byte 可以接收 Uint8Array 等类型化数组、ArrayBuffer 与字符串。
+func (e *engine) fromValue(rt *goja.Runtime, v goja.Value, typ reflect.Type) reflect.Value {
+	out := reflect.New(typ)
+
+	// 由 struct 转换的对象直接使用原来的 struct
+	if obj, ok := v.(*goja.Object); ok && obj.ExportType() == structObjectType {
+		switch target := obj.Export().(*structObject).target; {
+		case target.Type().AssignableTo(typ):
+			out.Elem().Set(target)
+			return out.Elem()
+		case target.Kind() == reflect.Ptr && target.Elem().Type().AssignableTo(typ):
+			out.Elem().Set(target.Elem())
+			return out.Elem()
+		}
+	}
+
+	var err error
+	switch {
+	case typ.Kind() == reflect.Interface && typ.NumMethod() == 0:
+		if exported := e.exportValue(v); exported != nil {
+			out.Elem().Set(reflect.ValueOf(exported))
+		}
+	case e.options.Int64Mode != scriptEngine.Int64AsNumber && e.convertible(typ, false, make(map[reflect.Type]bool)):
+		err = scriptEngine.Decode(e.exportValue(v), out.Interface())
+	default:
+		err = rt.ExportTo(v, out.Interface())
+	}
+	if err != nil {
+		panic(rt.NewTypeError(err.Error()))
+	}
+	return out.Elem()
+}
+
+// maxConversionDepth needsConversion 检查接口类型的值时的最大深度
+const maxConversionDepth = 32
+
+// needsConversion rv 是否需要由 convertValue 转换。类型上无法确定时检查接口类型的实际值，
+// 例如 map[string]any 中的 []byte
+func (e *engine) needsConversion(rv reflect.Value, depth int) bool {
+	typ := rv.Type()
+	if typ.Implements(valueType) || typ.PkgPath() == gojaPkgPath {
+		return false
+	}
+	if e.convertible(typ, false, make(map[reflect.Type]bool)) {
+		return true
+	}
+	if depth == 0 {
+		return false
+	}
+
+	switch rv.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return !rv.IsNil() && e.needsConversion(rv.Elem(), depth-1)
+	case reflect.Slice, reflect.Array:
+		if !isComposite(typ.Elem()) {
+			return false
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if e.needsConversion(rv.Index(i), depth-1) {
+				return true
+			}
+		}
+	case reflect.Map:
+		if !isComposite(typ.Elem()) {
+			return false
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			if e.needsConversion(iter.Value(), depth-1) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if field := typ.Field(i); (field.IsExported() || field.Anonymous) && isComposite(field.Type) &&
+				e.needsConversion(rv.Field(i), depth-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// convertible typ 的值是否一定需要转换：包含 []byte，或 Int64Mode 不为 Int64AsNumber 时包含 int64、uint64
+// （time.Duration 除外）。接口类型在 Int64Mode 不为 Int64AsNumber 或 inFunc 为 true（位于函数签名中）时视为需要转换。
+// goja 自身的类型（如 goja.Value、goja.FunctionCall）由 goja 处理。
+func (e *engine) convertible(typ reflect.Type, inFunc bool, seen map[reflect.Type]bool) bool {
+	if seen[typ] || typ == durationType || typ.PkgPath() == gojaPkgPath {
+		return false
+	}
+	seen[typ] = true
+
+	int64Safe := e.options.Int64Mode != scriptEngine.Int64AsNumber
+	switch typ.Kind() {
+	case reflect.Int64, reflect.Uint64:
+		return int64Safe
+	case reflect.Interface:
+		return int64Safe || inFunc
+	case reflect.Slice:
+		return isBytes(typ) || e.convertible(typ.Elem(), inFunc, seen)
+	case reflect.Ptr, reflect.Array:
+		return e.convertible(typ.Elem(), inFunc, seen)
+	case reflect.Map:
+		return e.convertible(typ.Key(), inFunc, seen) || e.convertible(typ.Elem(), inFunc, seen)
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if field := typ.Field(i); (field.IsExported() || field.Anonymous) && e.convertible(field.Type, inFunc, seen) {
+				return true
+			}
+		}
+	case reflect.Func:
+		for i := 0; i < typ.NumIn(); i++ {
+			if e.convertible(typ.In(i), true, seen) {
+				return true
+			}
+		}
+		for i := 0; i < typ.NumOut(); i++ {
+			if typ.Out(i) == errorType || e.convertible(typ.Out(i), true, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBytes typ 是否为字节切片
+func isBytes(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8
+}
+
+// isComposite typ 的值是否可能通过接口类型包含其他值
+func isComposite(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		return true
+	}
+	return false
+}
+
+// exportValue 将 JavaScript 值导出为 Go 值，ArrayBuffer 导出为共享数据的 []byte，Uint8Array 由 goja 导出为 []byte，
+// 由 struct 转换的对象导出为原来的 struct 指针或 struct 副本。
+// Int64Mode 为 Int64AsObject 时，能以 int64 或 uint64 表示的 BigInt 导出为 int64 或 uint64。
+func (e *engine) exportValue(v goja.Value) any {
+	return e.normalize(v.Export(), make(map[uintptr]bool))
+}
+
+// normalize 转换 value 及其中的 goja.ArrayBuffer 与 *big.Int，超出范围的 *big.Int 保持不变
+func (e *engine) normalize(value any, seen map[uintptr]bool) any {
+	switch v := value.(type) {
+	case goja.ArrayBuffer:
+		return v.Bytes()
+	case *structObject:
+		return v.target.Interface()
+	case *big.Int:
+		if e.options.Int64Mode != scriptEngine.Int64AsObject {
+			return v
+		}
+		if v.IsInt64() {
+			return v.Int64()
+		}
+		if v.IsUint64() {
+			return v.Uint64()
+		}
+	case []any:
+		if len(v) == 0 || seen[reflect.ValueOf(v).Pointer()] {
+			return v
+		}
+		seen[reflect.ValueOf(v).Pointer()] = true
+		for i := range v {
+			v[i] = e.normalize(v[i], seen)
+		}
+	case map[string]any:
+		if seen[reflect.ValueOf(v).Pointer()] {
+			return v
+		}
+		seen[reflect.ValueOf(v).Pointer()] = true
+		for k := range v {
+			v[k] = e.normalize(v[k], seen)
+		}
+	}
+	return value
+}
@@ -1,22 +1,226 @@
 package js
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/dop251/goja"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
 
 var (
 	// ErrJavascriptEngineNotInitialized JavaScript 引擎未初始化错误
-	ErrJavascriptEngineNotInitialized = errors.New("javascript engine not initialized")
+	ErrJavascriptEngineNotInitialized = fmt.Errorf("javascript engine %w", scriptEngine.ErrNotInitialized)
 
 	// ErrJavascriptEngineAlreadyInitialized JavaScript 引擎已初始化错误
-	ErrJavascriptEngineAlreadyInitialized = errors.New("javascript engine already initialized")
+	ErrJavascriptEngineAlreadyInitialized = fmt.Errorf("javascript engine %w", scriptEngine.ErrAlreadyInitialized)
 
 	// ErrJavascriptVMNotInitialized JavaScript 虚拟机未初始化错误
-	ErrJavascriptVMNotInitialized = errors.New("javascript VM not initialized")
+	ErrJavascriptVMNotInitialized = fmt.Errorf("javascript VM %w", scriptEngine.ErrNotInitialized)
 
 	ErrJavascriptCompileFailed = errors.New("javascript compile failed")
 
-	ErrJavascriptRuntimeNotInitialized = errors.New("javascript runtime not initialized")
+	ErrJavascriptRuntimeNotInitialized = fmt.Errorf("javascript runtime %w", scriptEngine.ErrNotInitialized)
 
 	ErrJavascriptExecutionFailed = errors.New("javascript execution failed")
 
-	ErrJavascriptNoProgramLoaded = errors.New("javascript no program loaded")
+	ErrJavascriptNoProgramLoaded = fmt.Errorf("javascript %w", scriptEngine.ErrNoProgramLoaded)
+
+	// ErrJavascriptFunctionNotFound JavaScript 函数不存在错误
+	ErrJavascriptFunctionNotFound = fmt.Errorf("javascript %w", scriptEngine.ErrFunctionNotFound)
+
+	// ErrJavascriptNotAFunction JavaScript 全局变量不是函数错误
+	ErrJavascriptNotAFunction = fmt.Errorf("javascript %w", scriptEngine.ErrNotAFunction)
+
+	// ErrJavascriptPromiseRejected 脚本返回的 Promise 被拒绝
+	ErrJavascriptPromiseRejected = errors.New("javascript promise rejected")
+
+	// ErrJavascriptPromisePending 事件循环结束时脚本返回的 Promise 仍未完成
+	ErrJavascriptPromisePending = errors.New("javascript promise still pending")
+
+	// ErrJavascriptIntervalPending 执行没有超时或可取消的 ctx，且只剩 setInterval 注册的定时器，事件循环不会自行结束
+	ErrJavascriptIntervalPending = errors.New("javascript interval pending without a deadline")
 )
+
+// newScriptError 将 goja 错误转换为 *scriptEngine.ScriptError，已转换的错误原样返回
+func newScriptError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var se *scriptEngine.ScriptError
+	if errors.As(err, &se) {
+		return err
+	}
+	return toScriptError(err)
+}
+
+// newTimeoutError 返回因 cause（ctx 结束或预算耗尽）而中止的错误，err 为 runtime 中断时产生的错误
+func newTimeoutError(cause, err error) error {
+	se := toScriptError(err)
+	se.Kind = scriptEngine.ErrorKindTimeout
+	se.Message = cause.Error()
+	se.Cause = cause
+	return se
+}
+
+// newRejectionError 返回 Promise 被拒绝的错误，消息为拒绝原因，
+// 原因同时匹配 ErrJavascriptPromiseRejected 与拒绝原因对应的 *scriptEngine.ThrownError。调用方需持有 runtime。
+func (e *engine) newRejectionError(reason goja.Value) error {
+	return &scriptEngine.ScriptError{
+		Engine:  scriptEngine.JavaScriptType,
+		Kind:    scriptEngine.ErrorKindRuntime,
+		Message: reason.String(),
+		Cause:   fmt.Errorf("%w: %w", ErrJavascriptPromiseRejected, e.thrownError(reason)),
+	}
+}
+
+// maxCauseDepth 查找异常承载的 Go 错误时沿 cause 字段查找的最大深度
+const maxCauseDepth = 16
+
+// goError 将宿主函数返回的错误转换为 GoError 异常对象：message 为 err.Error()，value 承载原始错误，
+// 错误码不为空时设置 code，包装了其他错误时 cause 为被包装的错误对应的异常对象。调用方需持有 runtime。
+func (e *engine) goError(rt *goja.Runtime, err error) *goja.Object {
+	obj := rt.NewGoError(err)
+	if code := scriptEngine.ErrorCode(err); code != "" {
+		_ = obj.Set("code", code)
+	}
+	if cause := errors.Unwrap(err); cause != nil {
+		_ = obj.Set("cause", e.goError(rt, cause))
+	}
+	return obj
+}
+
+// withThrown 将脚本抛出的异常转换为以 *scriptEngine.ThrownError 为原因的 *scriptEngine.ScriptError，
+// 其他错误原样返回。调用方需持有 runtime。
+func (e *engine) withThrown(err error) error {
+	var exception *goja.Exception
+	if !errors.As(err, &exception) {
+		return err
+	}
+	se := toScriptError(err)
+	se.Cause = e.thrownError(exception.Value())
+	return se
+}
+
+// thrownError 将脚本抛出的值转换为 *scriptEngine.ThrownError。
+// Error 对象的 message 不可枚举，导出为 map 时补充 message 字段，以便 Decode 读取。调用方需持有 runtime。
+func (e *engine) thrownError(v goja.Value) *scriptEngine.ThrownError {
+	thrown := &scriptEngine.ThrownError{Message: v.String(), Value: e.exportValue(v)}
+	obj, ok := v.(*goja.Object)
+	if !ok {
+		return thrown
+	}
+
+	if message := obj.Get("message"); message != nil && !goja.IsUndefined(message) {
+		thrown.Message = message.String()
+	}
+	if code := obj.Get("code"); code != nil && !goja.IsUndefined(code) && !goja.IsNull(code) {
+		thrown.Code = code.String()
+	}
+	if m, ok := thrown.Value.(map[string]any); ok {
+		if _, ok := m["message"]; !ok {
+			m["message"] = thrown.Message
+		}
+	}
+	thrown.Cause = hostError(obj)
+	return thrown
+}
+
+// hostError 返回异常对象 obj 或其 cause 链中的对象承载的 Go 错误，没有时返回 nil
+func hostError(obj *goja.Object) error {
+	for depth := 0; obj != nil && depth < maxCauseDepth; depth++ {
+		if v := obj.Get("value"); v != nil {
+			if err, ok := v.Export().(error); ok {
+				return err
+			}
+		}
+		obj, _ = obj.Get("cause").(*goja.Object)
+	}
+	return nil
+}
+
+// toScriptError 按 goja 错误的类型解析错误类别、出错位置与调用栈
+func toScriptError(err error) *scriptEngine.ScriptError {
+	se := &scriptEngine.ScriptError{
+		Engine:  scriptEngine.JavaScriptType,
+		Kind:    scriptEngine.ErrorKindHost,
+		Message: err.Error(),
+		Cause:   err,
+	}
+
+	var (
+		syntaxErr    *goja.CompilerSyntaxError
+		referenceErr *goja.CompilerReferenceError
+		interrupted  *goja.InterruptedError
+		exception    *goja.Exception
+	)
+	switch {
+	case errors.As(err, &syntaxErr):
+		se.Kind = scriptEngine.ErrorKindCompile
+		se.Message = syntaxErr.Message
+		setCompilerPosition(se, syntaxErr.CompilerError)
+
+	case errors.As(err, &referenceErr):
+		se.Kind = scriptEngine.ErrorKindCompile
+		se.Message = referenceErr.Message
+		setCompilerPosition(se, referenceErr.CompilerError)
+
+	case errors.As(err, &interrupted):
+		se.Kind = scriptEngine.ErrorKindTimeout
+		se.Message = fmt.Sprint(interrupted.Value())
+		setStack(se, interrupted.Stack())
+
+	case errors.As(err, &exception):
+		// Go 函数返回的错误以 GoError 的形式抛出
+		if exception.Unwrap() == nil {
+			se.Kind = scriptEngine.ErrorKindRuntime
+		}
+		se.Message = exception.Value().String()
+		setStack(se, exception.Stack())
+	}
+
+	return se
+}
+
+// parserErrorPattern 匹配语法分析错误的消息，例如 `(anonymous): Line 2:5 Unexpected token =`
+var parserErrorPattern = regexp.MustCompile(`(?s)^(.*?): Line (\d+):(\d+) (.*)$`)
+
+// setCompilerPosition 设置编译错误的出错位置。
+// 语法分析错误不携带偏移量，位置从错误消息中解析。
+func setCompilerPosition(se *scriptEngine.ScriptError, err goja.CompilerError) {
+	if err.File == nil {
+		if m := parserErrorPattern.FindStringSubmatch(err.Message); m != nil {
+			se.File = m[1]
+			se.Line, _ = strconv.Atoi(m[2])
+			se.Column, _ = strconv.Atoi(m[3])
+			se.Message = m[4]
+		}
+		return
+	}
+	pos := err.File.Position(err.Offset)
+	se.File = err.File.Name()
+	se.Line = pos.Line
+	se.Column = pos.Column
+}
+
+// setStack 设置调用栈，并以最内层脚本帧的位置作为出错位置
+func setStack(se *scriptEngine.ScriptError, stack []goja.StackFrame) {
+	for i := range stack {
+		pos := stack[i].Position()
+		se.Stack = append(se.Stack, scriptEngine.StackFrame{
+			Function: stack[i].FuncName(),
+			File:     stack[i].SrcName(),
+			Line:     pos.Line,
+			Column:   pos.Column,
+		})
+		if se.Line == 0 && pos.Line > 0 {
+			se.File = stack[i].SrcName()
+			se.Line = pos.Line
+			se.Column = pos.Column
+		}
+	}
+}
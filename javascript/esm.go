@@ -0,0 +1,1202 @@
+package js
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja/unistring"
+)
+
+// ES 模块在 goja 中以 CommonJS 模块的形式运行：transformModule 将 import、export 语句改写为 require 调用与
+// exports 上的 getter。goja 的语法分析器不支持模块语法，import、export 语句由它报告的语法错误定位：
+// 每找到一条语句就以等长的占位替换，直到源码可以解析，因此只有真正的 import、export 语句会被改写。
+// 导入的绑定改写为对导入模块的成员访问，与 ES 模块一样是活绑定；改写后的源码附带内联 source map，
+// 错误位置指向原始源码。
+// 与 ES 模块规范的差异：
+//   - 导入的模块按 import 语句的顺序在模块开头通过 require 加载，循环依赖按 CommonJS 的规则处理；
+//   - export default 导出的匿名函数与类不会提升；
+//   - 不支持顶层 await、import.meta 与导入属性，例如 import data from "./a.json" with { type: "json" }。
+
+// esmPrelude 模块开头的声明，单独占据改写后源码的第一行
+const esmPrelude = `"use strict";Object.defineProperty(exports,"__esModule",{value:true});`
+
+// esm 辅助函数，按需插入模块开头
+const (
+	esmDefaultHelper = `function __esmDefault(m){return m&&m.__esModule?m.default:m}`
+	esmStarHelper    = `function __esmStar(m){for(const k in m)if(k!=="default"&&!Object.prototype.hasOwnProperty.call(exports,k))Object.defineProperty(exports,k,{enumerable:true,get:()=>m[k]})}`
+	esmImportHelper  = `function __esmImport(s){return new Promise((r)=>r(require(s)))}`
+)
+
+// esmWrapper 解析模块源码时使用的包装函数，与模块注册表的包装函数相同，模块顶层因此可以 return
+const esmWrapper = "(function(exports,require,module,__filename,__dirname){"
+
+// importPlaceholder 动态 import() 在解析时的占位标识符，与 import 等长
+const importPlaceholder = "_mport"
+
+type tokenKind int
+
+const (
+	tokenPunct tokenKind = iota
+	tokenIdent
+	tokenString
+)
+
+// token 词法单元，text 为其在源码中的原文，源码结束时 text 为空
+type token struct {
+	kind       tokenKind
+	text       string
+	start, end int
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+// scanner 按需扫描 import、export 语句中的词法单元：标识符、字符串与标点，跳过空白与注释
+type scanner struct {
+	src string
+	pos int
+}
+
+func (s *scanner) next() token {
+	s.skipSpace()
+	start := s.pos
+	if s.pos >= len(s.src) {
+		return token{kind: tokenPunct, start: start, end: start}
+	}
+
+	switch c := s.src[s.pos]; {
+	case c == '\'' || c == '"':
+		for s.pos++; s.pos < len(s.src) && s.src[s.pos] != c && s.src[s.pos] != '\n'; s.pos++ {
+			if s.src[s.pos] == '\\' {
+				s.pos++
+			}
+		}
+		if s.pos < len(s.src) && s.src[s.pos] == c {
+			s.pos++
+			return token{kind: tokenString, text: s.src[start:s.pos], start: start, end: s.pos}
+		}
+		s.pos = start + 1
+	case isIdentStart(c) || c >= utf8.RuneSelf:
+		for s.pos < len(s.src) && (isIdentPart(s.src[s.pos]) || s.src[s.pos] >= utf8.RuneSelf) {
+			s.pos++
+		}
+		return token{kind: tokenIdent, text: s.src[start:s.pos], start: start, end: s.pos}
+	default:
+		s.pos++
+	}
+	return token{kind: tokenPunct, text: s.src[start:s.pos], start: start, end: s.pos}
+}
+
+// peek 返回下一个词法单元，不改变扫描位置
+func (s *scanner) peek() token {
+	pos := s.pos
+	t := s.next()
+	s.pos = pos
+	return t
+}
+
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f':
+			s.pos++
+		case strings.HasPrefix(s.src[s.pos:], "//"):
+			for s.pos < len(s.src) && s.src[s.pos] != '\n' {
+				s.pos++
+			}
+		case strings.HasPrefix(s.src[s.pos:], "/*"):
+			end := strings.Index(s.src[s.pos+2:], "*/")
+			if end < 0 {
+				s.pos = len(s.src)
+				return
+			}
+			s.pos += end + 4
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRuneInString(s.src[s.pos:])
+			if r != '\u00a0' && r != '\ufeff' && r != '\u2028' && r != '\u2029' {
+				return
+			}
+			s.pos += size
+		default:
+			return
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$' || c == '\\'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+// lineBreak 返回 src 中 i 处换行符的长度，不是换行符时返回 0，与 goja 计算行号的规则相同
+func lineBreak(src string, i int) int {
+	switch {
+	case src[i] == '\r' && i+1 < len(src) && src[i+1] == '\n':
+		return 2
+	case src[i] == '\r' || src[i] == '\n':
+		return 1
+	case strings.HasPrefix(src[i:], "\u2028") || strings.HasPrefix(src[i:], "\u2029"):
+		return 3
+	}
+	return 0
+}
+
+// position 返回 src 中 offset 处的行号与列号，均从 1 开始，列号按字节计算，与 goja 相同
+func position(src string, offset int) (line, column int) {
+	line, start := 1, 0
+	for i := 0; i < offset; {
+		if n := lineBreak(src, i); n > 0 {
+			i += n
+			line, start = line+1, i
+			continue
+		}
+		i++
+	}
+	return line, offset - start + 1
+}
+
+// offsetOf 返回 src 中第 line 行第 column 列的偏移量
+func offsetOf(src string, line, column int) int {
+	for i := 0; i < len(src) && line > 1; {
+		if n := lineBreak(src, i); n > 0 {
+			i += n
+			if line--; line == 1 {
+				return min(i+column-1, len(src))
+			}
+			continue
+		}
+		i++
+	}
+	return min(column-1, len(src))
+}
+
+// moduleSyntaxError 返回模块 name 的源码 offset 处的语法错误
+func moduleSyntaxError(name, src string, offset int, message string) error {
+	if name == "" {
+		name = "(anonymous)"
+	}
+	line, column := position(src, offset)
+	return &goja.CompilerSyntaxError{CompilerError: goja.CompilerError{
+		Message: fmt.Sprintf("%s: Line %d:%d %s", name, line, column, message),
+	}}
+}
+
+// edit 将原始源码 start 到 end 的内容替换为 code
+type edit struct {
+	start, end int
+	code       string
+}
+
+// exportDeclaration export 声明，names 在源码解析后从声明中取得
+type exportDeclaration struct {
+	offset    int // export 关键字的位置
+	start     int // 声明的起始位置不早于此
+	isDefault bool
+}
+
+// moduleRewriter 将 ES 模块的 import、export 语句改写为 CommonJS
+type moduleRewriter struct {
+	name     string
+	src      string
+	masked   []byte // 以占位替换了 import、export 语句的源码，偏移量与 src 相同
+	edits    []edit
+	spans    []edit                      // import 语句与不含声明的 export 语句，必须位于模块顶层
+	decls    []exportDeclaration         // export 声明
+	imports  map[unistring.String]string // 导入的绑定对应的表达式
+	requires []string                    // 模块开头加载导入模块的语句
+	exports  []string                    // 导出的名称，按声明顺序
+	seen     map[string]string           // 导出名对应的表达式，用于检测重复导出
+	helpers  map[string]bool             // 需要插入的辅助函数
+	temps    int
+	module   bool // 是否包含 import、export 语句
+}
+
+// esmModule 改写后的模块
+type esmModule struct {
+	code     string // 改写后的源码，第一行为模块开头的声明
+	mappings string // source map 的 mappings
+}
+
+// sourceMapComment 返回附带 source map 的注释，name 为模块名
+func (m *esmModule) sourceMapComment(name string) string {
+	sm := `{"version":3,"sources":[` + strconv.Quote(path.Base(name)) + `],"names":[],"mappings":"` + m.mappings + `"}`
+	return "//# sourceMappingURL=data:application/json;base64," + base64.StdEncoding.EncodeToString([]byte(sm))
+}
+
+// transformModule 将模块 name 的 ES 模块源码改写为在 require 的模块包装函数中运行的 CommonJS 源码，
+// 改写后的源码以内联 source map 结尾。不包含 import、export 语句与动态 import() 的源码原样返回，
+// CommonJS 模块因此不经过改写。
+func transformModule(name, source string) (string, error) {
+	m, err := rewriteModule(name, source, false)
+	if err != nil || m == nil {
+		return source, err
+	}
+	return m.code + "\n" + m.sourceMapComment(name), nil
+}
+
+// rewriteModule 改写模块 name 的源码，源码不需要改写且 force 为 false 时返回 nil
+func rewriteModule(name, source string, force bool) (*esmModule, error) {
+	w := &moduleRewriter{
+		name:    name,
+		src:     source,
+		masked:  []byte(source),
+		imports: make(map[unistring.String]string),
+		seen:    make(map[string]string),
+		helpers: make(map[string]bool),
+	}
+
+	fn, err := w.locate()
+	if err != nil {
+		return nil, err
+	}
+	if !w.module && len(w.helpers) == 0 && !force {
+		return nil, nil
+	}
+	if err = w.resolve(fn); err != nil {
+		return nil, err
+	}
+	return w.generate(), nil
+}
+
+// locate 反复解析源码，以占位替换语法错误处的 import、export 语句，返回可以解析时的模块函数
+func (w *moduleRewriter) locate() (*ast.FunctionLiteral, error) {
+	done := make(map[int]bool)
+	for {
+		prg, err := parser.ParseFile(nil, w.name, esmWrapper+string(w.masked)+"\n})", 0)
+		if err == nil {
+			return prg.Body[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral), nil
+		}
+
+		var list parser.ErrorList
+		if !errors.As(err, &list) || len(list) == 0 {
+			return nil, err
+		}
+		first := list[0]
+		offset := offsetOf(esmWrapper+string(w.masked), first.Position.Line, first.Position.Column) - len(esmWrapper)
+		if offset < 0 || offset > len(w.src) {
+			return nil, err
+		}
+
+		keyword := ""
+		for _, kw := range []string{"import", "export"} {
+			if strings.HasPrefix(string(w.masked[offset:]), kw) &&
+				(offset+len(kw) == len(w.src) || !isIdentPart(w.src[offset+len(kw)])) {
+				keyword = kw
+			}
+		}
+		if keyword == "" || done[offset] {
+			if w.awaitAt(offset) {
+				return nil, moduleSyntaxError(w.name, w.src, offset, "top-level await is not supported")
+			}
+			return nil, moduleSyntaxError(w.name, w.src, offset, first.Message)
+		}
+		done[offset] = true
+
+		if keyword == "import" {
+			err = w.rewriteImport(offset)
+		} else {
+			err = w.rewriteExport(offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// awaitAt 判断 offset 处的语法错误是否由 await 引起
+func (w *moduleRewriter) awaitAt(offset int) bool {
+	before := strings.TrimRight(string(w.masked[:offset]), " \t")
+	return strings.HasPrefix(string(w.masked[offset:]), "await") || strings.HasSuffix(before, "await")
+}
+
+// mask 以空白替换 start 到 end 的源码，保留其中的换行，并将其在改写后的源码中替换为 code
+func (w *moduleRewriter) mask(start, end int, code string) {
+	for i := start; i < end; i++ {
+		if w.masked[i] != '\n' && w.masked[i] != '\r' {
+			w.masked[i] = ' '
+		}
+	}
+	w.edits = append(w.edits, edit{start, end, code})
+}
+
+// placeholder 以等长的 text 替换 start 处的源码，并将其在改写后的源码中替换为 code
+func (w *moduleRewriter) placeholder(start int, text, code string) {
+	copy(w.masked[start:], text)
+	w.edits = append(w.edits, edit{start, start + len(text), code})
+}
+
+// expect 消费一个 kind 类型的词法单元，text 不为空时要求原文相同
+func (w *moduleRewriter) expect(s *scanner, kind tokenKind, text string) (token, error) {
+	t := s.next()
+	if t.kind != kind || text != "" && t.text != text || t.end == t.start {
+		if t.end == t.start {
+			return t, moduleSyntaxError(w.name, w.src, t.start, "Unexpected end of input")
+		}
+		return t, moduleSyntaxError(w.name, w.src, t.start, "Unexpected token "+t.text)
+	}
+	return t, nil
+}
+
+// moduleExportName 消费一个导入或导出的名称，名称可以是标识符或字符串
+func (w *moduleRewriter) moduleExportName(s *scanner) (string, error) {
+	if t := s.peek(); t.kind == tokenString {
+		s.next()
+		name, err := strconv.Unquote(`"` + strings.ReplaceAll(t.text[1:len(t.text)-1], `"`, `\"`) + `"`)
+		if err != nil {
+			return "", moduleSyntaxError(w.name, w.src, t.start, "Invalid module export name")
+		}
+		return name, nil
+	}
+	t, err := w.expect(s, tokenIdent, "")
+	return t.text, err
+}
+
+// endStatement 消费语句末尾可选的分号，以空白替换 start 开始的语句
+func (w *moduleRewriter) endStatement(s *scanner, start int) {
+	if s.peek().is(tokenPunct, ";") {
+		s.next()
+	}
+	w.mask(start, s.pos, "")
+	w.spans = append(w.spans, edit{start: start, end: s.pos})
+	w.module = true
+}
+
+// temp 返回新的临时变量名
+func (w *moduleRewriter) temp() string {
+	w.temps++
+	return "__esm" + strconv.Itoa(w.temps)
+}
+
+// require 在模块开头加载 spec，返回保存模块的临时变量
+func (w *moduleRewriter) require(spec string) string {
+	module := w.temp()
+	w.requires = append(w.requires, "const "+module+"=require("+spec+");")
+	return module
+}
+
+// export 以 getter 导出 expr，名称为 name
+func (w *moduleRewriter) export(name, expr string, offset int) error {
+	if _, ok := w.seen[name]; ok {
+		return moduleSyntaxError(w.name, w.src, offset, fmt.Sprintf("Duplicate export of '%s'", name))
+	}
+	w.seen[name] = expr
+	w.exports = append(w.exports, name)
+	return nil
+}
+
+// member 返回模块 module 的成员 name 的表达式
+func member(module, name string) string {
+	return module + "[" + strconv.Quote(name) + "]"
+}
+
+// rewriteImport 改写 offset 处的 import 语句或动态 import()：
+//
+//	import "m"                    -> 模块开头 const __esm1=require("m");
+//	import d, * as ns from "m"    -> 模块开头 const __esm1=require("m");，d 改写为 (__esmDefault(__esm1))，ns 改写为 __esm1
+//	import { a, b as c } from "m" -> 模块开头 const __esm1=require("m");，a 改写为 __esm1["a"]，c 改写为 __esm1["b"]
+//	import("m")                   -> __esmImport("m")
+func (w *moduleRewriter) rewriteImport(offset int) error {
+	s := &scanner{src: string(w.masked), pos: offset + len("import")}
+	switch t := s.peek(); {
+	case t.is(tokenPunct, "("):
+		w.placeholder(offset, importPlaceholder, "__esmImport")
+		w.helpers[esmImportHelper] = true
+		return nil
+	case t.is(tokenPunct, "."):
+		return moduleSyntaxError(w.name, w.src, offset, "import.meta is not supported")
+	case t.kind == tokenString:
+		s.next()
+		w.require(t.text)
+		w.endStatement(s, offset)
+		return nil
+	}
+
+	var defaultName, namespace string
+	type binding struct{ imported, local string }
+	var named []binding
+	bindings := true
+	if t := s.peek(); t.kind == tokenIdent && t.text != "from" {
+		s.next()
+		defaultName = t.text
+		bindings = s.peek().is(tokenPunct, ",")
+		if bindings {
+			s.next()
+		}
+	}
+
+	if bindings {
+		if s.peek().is(tokenPunct, "*") {
+			s.next()
+			if _, err := w.expect(s, tokenIdent, "as"); err != nil {
+				return err
+			}
+			local, err := w.expect(s, tokenIdent, "")
+			if err != nil {
+				return err
+			}
+			namespace = local.text
+		} else {
+			if _, err := w.expect(s, tokenPunct, "{"); err != nil {
+				return err
+			}
+			for !s.peek().is(tokenPunct, "}") {
+				imported, err := w.moduleExportName(s)
+				if err != nil {
+					return err
+				}
+				local := imported
+				if s.peek().is(tokenIdent, "as") {
+					s.next()
+					t, err := w.expect(s, tokenIdent, "")
+					if err != nil {
+						return err
+					}
+					local = t.text
+				}
+				named = append(named, binding{imported, local})
+				if !s.peek().is(tokenPunct, ",") {
+					break
+				}
+				s.next()
+			}
+			if _, err := w.expect(s, tokenPunct, "}"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := w.expect(s, tokenIdent, "from"); err != nil {
+		return err
+	}
+	spec, err := w.expect(s, tokenString, "")
+	if err != nil {
+		return err
+	}
+	if s.peek().is(tokenIdent, "with") {
+		return moduleSyntaxError(w.name, w.src, s.peek().start, "import attributes are not supported")
+	}
+
+	module := w.require(spec.text)
+	if defaultName != "" {
+		w.imports[unistring.NewFromString(defaultName)] = "(__esmDefault(" + module + "))"
+		w.helpers[esmDefaultHelper] = true
+	}
+	if namespace != "" {
+		w.imports[unistring.NewFromString(namespace)] = module
+	}
+	for _, b := range named {
+		if b.imported == "default" {
+			w.imports[unistring.NewFromString(b.local)] = "(__esmDefault(" + module + "))"
+			w.helpers[esmDefaultHelper] = true
+			continue
+		}
+		w.imports[unistring.NewFromString(b.local)] = member(module, b.imported)
+	}
+	w.endStatement(s, offset)
+	return nil
+}
+
+// rewriteExport 改写 offset 处的 export 语句：声明去掉 export 关键字并在模块开头为声明的名称定义 getter，
+// export default 表达式改写为临时常量，export { } 与 export * 改写为 getter。
+func (w *moduleRewriter) rewriteExport(offset int) error {
+	s := &scanner{src: string(w.masked), pos: offset + len("export")}
+	switch t := s.next(); {
+	case t.is(tokenPunct, "*"):
+		namespace := ""
+		if s.peek().is(tokenIdent, "as") {
+			s.next()
+			name, err := w.moduleExportName(s)
+			if err != nil {
+				return err
+			}
+			namespace = name
+		}
+		if _, err := w.expect(s, tokenIdent, "from"); err != nil {
+			return err
+		}
+		spec, err := w.expect(s, tokenString, "")
+		if err != nil {
+			return err
+		}
+		if namespace == "" {
+			w.helpers[esmStarHelper] = true
+			w.requires = append(w.requires, "__esmStar(require("+spec.text+"));")
+		} else if err := w.export(namespace, w.require(spec.text), t.start); err != nil {
+			return err
+		}
+		w.endStatement(s, offset)
+		return nil
+
+	case t.is(tokenPunct, "{"):
+		type binding struct {
+			local, exported string
+			offset          int
+		}
+		var bindings []binding
+		for !s.peek().is(tokenPunct, "}") {
+			start := s.peek().start
+			local, err := w.moduleExportName(s)
+			if err != nil {
+				return err
+			}
+			exported := local
+			if s.peek().is(tokenIdent, "as") {
+				s.next()
+				if exported, err = w.moduleExportName(s); err != nil {
+					return err
+				}
+			}
+			bindings = append(bindings, binding{local, exported, start})
+			if !s.peek().is(tokenPunct, ",") {
+				break
+			}
+			s.next()
+		}
+		if _, err := w.expect(s, tokenPunct, "}"); err != nil {
+			return err
+		}
+
+		module := ""
+		if s.peek().is(tokenIdent, "from") {
+			s.next()
+			spec, err := w.expect(s, tokenString, "")
+			if err != nil {
+				return err
+			}
+			module = w.require(spec.text)
+		}
+		for _, b := range bindings {
+			expr := b.local
+			switch {
+			case module != "" && b.local == "default":
+				expr = "__esmDefault(" + module + ")"
+				w.helpers[esmDefaultHelper] = true
+			case module != "":
+				expr = member(module, b.local)
+			}
+			if err := w.export(b.exported, expr, b.offset); err != nil {
+				return err
+			}
+		}
+		w.endStatement(s, offset)
+		return nil
+
+	case t.is(tokenIdent, "default"):
+		w.module = true
+		if declaration(s) {
+			w.mask(offset, t.end, "")
+			w.decls = append(w.decls, exportDeclaration{offset: offset, start: t.end, isDefault: true})
+			return nil
+		}
+		value := w.temp()
+		w.placeholder(offset, "void"+strings.Repeat(" ", t.end-offset-len("void")), "const "+value+" =")
+		return w.export("default", value, t.start)
+
+	case t.kind == tokenIdent:
+		w.module = true
+		w.mask(offset, offset+len("export"), "")
+		w.decls = append(w.decls, exportDeclaration{offset: offset, start: t.start})
+		return nil
+
+	default:
+		if t.end == t.start {
+			return moduleSyntaxError(w.name, w.src, t.start, "Unexpected end of input")
+		}
+		return moduleSyntaxError(w.name, w.src, t.start, "Unexpected token "+t.text)
+	}
+}
+
+// declaration 判断 s 之后是否为具名的函数或类声明
+func declaration(s *scanner) bool {
+	sub := &scanner{src: s.src, pos: s.pos}
+	t := sub.next()
+	if t.is(tokenIdent, "async") {
+		t = sub.next()
+	}
+	switch {
+	case t.is(tokenIdent, "function"):
+		if sub.peek().is(tokenPunct, "*") {
+			sub.next()
+		}
+	case t.is(tokenIdent, "class"):
+	default:
+		return false
+	}
+	name := sub.next()
+	return name.kind == tokenIdent && name.text != "extends"
+}
+
+// offset 返回 AST 位置 idx 在原始源码中的偏移量
+func (w *moduleRewriter) offset(idx int) int {
+	return idx - 1 - len(esmWrapper)
+}
+
+// resolve 从解析得到的模块函数 fn 中取得 export 声明的名称，检查 import、export 语句位于模块顶层，
+// 并将对导入的绑定的引用改写为对导入模块的成员访问
+func (w *moduleRewriter) resolve(fn *ast.FunctionLiteral) error {
+	body := fn.Body.List
+	offsets := make([]int, 0, len(w.spans)+len(w.decls))
+	for _, span := range w.spans {
+		offsets = append(offsets, span.start)
+	}
+	for _, decl := range w.decls {
+		offsets = append(offsets, decl.offset)
+	}
+	for _, offset := range offsets {
+		for _, stmt := range body {
+			if w.offset(int(stmt.Idx0())) < offset && w.offset(int(stmt.Idx1())) > offset {
+				return moduleSyntaxError(w.name, w.src, offset, "import and export statements must be at the top level of a module")
+			}
+		}
+	}
+
+	for _, decl := range w.decls {
+		i := sort.Search(len(body), func(i int) bool { return w.offset(int(body[i].Idx0())) >= decl.start })
+		if i == len(body) || w.offset(int(body[i].Idx0())) != w.skipSpace(decl.start) {
+			return moduleSyntaxError(w.name, w.src, decl.offset, "Unexpected token export")
+		}
+		names, ok := declaredNames(body[i])
+		if !ok {
+			return moduleSyntaxError(w.name, w.src, decl.offset, "Unexpected token export")
+		}
+		for _, name := range names {
+			exported := name
+			if decl.isDefault {
+				exported = "default"
+			}
+			if err := w.export(exported, name, decl.offset); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(w.imports) == 0 {
+		return nil
+	}
+	for name, expr := range w.seen {
+		if imported, ok := w.imports[unistring.NewFromString(expr)]; ok {
+			w.seen[name] = imported
+		}
+	}
+	r := &resolver{w: w}
+	for _, stmt := range body {
+		r.walk(stmt, nil)
+	}
+	return nil
+}
+
+// skipSpace 返回 offset 之后第一个不是空白或注释的位置
+func (w *moduleRewriter) skipSpace(offset int) int {
+	s := &scanner{src: string(w.masked), pos: offset}
+	s.skipSpace()
+	return s.pos
+}
+
+// declaredNames 返回声明语句 stmt 声明的名称，stmt 不是声明时返回 false
+func declaredNames(stmt ast.Statement) ([]string, bool) {
+	var names []string
+	switch n := stmt.(type) {
+	case *ast.VariableStatement:
+		for _, b := range n.List {
+			names = appendNames(names, b.Target)
+		}
+	case *ast.LexicalDeclaration:
+		for _, b := range n.List {
+			names = appendNames(names, b.Target)
+		}
+	case *ast.FunctionDeclaration:
+		names = append(names, n.Function.Name.Name.String())
+	case *ast.ClassDeclaration:
+		names = append(names, n.Class.Name.Name.String())
+	default:
+		return nil, false
+	}
+	return names, true
+}
+
+// appendNames 将绑定目标 target（标识符或解构模式）声明的名称追加到 names
+func appendNames(names []string, target ast.Expression) []string {
+	switch n := target.(type) {
+	case *ast.Identifier:
+		names = append(names, n.Name.String())
+	case *ast.ObjectPattern:
+		for _, prop := range n.Properties {
+			switch p := prop.(type) {
+			case *ast.PropertyShort:
+				names = append(names, p.Name.Name.String())
+			case *ast.PropertyKeyed:
+				names = appendNames(names, p.Value)
+			}
+		}
+		names = appendNames(names, n.Rest)
+	case *ast.ArrayPattern:
+		for _, elem := range n.Elements {
+			names = appendNames(names, elem)
+		}
+		names = appendNames(names, n.Rest)
+	case *ast.AssignExpression:
+		names = appendNames(names, n.Left)
+	}
+	return names
+}
+
+// scope 词法作用域中声明的名称
+type scope struct {
+	names  map[unistring.String]bool
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{names: make(map[unistring.String]bool), parent: parent}
+}
+
+func (s *scope) declare(target ast.Expression) {
+	for _, name := range appendNames(nil, target) {
+		s.names[unistring.NewFromString(name)] = true
+	}
+}
+
+// declareLexical 声明语句列表 list 中的 let、const、类与函数声明
+func (s *scope) declareLexical(list []ast.Statement) {
+	for _, stmt := range list {
+		switch n := stmt.(type) {
+		case *ast.LexicalDeclaration, *ast.FunctionDeclaration, *ast.ClassDeclaration:
+			names, _ := declaredNames(n)
+			for _, name := range names {
+				s.names[unistring.NewFromString(name)] = true
+			}
+		}
+	}
+}
+
+func (s *scope) declared(name unistring.String) bool {
+	for ; s != nil; s = s.parent {
+		if s.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// resolver 遍历模块的 AST，将没有被局部声明遮蔽的导入绑定的引用改写为导入模块的成员访问
+type resolver struct {
+	w *moduleRewriter
+}
+
+// reference 改写对导入的绑定 id 的引用，shorthand 为 true 时 id 是对象字面量的简写属性
+func (r *resolver) reference(id *ast.Identifier, s *scope, shorthand bool) {
+	expr, ok := r.w.imports[id.Name]
+	if !ok || s.declared(id.Name) {
+		return
+	}
+	if shorthand {
+		expr = id.Name.String() + ":" + expr
+	}
+	start := r.w.offset(int(id.Idx))
+	r.w.edits = append(r.w.edits, edit{start, start + len(id.Name.String()), expr})
+}
+
+// function 遍历函数，name 为函数表达式的名称
+func (r *resolver) function(name *ast.Identifier, params *ast.ParameterList, body ast.Node, decls []*ast.VariableDeclaration, s *scope) {
+	inner := newScope(s)
+	if name != nil {
+		inner.declare(name)
+	}
+	if params != nil {
+		for _, b := range params.List {
+			inner.declare(b.Target)
+		}
+		if params.Rest != nil {
+			inner.declare(params.Rest)
+		}
+	}
+	for _, decl := range decls {
+		for _, b := range decl.List {
+			inner.declare(b.Target)
+		}
+	}
+	if params != nil {
+		for _, b := range params.List {
+			r.binding(b, inner)
+		}
+		r.pattern(params.Rest, inner)
+	}
+
+	switch b := body.(type) {
+	case *ast.BlockStatement:
+		inner.declareLexical(b.List)
+		for _, stmt := range b.List {
+			r.walk(stmt, inner)
+		}
+	case *ast.ExpressionBody:
+		r.walk(b.Expression, inner)
+	}
+}
+
+// binding 遍历绑定 b 的默认值与初始值
+func (r *resolver) binding(b *ast.Binding, s *scope) {
+	r.pattern(b.Target, s)
+	r.walk(b.Initializer, s)
+}
+
+// pattern 遍历绑定目标 target 中的默认值与计算属性名
+func (r *resolver) pattern(target ast.Expression, s *scope) {
+	switch n := target.(type) {
+	case *ast.ObjectPattern:
+		for _, prop := range n.Properties {
+			switch p := prop.(type) {
+			case *ast.PropertyShort:
+				r.walk(p.Initializer, s)
+			case *ast.PropertyKeyed:
+				if p.Computed {
+					r.walk(p.Key, s)
+				}
+				r.pattern(p.Value, s)
+			}
+		}
+		r.pattern(n.Rest, s)
+	case *ast.ArrayPattern:
+		for _, elem := range n.Elements {
+			r.pattern(elem, s)
+		}
+		r.pattern(n.Rest, s)
+	case *ast.AssignExpression:
+		r.pattern(n.Left, s)
+		r.walk(n.Right, s)
+	}
+}
+
+// block 遍历语句块 list
+func (r *resolver) block(list []ast.Statement, s *scope) {
+	inner := newScope(s)
+	inner.declareLexical(list)
+	for _, stmt := range list {
+		r.walk(stmt, inner)
+	}
+}
+
+func (r *resolver) walk(node ast.Node, s *scope) {
+	switch n := node.(type) {
+	case nil:
+	case *ast.Identifier:
+		if n != nil {
+			r.reference(n, s, false)
+		}
+	case *ast.PropertyShort:
+		r.reference(&n.Name, s, true)
+		r.walk(n.Initializer, s)
+	case *ast.PropertyKeyed:
+		if n.Computed {
+			r.walk(n.Key, s)
+		}
+		r.walk(n.Value, s)
+	case *ast.DotExpression:
+		r.walk(n.Left, s)
+	case *ast.PrivateDotExpression:
+		r.walk(n.Left, s)
+	case *ast.MetaProperty, *ast.BranchStatement:
+	case *ast.LabelledStatement:
+		r.walk(n.Statement, s)
+
+	case *ast.FunctionLiteral:
+		if n != nil {
+			r.function(n.Name, n.ParameterList, n.Body, n.DeclarationList, s)
+		}
+	case *ast.ArrowFunctionLiteral:
+		r.function(nil, n.ParameterList, n.Body, n.DeclarationList, s)
+	case *ast.FunctionDeclaration:
+		r.walk(n.Function, s)
+	case *ast.ClassDeclaration:
+		r.walk(n.Class, s)
+	case *ast.ClassLiteral:
+		if n == nil {
+			return
+		}
+		inner := s
+		if n.Name != nil {
+			inner = newScope(s)
+			inner.declare(n.Name)
+		}
+		r.walk(n.SuperClass, inner)
+		for _, elem := range n.Body {
+			r.walk(elem, inner)
+		}
+	case *ast.MethodDefinition:
+		if n.Computed {
+			r.walk(n.Key, s)
+		}
+		r.walk(n.Body, s)
+	case *ast.FieldDefinition:
+		if n.Computed {
+			r.walk(n.Key, s)
+		}
+		r.walk(n.Initializer, s)
+	case *ast.ClassStaticBlock:
+		r.function(nil, nil, n.Block, n.DeclarationList, s)
+
+	case *ast.VariableStatement:
+		for _, b := range n.List {
+			r.binding(b, s)
+		}
+	case *ast.LexicalDeclaration:
+		for _, b := range n.List {
+			r.binding(b, s)
+		}
+	case *ast.BlockStatement:
+		if n != nil {
+			r.block(n.List, s)
+		}
+	case *ast.SwitchStatement:
+		r.walk(n.Discriminant, s)
+		inner := newScope(s)
+		for _, c := range n.Body {
+			inner.declareLexical(c.Consequent)
+		}
+		for _, c := range n.Body {
+			r.walk(c.Test, inner)
+			for _, stmt := range c.Consequent {
+				r.walk(stmt, inner)
+			}
+		}
+	case *ast.ForStatement:
+		inner := s
+		switch init := n.Initializer.(type) {
+		case *ast.ForLoopInitializerLexicalDecl:
+			inner = newScope(s)
+			for _, b := range init.LexicalDeclaration.List {
+				inner.declare(b.Target)
+			}
+			for _, b := range init.LexicalDeclaration.List {
+				r.binding(b, inner)
+			}
+		case *ast.ForLoopInitializerVarDeclList:
+			for _, b := range init.List {
+				r.binding(b, s)
+			}
+		case *ast.ForLoopInitializerExpression:
+			r.walk(init.Expression, s)
+		}
+		r.walk(n.Test, inner)
+		r.walk(n.Update, inner)
+		r.walk(n.Body, inner)
+	case *ast.ForInStatement:
+		r.forInto(n.Into, n.Source, n.Body, s)
+	case *ast.ForOfStatement:
+		r.forInto(n.Into, n.Source, n.Body, s)
+	case *ast.CatchStatement:
+		if n == nil {
+			return
+		}
+		inner := newScope(s)
+		if n.Parameter != nil {
+			inner.declare(n.Parameter)
+			r.pattern(n.Parameter, inner)
+		}
+		r.walk(n.Body, inner)
+
+	default:
+		r.children(reflect.ValueOf(node), s)
+	}
+}
+
+// forInto 遍历 for-in 与 for-of 语句
+func (r *resolver) forInto(into ast.ForInto, source ast.Expression, body ast.Statement, s *scope) {
+	r.walk(source, s)
+	inner := s
+	switch n := into.(type) {
+	case *ast.ForDeclaration:
+		inner = newScope(s)
+		inner.declare(n.Target)
+		r.pattern(n.Target, inner)
+	case *ast.ForIntoVar:
+		r.binding(n.Binding, s)
+	case *ast.ForIntoExpression:
+		r.walk(n.Expression, s)
+	}
+	r.walk(body, inner)
+}
+
+// children 遍历其他节点 v 的子节点
+func (r *resolver) children(v reflect.Value, s *scope) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if node, ok := v.Interface().(ast.Node); ok && v.Kind() == reflect.Interface {
+			r.walk(node, s)
+			return
+		}
+		if v.Kind() == reflect.Ptr {
+			r.children(v.Elem(), s)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			switch field.Kind() {
+			case reflect.Interface, reflect.Ptr:
+				if !field.IsNil() {
+					if node, ok := field.Interface().(ast.Node); ok {
+						r.walk(node, s)
+					}
+				}
+			case reflect.Slice:
+				for j := 0; j < field.Len(); j++ {
+					if node, ok := field.Index(j).Interface().(ast.Node); ok {
+						r.walk(node, s)
+					}
+				}
+			}
+		}
+	}
+}
+
+// generate 生成改写后的源码：第一行为模块开头的声明，其余为应用了 edits 的源码，并记录 source map
+func (w *moduleRewriter) generate() *esmModule {
+	var prelude strings.Builder
+	prelude.WriteString(esmPrelude)
+	for _, name := range w.exports {
+		fmt.Fprintf(&prelude, `Object.defineProperty(exports,%s,{enumerable:true,get:()=>%s});`, strconv.Quote(name), w.seen[name])
+	}
+	for _, helper := range []string{esmDefaultHelper, esmStarHelper, esmImportHelper} {
+		if w.helpers[helper] {
+			prelude.WriteString(helper)
+		}
+	}
+	for _, req := range w.requires {
+		prelude.WriteString(req)
+	}
+
+	out := &mappedWriter{}
+	out.code.WriteString(prelude.String())
+	out.newline()
+
+	sort.SliceStable(w.edits, func(i, j int) bool { return w.edits[i].start < w.edits[j].start })
+	last := 0
+	for _, e := range w.edits {
+		out.copy(w.src, last, e.start)
+		out.insert(w.src, e.start, e.code)
+		last = e.end
+	}
+	out.copy(w.src, last, len(w.src))
+	return &esmModule{code: out.code.String(), mappings: out.mappings.String()}
+}
+
+// mappedWriter 写入改写后的源码，并记录其与原始源码位置对应关系的 source map mappings。
+// goja 以从 1 开始的列号查找 source map，并原样使用其中的原始列号，因此记录的生成列号与原始列号均从 1 开始。
+type mappedWriter struct {
+	code     strings.Builder
+	mappings strings.Builder
+	column   int // 生成的源码当前行的列，从 0 开始
+	segment  bool
+	prevGen  int
+	prevLine int
+	prevCol  int
+	line     int // 原始源码当前的行与行首偏移量，在 copy 中推进
+	start    int
+	pos      int
+}
+
+func (m *mappedWriter) newline() {
+	m.code.WriteByte('\n')
+	m.mappings.WriteByte(';')
+	m.column, m.prevGen, m.segment = 0, 0, false
+}
+
+// mark 记录生成的源码当前位置对应原始源码 offset 处
+func (m *mappedWriter) mark(src string, offset int) {
+	m.advance(src, offset)
+	if m.segment {
+		m.mappings.WriteByte(',')
+	}
+	gen, col := m.column+1, offset-m.start+1
+	writeVLQ(&m.mappings, gen-m.prevGen)
+	writeVLQ(&m.mappings, 0)
+	writeVLQ(&m.mappings, m.line-m.prevLine)
+	writeVLQ(&m.mappings, col-m.prevCol)
+	m.prevGen, m.prevLine, m.prevCol, m.segment = gen, m.line, col, true
+}
+
+// advance 将原始源码的行号推进到 offset 处
+func (m *mappedWriter) advance(src string, offset int) {
+	for m.pos < offset {
+		if n := lineBreak(src, m.pos); n > 0 {
+			m.pos += n
+			m.line, m.start = m.line+1, m.pos
+			continue
+		}
+		m.pos++
+	}
+}
+
+// copy 原样写入原始源码 start 到 end 的内容，在每个词法单元的开头记录位置
+func (m *mappedWriter) copy(src string, start, end int) {
+	for i := start; i < end; {
+		if n := lineBreak(src, i); n > 0 {
+			m.code.WriteString(src[i : i+n])
+			m.mappings.WriteByte(';')
+			m.column, m.prevGen, m.segment = 0, 0, false
+			i += n
+			continue
+		}
+		c := src[i]
+		word := isIdentPart(c) || c >= utf8.RuneSelf
+		if c != ' ' && c != '\t' && (i == start || !word || !(isIdentPart(src[i-1]) || src[i-1] >= utf8.RuneSelf)) {
+			m.mark(src, i)
+		}
+		m.code.WriteByte(c)
+		m.column++
+		i++
+	}
+}
+
+// insert 写入替换原始源码 offset 处内容的 code
+func (m *mappedWriter) insert(src string, offset int, code string) {
+	if code == "" {
+		return
+	}
+	m.mark(src, offset)
+	m.code.WriteString(code)
+	m.column += len(code)
+}
+
+// writeVLQ 以 source map 的 Base64 VLQ 编码写入 v
+func writeVLQ(b *strings.Builder, v int) {
+	const digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	u := v << 1
+	if v < 0 {
+		u = -v<<1 | 1
+	}
+	for {
+		digit := u & 31
+		u >>= 5
+		if u > 0 {
+			digit |= 32
+		}
+		b.WriteByte(digits[digit])
+		if u == 0 {
+			return
+		}
+	}
+}
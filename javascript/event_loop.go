@@ -0,0 +1,172 @@
+package js
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// eventLoop 单次执行期间的事件循环，负责驱动 setTimeout / setInterval 注册的定时器。
+// 只能在持有 runtime 的 goroutine 中使用。
+type eventLoop struct {
+	timers map[int64]*timer // 待执行的定时器
+	nextID int64
+
+	fired chan *timer   // 到期的定时器
+	done  chan struct{} // 事件循环关闭后不再接收到期的定时器
+}
+
+// timer setTimeout / setInterval 注册的定时器
+type timer struct {
+	id       int64
+	fn       goja.Callable
+	args     []goja.Value
+	interval time.Duration // 大于 0 表示由 setInterval 注册
+	t        *time.Timer
+}
+
+func newEventLoop() *eventLoop {
+	return &eventLoop{
+		timers: make(map[int64]*timer),
+		fired:  make(chan *timer),
+		done:   make(chan struct{}),
+	}
+}
+
+// schedule 注册在 delay 后调用 fn 的定时器，repeat 为 true 时每隔 delay 重复调用，返回定时器 ID
+func (l *eventLoop) schedule(fn goja.Callable, delay time.Duration, repeat bool, args []goja.Value) int64 {
+	l.nextID++
+	t := &timer{id: l.nextID, fn: fn, args: args}
+	if repeat {
+		// 与 Node.js 一致，间隔至少为 1ms
+		if delay <= 0 {
+			delay = time.Millisecond
+		}
+		t.interval = delay
+	}
+
+	t.t = time.AfterFunc(delay, func() {
+		select {
+		case l.fired <- t:
+		case <-l.done:
+		}
+	})
+	l.timers[t.id] = t
+	return t.id
+}
+
+// clear 取消定时器，ID 不存在时忽略
+func (l *eventLoop) clear(id int64) {
+	if t, ok := l.timers[id]; ok {
+		t.t.Stop()
+		delete(l.timers, id)
+	}
+}
+
+// run 驱动定时器，直到没有待执行的定时器、ctx 结束或回调抛出异常。
+// ctx 不可取消时只剩 setInterval 注册的定时器的事件循环不会结束，此时返回 ErrJavascriptIntervalPending，
+// 需要持续运行的定时器应通过 ExecuteOptions.Timeout 或可取消的 ctx 限定执行时间。
+func (l *eventLoop) run(ctx context.Context) error {
+	for len(l.timers) > 0 {
+		if ctx.Done() == nil && l.intervalsOnly() {
+			return ErrJavascriptIntervalPending
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case t := <-l.fired:
+			// 定时器在到期后、执行前被取消
+			if l.timers[t.id] != t {
+				continue
+			}
+			if t.interval > 0 {
+				t.t.Reset(t.interval)
+			} else {
+				delete(l.timers, t.id)
+			}
+
+			if _, err := t.fn(goja.Undefined(), t.args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// intervalsOnly 返回待执行的定时器是否全部由 setInterval 注册
+func (l *eventLoop) intervalsOnly() bool {
+	for _, t := range l.timers {
+		if t.interval == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// close 取消全部定时器并关闭事件循环
+func (l *eventLoop) close() {
+	for id := range l.timers {
+		l.clear(id)
+	}
+	close(l.done)
+}
+
+// registerTimers 注册 setTimeout、setInterval、clearTimeout、clearInterval 全局函数，
+// 定时器由当前执行的事件循环驱动
+func (e *engine) registerTimers(rt *goja.Runtime) {
+	setTimer := func(repeat bool) func(goja.FunctionCall) goja.Value {
+		return func(call goja.FunctionCall) goja.Value {
+			fn, ok := goja.AssertFunction(call.Argument(0))
+			if !ok {
+				panic(rt.NewTypeError("callback must be a function"))
+			}
+			if e.loop == nil {
+				panic(rt.NewTypeError("timers are only available while a script is running"))
+			}
+
+			var args []goja.Value
+			if len(call.Arguments) > 2 {
+				args = append(args, call.Arguments[2:]...)
+			}
+			delay := time.Duration(call.Argument(1).ToInteger()) * time.Millisecond
+			return rt.ToValue(e.loop.schedule(fn, delay, repeat, args))
+		}
+	}
+
+	clearTimer := func(call goja.FunctionCall) goja.Value {
+		if e.loop != nil {
+			e.loop.clear(call.Argument(0).ToInteger())
+		}
+		return goja.Undefined()
+	}
+
+	_ = rt.Set("setTimeout", setTimer(false))
+	_ = rt.Set("setInterval", setTimer(true))
+	_ = rt.Set("clearTimeout", clearTimer)
+	_ = rt.Set("clearInterval", clearTimer)
+}
+
+// promiseType Promise 导出的 Go 类型
+var promiseType = reflect.TypeOf((*goja.Promise)(nil))
+
+// settle 返回 Promise 的结果，Promise 被拒绝时返回拒绝原因对应的错误；value 不是 Promise 时原样返回
+func (e *engine) settle(value goja.Value) (goja.Value, error) {
+	obj, ok := value.(*goja.Object)
+	if !ok || obj.ExportType() != promiseType {
+		return value, nil
+	}
+	p := obj.Export().(*goja.Promise)
+
+	switch p.State() {
+	case goja.PromiseStateFulfilled:
+		return p.Result(), nil
+	case goja.PromiseStateRejected:
+		return nil, e.newRejectionError(p.Result())
+	default:
+		return nil, ErrJavascriptPromisePending
+	}
+}
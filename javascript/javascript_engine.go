@@ -2,22 +2,31 @@ package js
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"reflect"
 	"sync"
 
 	"github.com/dop251/goja"
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja_nodejs/require"
 
 	scriptEngine "github.com/tx7do/go-scripts"
 )
 
 func init() {
-	_ = scriptEngine.Register(scriptEngine.JavaScriptType, func() (scriptEngine.Engine, error) {
-		return newJavascriptEngine()
+	_ = scriptEngine.Register(scriptEngine.JavaScriptType, func(opts ...scriptEngine.Option) (scriptEngine.Engine, error) {
+		return newJavascriptEngine(opts...)
 	})
 }
 
+// defaultMaxCallStackSize goja 默认的最大调用栈深度
+const defaultMaxCallStackSize = math.MaxInt32
+
 // engine JavaScript 脚本引擎实现
 //
 // 锁使用约定：
@@ -26,20 +35,28 @@ func init() {
 // - 不要在持有 `execMu` 的情况下再去获取 `mu`，以避免死锁。
 // 该约定用于保护 runtime / programs / initialized 等状态的一致性。
 type engine struct {
-	runtime  *goja.Runtime   // JavaScript 运行时
-	programs []*goja.Program // 已编译的程序列表
+	runtime  *goja.Runtime         // JavaScript 运行时
+	registry *require.Registry     // require 使用的模块注册表
+	programs []*goja.Program       // 已编译的程序列表
+	options  *scriptEngine.Options // 引擎创建选项
+	budget   *budget               // 当前执行的预算计数器，nil 表示不限制
+	loop     *eventLoop            // 当前执行的事件循环，不在执行时为 nil
+	ctx      context.Context       // 当前执行的 context，不在执行时为 nil
+
+	modulePrograms map[string]*goja.Program // 插入了计量代码的模块函数，按模块路径索引
 
 	initialized bool
 	lastError   error
 
 	mu          sync.RWMutex // 保护 initialized, programs
-	execMu      sync.Mutex   // 保护 runtime
+	execMu      sync.Mutex   // 保护 runtime, budget, modulePrograms, loop, ctx
 	lastErrorMu sync.RWMutex // 保护 lastError
 }
 
 // newJavascriptEngine 创建 JavaScript 引擎实例
-func newJavascriptEngine() (*engine, error) {
+func newJavascriptEngine(opts ...scriptEngine.Option) (*engine, error) {
 	return &engine{
+		options:     scriptEngine.NewOptions(opts...),
 		initialized: false,
 	}, nil
 }
@@ -48,22 +65,52 @@ func (e *engine) GetType() scriptEngine.Type {
 	return scriptEngine.JavaScriptType
 }
 
+// fieldNameMapper 按 FieldNaming 命名 Go struct 的字段与方法
+type fieldNameMapper struct {
+	naming scriptEngine.FieldNaming
+}
+
+func (m fieldNameMapper) FieldName(_ reflect.Type, f reflect.StructField) string {
+	name, _ := m.naming.FieldName(f)
+	return name
+}
+
+func (m fieldNameMapper) MethodName(_ reflect.Type, method reflect.Method) string {
+	return m.naming.MethodName(method.Name)
+}
+
 // Init 初始化引擎
 func (e *engine) Init(_ context.Context) error {
 	newRt := goja.New()
+	e.registerTimers(newRt)
+	e.registerContext(newRt)
+	if e.options.FieldNaming != scriptEngine.FieldNamingDefault {
+		newRt.SetFieldNameMapper(fieldNameMapper{naming: e.options.FieldNaming})
+	}
+
+	e.defineBudget(newRt)
+	registry := require.NewRegistry(e.registryOptions()...)
+	if err := e.enableNodeModules(newRt, registry); err != nil {
+		err = newScriptError(err)
+		e.setLastError(err)
+		return err
+	}
 
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	if e.initialized {
-		e.setLastError(ErrJavascriptEngineAlreadyInitialized)
-		return ErrJavascriptEngineAlreadyInitialized
+		err := newScriptError(ErrJavascriptEngineAlreadyInitialized)
+		e.setLastError(err)
+		return err
 	}
 
 	e.execMu.Lock()
 	defer e.execMu.Unlock()
 
 	e.runtime = newRt
+	e.registry = registry
+	e.modulePrograms = make(map[string]*goja.Program)
 
 	e.initialized = true
 	e.lastError = nil
@@ -77,8 +124,9 @@ func (e *engine) Close() error {
 	defer e.mu.Unlock()
 
 	if !e.initialized {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
 	e.execMu.Lock()
@@ -86,6 +134,7 @@ func (e *engine) Close() error {
 
 	e.initialized = false
 	e.runtime = nil
+	e.registry = nil
 	e.programs = nil
 
 	e.lastErrorMu.Lock()
@@ -113,12 +162,14 @@ func (e *engine) IsInitialized() bool {
 // LoadString 加载字符串脚本
 func (e *engine) LoadString(_ context.Context, source string) error {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	program, err := goja.Compile("", source, true)
+	program, err := e.compileScript("", source, true)
 	if err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return err
 	}
@@ -126,8 +177,9 @@ func (e *engine) LoadString(_ context.Context, source string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	if !e.initialized {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err = newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 	e.programs = append(e.programs, program)
 
@@ -135,21 +187,17 @@ func (e *engine) LoadString(_ context.Context, source string) error {
 	return nil
 }
 
-// LoadFile 加载脚本文件
+// LoadFile 加载脚本文件，模块模式下通过 require 加载模块文件，执行时才读取并编译模块
 func (e *engine) LoadFile(_ context.Context, filePath string) error {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
-	}
-
-	source, err := os.ReadFile(filePath)
-	if err != nil {
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
 		e.setLastError(err)
 		return err
 	}
 
-	program, err := goja.Compile(filePath, string(source), true)
+	program, err := e.compileFile(filePath)
 	if err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return err
 	}
@@ -157,8 +205,9 @@ func (e *engine) LoadFile(_ context.Context, filePath string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	if !e.initialized {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err = newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 	e.programs = append(e.programs, program)
 
@@ -169,12 +218,14 @@ func (e *engine) LoadFile(_ context.Context, filePath string) error {
 // LoadReader 从 Reader 加载脚本
 func (e *engine) LoadReader(ctx context.Context, reader io.Reader, _ string) error {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
 	source, err := io.ReadAll(reader)
 	if err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return err
 	}
@@ -200,11 +251,34 @@ func (e *engine) LoadFiles(ctx context.Context, filePaths []string) error {
 	return nil
 }
 
+// compileScript 编译字符串脚本，模块模式下编译为匿名 ES 模块
+func (e *engine) compileScript(name, source string, strict bool) (*goja.Program, error) {
+	if e.options.ESModules {
+		return compileModule(name, source)
+	}
+	return compileProgram(name, source, strict)
+}
+
+// compileFile 编译脚本文件，相对路径按 Options.ScriptPath 解析，模块模式下返回通过 require 加载该文件的程序
+func (e *engine) compileFile(filePath string) (*goja.Program, error) {
+	if e.options.ESModules {
+		return e.requireProgram(filePath)
+	}
+
+	filePath = e.options.ScriptPath(filePath)
+	source, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return compileProgram(filePath, string(source), true)
+}
+
 // executeProgram 执行已编译的程序
 func (e *engine) executeProgram(ctx context.Context, program *goja.Program) (any, error) {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return nil, ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
 	}
 
 	return e.RunProgram(ctx, program)
@@ -213,8 +287,9 @@ func (e *engine) executeProgram(ctx context.Context, program *goja.Program) (any
 // ExecuteLoaded 执行已加载的脚本
 func (e *engine) ExecuteLoaded(ctx context.Context) (any, error) {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return nil, ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
 	}
 
 	// 复制 programs 引用，避免执行期间被修改
@@ -224,8 +299,9 @@ func (e *engine) ExecuteLoaded(ctx context.Context) (any, error) {
 	e.mu.RUnlock()
 
 	if len(progs) == 0 {
-		e.setLastError(ErrJavascriptNoProgramLoaded)
-		return nil, ErrJavascriptNoProgramLoaded
+		err := newScriptError(ErrJavascriptNoProgramLoaded)
+		e.setLastError(err)
+		return nil, err
 	}
 
 	results := make([]any, 0, len(progs))
@@ -244,44 +320,30 @@ func (e *engine) ExecuteLoaded(ctx context.Context) (any, error) {
 
 // ExecuteString 执行字符串脚本
 func (e *engine) ExecuteString(ctx context.Context, src string) (any, error) {
+	return e.ExecuteStringWithOptions(ctx, src, scriptEngine.ExecuteOptions{})
+}
+
+// ExecuteStringWithOptions 按 opts 执行字符串脚本，opts 仅对本次执行生效
+func (e *engine) ExecuteStringWithOptions(ctx context.Context, src string, opts scriptEngine.ExecuteOptions) (any, error) {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return nil, ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
 	}
 
-	done := make(chan struct{})
-	defer close(done)
-
-	go func() {
-		select {
-		case <-ctx.Done():
-			e.execMu.Lock()
-			rt := e.runtime
-			e.execMu.Unlock()
-			if rt != nil {
-				rt.Interrupt(ctx.Err())
-			}
-		case <-done:
-		}
-	}()
-
-	result, err := e.withRuntime(func(rt *goja.Runtime) (any, error) {
-		var retErr error
-		defer func() {
-			if r := recover(); r != nil {
-				retErr = fmt.Errorf("panic in ExecuteString: %v", r)
-			}
-		}()
+	program, err := e.compileScript("", src, false)
+	if err != nil {
+		err = newScriptError(err)
+		e.setLastError(err)
+		return nil, err
+	}
 
-		val, runErr := rt.RunString(src)
-		if runErr != nil || val == nil {
-			return nil, runErr
-		}
-		exported := val.Export()
-		return exported, retErr
-	})
+	result, err := e.withContext(ctx, opts, func(rt *goja.Runtime) (goja.Value, error) {
+		return rt.RunProgram(program)
+	}, e.exportValue)
 
 	if err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return nil, err
 	}
@@ -313,17 +375,19 @@ func (e *engine) ExecuteFile(ctx context.Context, filePath string) (any, error)
 // RegisterGlobal 注册全局变量
 func (e *engine) RegisterGlobal(name string, value any) error {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
 	e.execMu.Lock()
 	defer e.execMu.Unlock()
 	if e.runtime == nil {
-		e.setLastError(ErrJavascriptRuntimeNotInitialized)
-		return ErrJavascriptRuntimeNotInitialized
+		err := newScriptError(ErrJavascriptRuntimeNotInitialized)
+		e.setLastError(err)
+		return err
 	}
-	_ = e.runtime.Set(name, value)
+	_ = e.runtime.Set(name, e.toValue(e.runtime, value))
 
 	e.ClearError()
 
@@ -357,23 +421,25 @@ func (e *engine) ExecuteFiles(ctx context.Context, filePaths []string) ([]any, e
 // GetGlobal 获取全局变量
 func (e *engine) GetGlobal(name string) (any, error) {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return nil, ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
 	}
 
 	e.execMu.Lock()
 	defer e.execMu.Unlock()
 	if e.runtime == nil {
-		e.setLastError(ErrJavascriptRuntimeNotInitialized)
-		return nil, ErrJavascriptRuntimeNotInitialized
+		err := newScriptError(ErrJavascriptRuntimeNotInitialized)
+		e.setLastError(err)
+		return nil, err
 	}
 	val := e.runtime.Get(name)
 	if val == nil {
-		err := fmt.Errorf("global variable %s not found", name)
+		err := newScriptError(fmt.Errorf("global variable %s not found", name))
 		e.setLastError(err)
 		return nil, err
 	}
-	result := val.Export()
+	result := e.exportValue(val)
 
 	e.ClearError()
 
@@ -383,18 +449,20 @@ func (e *engine) GetGlobal(name string) (any, error) {
 // RegisterFunction 注册全局函数
 func (e *engine) RegisterFunction(name string, fn any) error {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
 	e.execMu.Lock()
 	defer e.execMu.Unlock()
 	if e.runtime == nil {
-		e.setLastError(ErrJavascriptRuntimeNotInitialized)
-		return ErrJavascriptRuntimeNotInitialized
+		err := newScriptError(ErrJavascriptRuntimeNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	_ = e.runtime.Set(name, fn)
+	_ = e.runtime.Set(name, e.toValue(e.runtime, fn))
 
 	e.ClearError()
 
@@ -403,64 +471,125 @@ func (e *engine) RegisterFunction(name string, fn any) error {
 
 // CallFunction 调用 JavaScript 函数
 func (e *engine) CallFunction(ctx context.Context, name string, args ...any) (any, error) {
+	return e.CallFunctionWithOptions(ctx, name, scriptEngine.ExecuteOptions{}, args...)
+}
+
+// CallFunctionWithOptions 按 opts 调用 JavaScript 函数，opts 仅对本次调用生效
+func (e *engine) CallFunctionWithOptions(ctx context.Context, name string, opts scriptEngine.ExecuteOptions, args ...any) (any, error) {
+	return e.callFunction(ctx, name, opts, args, e.exportValue)
+}
+
+// CallFunctionMulti 调用 JavaScript 函数，以 CallResult 返回结果。
+// JavaScript 函数只有一个返回值；启用 SpreadArrayResults 时，返回的数组会被展开为多个返回值。
+func (e *engine) CallFunctionMulti(ctx context.Context, name string, args ...any) (scriptEngine.CallResult, error) {
+	res, err := e.callFunction(ctx, name, scriptEngine.ExecuteOptions{}, args, func(v goja.Value) any {
+		if goja.IsUndefined(v) {
+			return []any{}
+		}
+		exported := e.exportValue(v)
+		if arr, ok := exported.([]any); ok && e.options.SpreadArrayResults {
+			return arr
+		}
+		return []any{exported}
+	})
+	if err != nil {
+		return scriptEngine.CallResult{}, err
+	}
+	return scriptEngine.CallResult{Values: res.([]any)}, nil
+}
+
+// InspectFunction 返回全局函数 name 的参数信息。
+// 参数从函数源码解析：带默认值的参数计入 Optional，剩余参数视为 Variadic；
+// Go 实现的函数与无法解析源码的函数（例如 bind 得到的函数）视为接受任意个数的参数。
+func (e *engine) InspectFunction(name string) (scriptEngine.FunctionSignature, error) {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return nil, ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return scriptEngine.FunctionSignature{}, err
+	}
+
+	e.execMu.Lock()
+	defer e.execMu.Unlock()
+	if e.runtime == nil {
+		err := newScriptError(ErrJavascriptRuntimeNotInitialized)
+		e.setLastError(err)
+		return scriptEngine.FunctionSignature{}, err
 	}
 
-	done := make(chan struct{})
-	defer close(done)
+	v := e.runtime.Get(name)
+	if v == nil {
+		return scriptEngine.FunctionSignature{}, newScriptError(fmt.Errorf("%w: %s", ErrJavascriptFunctionNotFound, name))
+	}
+	if _, ok := goja.AssertFunction(v); !ok {
+		return scriptEngine.FunctionSignature{}, newScriptError(fmt.Errorf("%w: %s", ErrJavascriptNotAFunction, name))
+	}
 
-	go func() {
-		select {
-		case <-ctx.Done():
-			e.execMu.Lock()
-			rt := e.runtime
-			e.execMu.Unlock()
-			if rt != nil {
-				rt.Interrupt(ctx.Err())
-			}
-		case <-done:
-		}
-	}()
+	fn := v.ToObject(e.runtime)
+	required := int(fn.Get("length").ToInteger())
+	params := functionParams(fn.String())
+	if params == nil {
+		return scriptEngine.FunctionSignature{Params: required, Variadic: true}, nil
+	}
+	return scriptEngine.FunctionSignature{
+		Params:   len(params.List),
+		Optional: max(len(params.List)-required, 0),
+		Variadic: params.Rest != nil,
+	}, nil
+}
 
-	result, err := e.withRuntime(func(rt *goja.Runtime) (any, error) {
-		var (
-			res    any
-			retErr error
-		)
-		defer func() {
-			if r := recover(); r != nil {
-				retErr = fmt.Errorf("panic in CallFunction %s: %v", name, r)
-			}
-		}()
+// functionParams 解析函数源码 source 的参数列表，源码不是函数表达式时返回 nil
+func functionParams(source string) *ast.ParameterList {
+	program, err := parser.ParseFile(nil, "", "("+source+")", 0)
+	if err != nil || len(program.Body) != 1 {
+		return nil
+	}
+	stmt, ok := program.Body[0].(*ast.ExpressionStatement)
+	if !ok {
+		return nil
+	}
+	switch fn := stmt.Expression.(type) {
+	case *ast.FunctionLiteral:
+		return fn.ParameterList
+	case *ast.ArrowFunctionLiteral:
+		return fn.ParameterList
+	}
+	return nil
+}
+
+// callFunction 按 opts 调用全局函数 name，模块模式下 name 可以是 "模块路径#函数名"，并在持有 runtime 时使用 convert 转换返回值
+func (e *engine) callFunction(ctx context.Context, name string, opts scriptEngine.ExecuteOptions, args []any, convert func(goja.Value) any) (any, error) {
+	if !e.IsInitialized() {
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
+	}
 
-		v := rt.Get(name)
+	result, err := e.withContext(ctx, opts, func(rt *goja.Runtime) (goja.Value, error) {
+		v, err := e.moduleFunction(rt, name)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = rt.Get(name)
+		}
 		if v == nil {
-			return nil, fmt.Errorf("function %s not found", name)
+			return nil, fmt.Errorf("%w: %s", ErrJavascriptFunctionNotFound, name)
 		}
 		fn, ok := goja.AssertFunction(v)
 		if !ok {
-			return nil, fmt.Errorf("%s is not a function", name)
+			return nil, fmt.Errorf("%w: %s", ErrJavascriptNotAFunction, name)
 		}
 
 		vals := make([]goja.Value, len(args))
 		for i, a := range args {
-			vals[i] = rt.ToValue(a)
+			vals[i] = e.toValue(rt, a)
 		}
 
-		callRes, callErr := fn(goja.Undefined(), vals...)
-		if callErr != nil {
-			return nil, callErr
-		}
-		if callRes == nil {
-			return nil, nil
-		}
-		res = callRes.Export()
-		return res, retErr
-	})
+		return fn(goja.Undefined(), vals...)
+	}, convert)
 
 	if err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return nil, err
 	}
@@ -468,30 +597,51 @@ func (e *engine) CallFunction(ctx context.Context, name string, args ...any) (an
 	return result, nil
 }
 
-// RegisterModule 注册模块
+// RegisterModule 注册模块，脚本通过 require(name) 加载模块，模块不会出现在全局命名空间中。
+// module 为 map[string]any 时其成员作为模块的导出成员，为 *scriptEngine.NativeModule 时其函数、常量与子模块作为模块的导出成员，
+// 子模块同时可以通过 require("name/子模块名") 加载；其他值（如 struct）直接作为模块导出。
 func (e *engine) RegisterModule(name string, module any) error {
 	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return ErrJavascriptEngineNotInitialized
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return err
+	}
+
+	if nm, ok := module.(*scriptEngine.NativeModule); ok {
+		if err := nm.Validate(); err != nil {
+			err = newScriptError(err)
+			e.setLastError(err)
+			return err
+		}
 	}
 
 	e.execMu.Lock()
 	defer e.execMu.Unlock()
 	if e.runtime == nil {
-		e.setLastError(ErrJavascriptRuntimeNotInitialized)
-		return ErrJavascriptRuntimeNotInitialized
+		err := newScriptError(ErrJavascriptRuntimeNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	moduleObj := e.runtime.NewObject()
-	if m, ok := module.(map[string]any); ok {
-		for k, v := range m {
-			_ = moduleObj.Set(k, v)
-		}
-		_ = e.runtime.Set(name, moduleObj)
-	} else {
-		_ = e.runtime.Set(name, module)
+	if nm, ok := module.(*scriptEngine.NativeModule); ok {
+		e.registerNativeModule(name, nm)
+		e.ClearError()
+		return nil
 	}
 
+	e.registry.RegisterNativeModule(name, func(rt *goja.Runtime, m *goja.Object) {
+		members, ok := module.(map[string]any)
+		if !ok {
+			_ = m.Set("exports", e.toValue(rt, module))
+			return
+		}
+
+		exports := m.Get("exports").(*goja.Object)
+		for k, v := range members {
+			_ = exports.Set(k, e.toValue(rt, v))
+		}
+	})
+
 	e.ClearError()
 
 	return nil
@@ -527,38 +677,130 @@ func (e *engine) withRuntime(fn func(rt *goja.Runtime) (any, error)) (any, error
 	return fn(e.runtime)
 }
 
-// RunProgram 运行已编译的程序
-func (e *engine) RunProgram(ctx context.Context, program *goja.Program) (any, error) {
-	if !e.IsInitialized() {
-		e.setLastError(ErrJavascriptEngineNotInitialized)
-		return nil, ErrJavascriptEngineNotInitialized
-	}
+// withContext 在受保护的环境中使用 runtime 执行 fn，并按 opts 设置本次执行的超时、全局变量、调用栈深度与预算，
+// 执行期间第一个参数为 context.Context 的宿主函数收到 ctx。
+// fn 返回后事件循环继续驱动定时器，直到没有待执行的定时器，ctx 不可取消且只剩 setInterval 注册的定时器时
+// 返回 ErrJavascriptIntervalPending；fn 返回 Promise 时以其结果作为执行结果，
+// 被拒绝时返回拒绝原因对应的错误。convert 在持有 runtime 时转换最终结果。
+// ctx 结束或预算耗尽时中断正在运行的脚本并取消全部定时器，返回以 ctx 的错误或
+// *scriptEngine.BudgetExceededError 为原因的超时错误。
+func (e *engine) withContext(ctx context.Context, opts scriptEngine.ExecuteOptions, fn func(rt *goja.Runtime) (goja.Value, error), convert func(goja.Value) any) (any, error) {
+	ctx, cancel := opts.Context(ctx)
+	defer cancel()
+
+	return e.withRuntime(func(rt *goja.Runtime) (result any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				result, err = nil, fmt.Errorf("panic in javascript execution: %v", r)
+			}
+		}()
 
-	done := make(chan struct{})
-	defer close(done)
+		if limit := opts.BudgetOr(e.options.Budget); limit > 0 {
+			e.budget = &budget{limit: limit}
+			defer func() { e.budget = nil }()
+		}
 
-	go func() {
-		select {
-		case <-ctx.Done():
-			e.execMu.Lock()
-			rt := e.runtime
-			e.execMu.Unlock()
-			if rt != nil {
-				rt.Interrupt(ctx.Err())
-			}
-		case <-done:
+		defer e.applyOptions(rt, opts)()
+
+		previous := e.ctx
+		e.ctx = ctx
+		defer func() { e.ctx = previous }()
+
+		e.loop = newEventLoop()
+		defer func() {
+			e.loop.close()
+			e.loop = nil
+		}()
+
+		// 清除脚本结束后才送达的中断，避免影响下一次执行
+		defer rt.ClearInterrupt()
+
+		if ctx.Done() != nil {
+			stop := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				select {
+				case <-ctx.Done():
+					rt.Interrupt(ctx.Err())
+				case <-stop:
+				}
+			}()
+			defer func() {
+				close(stop)
+				<-stopped
+			}()
 		}
-	}()
 
-	result, err := e.withRuntime(func(rt *goja.Runtime) (any, error) {
-		val, err := rt.RunProgram(program)
-		if err != nil || val == nil {
-			return nil, err
+		value, err := fn(rt)
+		if err == nil {
+			err = e.loop.run(ctx)
+		}
+		if err == nil {
+			value, err = e.settle(value)
 		}
-		return val.Export(), nil
+
+		var exceeded *scriptEngine.BudgetExceededError
+		switch {
+		case errors.As(err, &exceeded):
+			return nil, newTimeoutError(exceeded, err)
+		case err != nil && ctx.Err() != nil:
+			return nil, newTimeoutError(ctx.Err(), err)
+		case err != nil:
+			return nil, e.withThrown(err)
+		}
+
+		if value == nil {
+			value = goja.Undefined()
+		}
+		return convert(value), nil
 	})
+}
+
+// applyOptions 为本次执行注入 opts 中的全局变量并限制调用栈深度，返回恢复先前状态的函数。
+// 调用方需持有 runtime。
+func (e *engine) applyOptions(rt *goja.Runtime, opts scriptEngine.ExecuteOptions) (restore func()) {
+	global := rt.GlobalObject()
+
+	previous := make(map[string]goja.Value, len(opts.Globals))
+	for name, value := range opts.Globals {
+		previous[name] = global.Get(name)
+		_ = rt.Set(name, e.toValue(rt, value))
+	}
+
+	if opts.MaxStack > 0 {
+		rt.SetMaxCallStackSize(opts.MaxStack)
+	}
+
+	return func() {
+		if opts.MaxStack > 0 {
+			rt.SetMaxCallStackSize(defaultMaxCallStackSize)
+		}
+
+		for name, value := range previous {
+			if value == nil {
+				_ = global.Delete(name)
+			} else {
+				_ = global.Set(name, value)
+			}
+		}
+	}
+}
+
+// RunProgram 运行已编译的程序
+func (e *engine) RunProgram(ctx context.Context, program *goja.Program) (any, error) {
+	if !e.IsInitialized() {
+		err := newScriptError(ErrJavascriptEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
+	}
+
+	result, err := e.withContext(ctx, scriptEngine.ExecuteOptions{}, func(rt *goja.Runtime) (goja.Value, error) {
+		return rt.RunProgram(program)
+	}, e.exportValue)
 
 	if err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return nil, err
 	}
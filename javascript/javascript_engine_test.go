@@ -2,12 +2,20 @@ package js
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	scriptEngine "github.com/tx7do/go-scripts"
 )
 
 func TestJavascriptEngine(t *testing.T) {
@@ -173,3 +181,1308 @@ func TestConcurrentInitCloseAndExecute(t *testing.T) {
 		t.Fatal("timeout: concurrent init/close and execute did not finish")
 	}
 }
+
+func TestCallFunctionMulti(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+	assert.Nil(t, eng.Init(ctx))
+
+	_, err = eng.ExecuteString(ctx, `
+	function pair(a, b) { return [a, b]; }
+	function nothing() {}
+	`)
+	assert.Nil(t, err)
+
+	res, err := eng.CallFunctionMulti(ctx, "pair", 1, "x")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{[]any{int64(1), "x"}}, res.Values)
+
+	res, err = eng.CallFunctionMulti(ctx, "nothing")
+	assert.Nil(t, err)
+	assert.Empty(t, res.Values)
+
+	// 启用数组展开
+	spread, err := newJavascriptEngine(scriptEngine.WithSpreadArrayResults(true))
+	assert.Nil(t, err)
+	defer spread.Close()
+	assert.Nil(t, spread.Init(ctx))
+
+	_, err = spread.ExecuteString(ctx, `function pair(a, b) { return [a, b]; }`)
+	assert.Nil(t, err)
+
+	res, err = spread.CallFunctionMulti(ctx, "pair", 1, "x")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(1), "x"}, res.Values)
+	assert.Nil(t, res.Error)
+}
+
+func TestExecuteOptions(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+	assert.Nil(t, eng.Init(ctx))
+
+	// Timeout 转换为截止时间，超时后引擎可立即继续使用
+	_, err = eng.ExecuteStringWithOptions(ctx, `while (true) {}`, scriptEngine.ExecuteOptions{
+		Timeout: 50 * time.Millisecond,
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	result, err := eng.ExecuteString(ctx, `1 + 1`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), result)
+
+	// Globals 仅在本次执行期间可见
+	assert.Nil(t, eng.RegisterGlobal("tenant", "default"))
+	result, err = eng.ExecuteStringWithOptions(ctx, `tenant + "/" + region`, scriptEngine.ExecuteOptions{
+		Globals: map[string]any{"tenant": "acme", "region": "cn"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "acme/cn", result)
+
+	result, err = eng.ExecuteString(ctx, `tenant + "/" + typeof region`)
+	assert.Nil(t, err)
+	assert.Equal(t, "default/undefined", result)
+
+	// MaxStack 限制调用栈深度
+	_, err = eng.ExecuteString(ctx, `function depth(n) { return n === 0 ? 0 : 1 + depth(n - 1); }`)
+	assert.Nil(t, err)
+
+	_, err = eng.CallFunctionWithOptions(ctx, "depth", scriptEngine.ExecuteOptions{MaxStack: 50}, 100)
+	assert.NotNil(t, err)
+
+	result, err = eng.CallFunction(ctx, "depth", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), result)
+}
+
+func TestBudget(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine(scriptEngine.WithBudget(1000))
+	assert.Nil(t, err)
+	defer eng.Close()
+	assert.Nil(t, eng.Init(ctx))
+
+	// 死循环在预算耗尽后中止，try/catch 无法捕获
+	_, err = eng.ExecuteString(ctx, `try { while (true) {} } catch (e) {}`)
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+
+	var exceeded *scriptEngine.BudgetExceededError
+	assert.True(t, errors.As(err, &exceeded))
+	assert.Equal(t, int64(1000), exceeded.Limit)
+	assert.Equal(t, int64(1001), exceeded.Used)
+
+	// 预算内的脚本正常执行，引擎可继续使用
+	result, err := eng.ExecuteString(ctx, `let sum = 0; for (let i = 0; i < 10; i++) { sum += i; } sum`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(45), result)
+
+	// 单次调用的预算覆盖引擎默认值
+	_, err = eng.ExecuteString(ctx, `const spin = (n) => { for (let i = 0; i < n; i++) {} return n; };`)
+	assert.Nil(t, err)
+
+	_, err = eng.CallFunctionWithOptions(ctx, "spin", scriptEngine.ExecuteOptions{Budget: 10}, 100)
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+
+	result, err = eng.CallFunctionWithOptions(ctx, "spin", scriptEngine.ExecuteOptions{Budget: 1000}, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), result)
+
+	// 计量函数名保留，脚本不能覆盖
+	_, err = eng.ExecuteString(ctx, `var __scriptEngineBudget__ = function () {};`)
+	assert.NotNil(t, err)
+
+	// require 加载的模块同样计量，包括模块模式下执行的文件
+	fsys := fstest.MapFS{
+		"spin.js":  {Data: []byte(`while (true) {}`)},
+		"spin.mjs": {Data: []byte(`export const n = 1; while (true) {}`)},
+		"lib.js":   {Data: []byte(`exports.forever = function () { while (true) {} }`)},
+	}
+	for _, esm := range []bool{false, true} {
+		modEng, err := newJavascriptEngine(scriptEngine.WithBudget(1000), scriptEngine.WithModuleFS(fsys), scriptEngine.WithESModules(esm))
+		assert.Nil(t, err)
+		assert.Nil(t, modEng.Init(ctx))
+
+		_, err = modEng.ExecuteString(ctx, `require("./spin.js")`)
+		assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+		if esm {
+			_, err = modEng.ExecuteFile(ctx, "spin.mjs")
+			assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+		}
+		assert.Nil(t, modEng.Close())
+	}
+
+	// 没有预算时编译与加载的代码同样插入计量代码，之后单次调用的预算对其生效
+	plain, err := newJavascriptEngine(scriptEngine.WithModuleFS(fsys))
+	assert.Nil(t, err)
+	assert.Nil(t, plain.Init(ctx))
+	defer plain.Close()
+
+	_, err = plain.ExecuteString(ctx, `function forever() { while (true) {} }`)
+	assert.Nil(t, err)
+	_, err = plain.CallFunctionWithOptions(ctx, "forever", scriptEngine.ExecuteOptions{Budget: 100})
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+
+	_, err = plain.ExecuteString(ctx, `const lib = require("./lib.js")`)
+	assert.Nil(t, err)
+	_, err = plain.ExecuteStringWithOptions(ctx, `lib.forever()`, scriptEngine.ExecuteOptions{Budget: 100})
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+
+	_, err = plain.ExecuteStringWithOptions(ctx, `require("./spin.js")`, scriptEngine.ExecuteOptions{Budget: 100})
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+}
+
+func TestScriptError(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+	assert.Nil(t, eng.Init(ctx))
+
+	var se *scriptEngine.ScriptError
+
+	// 编译错误
+	_, err = eng.ExecuteString(ctx, "var a = 1;\nvar = 2;")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.JavaScriptType, se.Engine)
+	assert.Equal(t, scriptEngine.ErrorKindCompile, se.Kind)
+	assert.Equal(t, 2, se.Line)
+	assert.Equal(t, 5, se.Column)
+
+	// 运行时错误，包含调用栈
+	assert.Nil(t, eng.LoadString(ctx, "function inner() {\n  throw new Error('boom');\n}\nfunction outer() { inner(); }"))
+	_, err = eng.ExecuteLoaded(ctx)
+	assert.Nil(t, err)
+
+	_, err = eng.CallFunction(ctx, "outer")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindRuntime, se.Kind)
+	assert.Equal(t, "Error: boom", se.Message)
+	assert.Equal(t, 2, se.Line)
+	assert.Equal(t, 9, se.Column)
+	assert.GreaterOrEqual(t, len(se.Stack), 2)
+	assert.Equal(t, "inner", se.Stack[0].Function)
+	assert.Equal(t, "outer", se.Stack[1].Function)
+
+	// Go 函数返回的错误
+	assert.Nil(t, eng.RegisterFunction("fail", func() error { return errors.New("host failure") }))
+	_, err = eng.ExecuteString(ctx, "fail()")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindHost, se.Kind)
+
+	_, err = eng.CallFunction(ctx, "missing")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindHost, se.Kind)
+
+	// 超时
+	_, err = eng.ExecuteStringWithOptions(ctx, "while (true) {}", scriptEngine.ExecuteOptions{Timeout: 20 * time.Millisecond})
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindTimeout, se.Kind)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// 注册、检查函数与生命周期方法返回的错误同样是宿主错误
+	uninitialized, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	for _, err := range []error{
+		eng.Init(ctx),
+		eng.RegisterModule("m", &scriptEngine.NativeModule{}),
+		uninitialized.Close(),
+		uninitialized.RegisterGlobal("a", 1),
+		uninitialized.RegisterFunction("f", func() {}),
+		func() error { _, err := eng.InspectFunction("missing"); return err }(),
+		func() error { _, err := eng.GetGlobal("missing"); return err }(),
+	} {
+		assert.True(t, errors.As(err, &se), err)
+		assert.Equal(t, scriptEngine.ErrorKindHost, se.Kind, err)
+	}
+	assert.True(t, errors.Is(uninitialized.Close(), scriptEngine.ErrNotInitialized))
+}
+
+func TestSharedErrors(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+
+	_, err = eng.ExecuteString(ctx, `1`)
+	assert.True(t, errors.Is(err, scriptEngine.ErrNotInitialized))
+
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+	assert.True(t, errors.Is(eng.Init(ctx), scriptEngine.ErrAlreadyInitialized))
+
+	_, err = eng.ExecuteLoaded(ctx)
+	assert.True(t, errors.Is(err, scriptEngine.ErrNoProgramLoaded))
+
+	_, err = eng.CallFunction(ctx, "missing")
+	assert.True(t, errors.Is(err, scriptEngine.ErrFunctionNotFound))
+
+	assert.Nil(t, eng.RegisterGlobal("answer", 42))
+	_, err = eng.CallFunction(ctx, "answer")
+	assert.True(t, errors.Is(err, scriptEngine.ErrNotAFunction))
+
+	_, err = eng.ExecuteStringWithOptions(ctx, `while (true) {}`, scriptEngine.ExecuteOptions{Timeout: 20 * time.Millisecond})
+	assert.True(t, errors.Is(err, scriptEngine.ErrTimeout))
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = eng.ExecuteString(cancelCtx, `while (true) {}`)
+	assert.True(t, errors.Is(err, scriptEngine.ErrCancelled))
+}
+
+func TestEventLoop(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+	assert.Nil(t, eng.Init(ctx))
+
+	// 等待返回的 Promise 完成
+	result, err := eng.ExecuteString(ctx, `
+		const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms));
+		(async () => {
+			await sleep(10);
+			return 42;
+		})()
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), result)
+
+	_, err = eng.ExecuteString(ctx, `
+		async function fetchValue(x) {
+			await sleep(5);
+			return x * 2;
+		}
+		async function fail() {
+			await sleep(5);
+			throw new Error("boom");
+		}
+	`)
+	assert.Nil(t, err)
+
+	result, err = eng.CallFunction(ctx, "fetchValue", 21)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), result)
+
+	_, err = eng.CallFunction(ctx, "fail")
+	assert.True(t, errors.Is(err, ErrJavascriptPromiseRejected))
+	assert.Contains(t, err.Error(), "boom")
+
+	// setInterval 与 clearInterval，只剩 setInterval 注册的定时器时需要超时
+	result, err = eng.ExecuteStringWithOptions(ctx, `
+		new Promise((resolve) => {
+			let count = 0;
+			const id = setInterval(() => {
+				count++;
+				if (count === 3) {
+					clearInterval(id);
+					resolve(count);
+				}
+			}, 1);
+		})
+	`, scriptEngine.ExecuteOptions{Timeout: time.Second})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), result)
+
+	// 没有超时时，只剩 setInterval 注册的定时器的执行立即返回错误而不会一直运行
+	_, err = eng.ExecuteString(ctx, `setInterval(() => {}, 1)`)
+	assert.True(t, errors.Is(err, ErrJavascriptIntervalPending))
+
+	// 其他定时器未完成时 setInterval 照常运行
+	result, err = eng.ExecuteString(ctx, `
+		let ticks = 0;
+		const ticker = setInterval(() => { ticks++; }, 1);
+		sleep(20).then(() => { clearInterval(ticker); return ticks > 0; })
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, true, result)
+
+	// 未清除的定时器随 ctx 一同取消
+	_, err = eng.ExecuteStringWithOptions(ctx, `setInterval(() => {}, 1)`, scriptEngine.ExecuteOptions{
+		Timeout: 30 * time.Millisecond,
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// clearTimeout 取消的回调不会执行
+	result, err = eng.ExecuteString(ctx, `
+		let fired = false;
+		clearTimeout(setTimeout(() => { fired = true; }, 1));
+		sleep(10).then(() => fired)
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, false, result)
+}
+
+type greeter struct {
+	Prefix string
+}
+
+func (g greeter) Greet(name string) string {
+	return g.Prefix + name
+}
+
+func TestRegisterModule(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterModule("mathx", map[string]any{
+		"pi":  3.14,
+		"mul": func(a, b float64) float64 { return a * b },
+	}))
+	assert.Nil(t, eng.RegisterModule("greeter", greeter{Prefix: "hello "}))
+
+	// 模块不会出现在全局命名空间中
+	value, err := eng.ExecuteString(ctx, `typeof mathx`)
+	assert.Nil(t, err)
+	assert.Equal(t, "undefined", value)
+
+	value, err = eng.ExecuteString(ctx, `
+		const mathx = require("mathx");
+		mathx.mul(mathx.pi, 2);
+	`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 6.28, value)
+
+	value, err = eng.ExecuteString(ctx, `require("greeter").Greet("tom")`)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello tom", value)
+}
+
+func TestDecodeHelpers(t *testing.T) {
+	ctx := context.Background()
+
+	type Item struct {
+		Name  string `script:"name"`
+		Count int    `json:"count"`
+	}
+	type Order struct {
+		ID        int64     `json:"id"`
+		Items     []Item    `json:"items"`
+		CreatedAt time.Time `json:"createdAt"`
+	}
+
+	const source = `
+		var order = {id: 7, items: [{name: "apple", count: 2}, {name: "pear", count: 3}], createdAt: new Date(0)};
+		var broken = {id: 1, items: [{name: "apple", count: 1.5}]};
+		function makeOrder(id, n) {
+			return {id: id, items: [{name: "item", count: n}]};
+		}
+	`
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	pool, err := scriptEngine.NewEnginePool(1, scriptEngine.JavaScriptType)
+	assert.Nil(t, err)
+	defer pool.Close()
+
+	autoPool, err := scriptEngine.NewAutoGrowEnginePool(1, 1, scriptEngine.JavaScriptType)
+	assert.Nil(t, err)
+	defer autoPool.Close()
+
+	for _, target := range []interface {
+		scriptEngine.GlobalGetter
+		scriptEngine.FunctionCaller
+		ExecuteString(ctx context.Context, source string) (any, error)
+	}{eng, pool, autoPool} {
+		_, err = target.ExecuteString(ctx, source)
+		assert.Nil(t, err)
+
+		order, err := scriptEngine.GetGlobalAs[Order](target, "order")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(7), order.ID)
+		assert.Equal(t, []Item{{"apple", 2}, {"pear", 3}}, order.Items)
+		assert.Equal(t, int64(0), order.CreatedAt.Unix())
+
+		made, err := scriptEngine.CallAs[*Order](ctx, target, "makeOrder", 9, 4)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(9), made.ID)
+		assert.Equal(t, 4, made.Items[0].Count)
+
+		var items struct {
+			Items []Item `json:"items"`
+		}
+		assert.Nil(t, scriptEngine.CallFunctionInto(ctx, target, "makeOrder", &items, 1, 5))
+		assert.Equal(t, []Item{{"item", 5}}, items.Items)
+
+		_, err = scriptEngine.CallAs[[]int](ctx, target, "makeOrder", 1, 1)
+		assert.NotNil(t, err)
+
+		// 解码错误指出出错字段的路径
+		_, err = scriptEngine.GetGlobalAs[Order](target, "broken")
+		var decodeErr *scriptEngine.DecodeError
+		assert.True(t, errors.As(err, &decodeErr))
+		assert.Equal(t, "items[0].count", decodeErr.Path)
+	}
+}
+
+func TestBindFunction(t *testing.T) {
+	ctx := context.Background()
+
+	type Order struct {
+		Price    float64 `json:"price"`
+		Quantity int     `json:"quantity"`
+	}
+	type Price struct {
+		Total    float64 `json:"total"`
+		Currency string  `json:"currency"`
+	}
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	_, err = eng.ExecuteString(ctx, `
+		function calcPrice(price, quantity) {
+			return {total: price * quantity, currency: "CNY"};
+		}
+		function fail() {
+			throw new Error("boom");
+		}
+		function add(a, b = 1) {
+			return a + b;
+		}
+		function sum(first, ...rest) {
+			return rest.reduce((acc, x) => acc + x, first);
+		}
+		const scale = (x, factor = 10) => x * factor;
+		var notAFunction = 1;
+	`)
+	assert.Nil(t, err)
+
+	var calcPrice func(ctx context.Context, price float64, quantity int) (Price, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "calcPrice", &calcPrice))
+	for i := 1; i <= 3; i++ {
+		price, err := calcPrice(ctx, 2.5, i)
+		assert.Nil(t, err)
+		assert.Equal(t, Price{Total: 2.5 * float64(i), Currency: "CNY"}, price)
+	}
+
+	var fail func() error
+	assert.Nil(t, scriptEngine.BindFunction(eng, "fail", &fail))
+	assert.ErrorContains(t, fail(), "boom")
+
+	// 带默认值的参数可以省略
+	var add func(a, b int) (int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "add", &add))
+	n, err := add(2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	var increment func(a int) (int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "add", &increment))
+	n, err = increment(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	var scale func(x int) (int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "scale", &scale))
+	n, err = scale(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, n)
+
+	// 剩余参数接受任意个数的参数
+	var sum func(first int, rest ...int) (int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "sum", &sum))
+	n, err = sum(1, 2, 3, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	var sum3 func(a, b, c int) (int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "sum", &sum3))
+	n, err = sum3(1, 2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+
+	// Go 实现的函数接受任意个数的参数
+	var parseInt func(s string, radix int) (int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "parseInt", &parseInt))
+	n, err = parseInt("ff", 16)
+	assert.Nil(t, err)
+	assert.Equal(t, 255, n)
+
+	// 绑定时检查误用
+	var none func() (int, error)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "add", &none), scriptEngine.ErrArityMismatch)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "sum", &none), scriptEngine.ErrArityMismatch)
+	var tooMany func(order Order, extra int, more int) (Price, error)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "calcPrice", &tooMany), scriptEngine.ErrArityMismatch)
+	var variadic func(values ...float64) (Price, error)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "calcPrice", &variadic), scriptEngine.ErrArityMismatch)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "notAFunction", &calcPrice), scriptEngine.ErrNotAFunction)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "missing", &calcPrice), scriptEngine.ErrFunctionNotFound)
+
+	autoPool, err := scriptEngine.NewAutoGrowEnginePool(1, 1, scriptEngine.JavaScriptType)
+	assert.Nil(t, err)
+	defer autoPool.Close()
+	_, err = autoPool.ExecuteString(ctx, `function double(x) { return x * 2; }`)
+	assert.Nil(t, err)
+
+	var double func(x float64) (float64, error)
+	assert.Nil(t, scriptEngine.BindFunction(autoPool, "double", &double))
+	v, err := double(1.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, v)
+}
+
+type account struct {
+	UserID      int64 `json:"userId"`
+	DisplayName string
+	HTTPPort    int
+	Secret      string `json:"-"`
+}
+
+func (a account) Greeting() string {
+	return "hi " + a.DisplayName
+}
+
+func TestFieldNaming(t *testing.T) {
+	ctx := context.Background()
+	acc := account{UserID: 42, DisplayName: "alice", HTTPPort: 8080, Secret: "s3cret"}
+
+	for _, tc := range []struct {
+		naming                    scriptEngine.FieldNaming
+		id, display, port, method string
+	}{
+		{scriptEngine.FieldNamingGo, "UserID", "DisplayName", "HTTPPort", "Greeting"},
+		{scriptEngine.FieldNamingJSON, "userId", "DisplayName", "HTTPPort", "Greeting"},
+		{scriptEngine.FieldNamingLowerCamel, "userID", "displayName", "httpPort", "greeting"},
+		{scriptEngine.FieldNamingSnakeCase, "user_id", "display_name", "http_port", "greeting"},
+	} {
+		eng, err := newJavascriptEngine(scriptEngine.WithFieldNaming(tc.naming))
+		assert.Nil(t, err)
+		assert.Nil(t, eng.Init(ctx))
+
+		// 全局变量
+		assert.Nil(t, eng.RegisterGlobal("account", acc))
+		result, err := eng.ExecuteString(ctx, fmt.Sprintf(`account.%s + ":" + account.%s + ":" + account.%s + ":" + account.Secret`,
+			tc.id, tc.display, tc.port))
+		assert.Nil(t, err)
+		assert.Equal(t, "42:alice:8080:undefined", result, tc.naming)
+
+		// 函数参数与返回值
+		assert.Nil(t, eng.RegisterFunction("loadAccount", func() account { return acc }))
+		assert.Nil(t, eng.RegisterFunction("saveAccount", func(a account) string { return a.DisplayName }))
+		_, err = eng.ExecuteString(ctx, fmt.Sprintf(`
+			function displayOf(a) { return a.%[2]s; }
+			function roundTrip() { return saveAccount({%[1]s: 1, %[2]s: "bob"}); }
+			function loaded() { return loadAccount().%[1]s; }
+		`, tc.id, tc.display))
+		assert.Nil(t, err)
+
+		result, err = eng.CallFunction(ctx, "displayOf", acc)
+		assert.Nil(t, err)
+		assert.Equal(t, "alice", result, tc.naming)
+		result, err = eng.CallFunction(ctx, "roundTrip")
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", result, tc.naming)
+		result, err = eng.CallFunction(ctx, "loaded")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(42), result, tc.naming)
+
+		// 模块与方法
+		assert.Nil(t, eng.RegisterModule("acct", acc))
+		result, err = eng.ExecuteString(ctx, fmt.Sprintf(`require("acct").%s() + "/" + account.%[1]s()`, tc.method))
+		assert.Nil(t, err)
+		assert.Equal(t, "hi alice/hi alice", result, tc.naming)
+
+		assert.Nil(t, eng.Close())
+	}
+}
+
+func TestInt64Mode(t *testing.T) {
+	ctx := context.Background()
+	const id = int64(1<<62 + 1)
+
+	type Order struct {
+		ID    int64  `json:"id"`
+		Owner uint64 `json:"owner"`
+	}
+
+	// 默认转换为数字，超过 2^53 的整数丢失精度
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	_, err = eng.ExecuteString(ctx, `function echo(v) { return v; }`)
+	assert.Nil(t, err)
+	result, err := eng.CallFunction(ctx, "echo", id)
+	assert.Nil(t, err)
+	assert.NotEqual(t, id, result)
+	assert.Nil(t, eng.Close())
+
+	// BigInt
+	eng, err = newJavascriptEngine(scriptEngine.WithInt64Mode(scriptEngine.Int64AsObject), scriptEngine.WithFieldNaming(scriptEngine.FieldNamingJSON))
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterGlobal("order", Order{ID: id, Owner: math.MaxUint64}))
+	assert.Nil(t, eng.RegisterFunction("lookup", func(id int64) string { return fmt.Sprint("order-", id) }))
+	assert.Nil(t, eng.RegisterFunction("load", func() Order { return Order{ID: id} }))
+	_, err = eng.ExecuteString(ctx, `
+		function echo(v) { return v; }
+		function next(v) { return v + 1n; }
+		function describe() {
+			return typeof order.id + "/" + order.id + "/" + order.owner + "/" + (load().id === order.id);
+		}
+	`)
+	assert.Nil(t, err)
+
+	result, err = eng.CallFunction(ctx, "echo", id)
+	assert.Nil(t, err)
+	assert.Equal(t, id, result)
+
+	result, err = eng.CallFunction(ctx, "next", id)
+	assert.Nil(t, err)
+	assert.Equal(t, id+1, result)
+
+	result, err = eng.CallFunction(ctx, "describe")
+	assert.Nil(t, err)
+	assert.Equal(t, "bigint/4611686018427387905/18446744073709551615/true", result)
+
+	result, err = eng.ExecuteString(ctx, `lookup(4611686018427387905n)`)
+	assert.Nil(t, err)
+	assert.Equal(t, "order-4611686018427387905", result)
+
+	order, err := scriptEngine.GetGlobalAs[Order](eng, "order")
+	assert.Nil(t, err)
+	assert.Equal(t, Order{ID: id, Owner: math.MaxUint64}, order)
+
+	// struct 指针的 64 位整数字段同样可以写回
+	pending := &Order{ID: id}
+	assert.Nil(t, eng.RegisterGlobal("pending", pending))
+	_, err = eng.ExecuteString(ctx, `pending.id += 1n; pending.owner = 7n;`)
+	assert.Nil(t, err)
+	assert.Equal(t, &Order{ID: id + 1, Owner: 7}, pending)
+
+	// 十进制字符串
+	str, err := newJavascriptEngine(scriptEngine.WithInt64Mode(scriptEngine.Int64AsString))
+	assert.Nil(t, err)
+	assert.Nil(t, str.Init(ctx))
+	defer str.Close()
+
+	assert.Nil(t, str.RegisterFunction("lookup", func(id int64) string { return fmt.Sprint("order-", id) }))
+	_, err = str.ExecuteString(ctx, `function echo(v) { return [typeof v, lookup(v), {id: v}]; }`)
+	assert.Nil(t, err)
+
+	result, err = str.CallFunction(ctx, "echo", id)
+	assert.Nil(t, err)
+	values := result.([]any)
+	assert.Equal(t, "string", values[0])
+	assert.Equal(t, "order-4611686018427387905", values[1])
+
+	var decoded Order
+	assert.Nil(t, scriptEngine.Decode(values[2], &decoded))
+	assert.Equal(t, id, decoded.ID)
+}
+
+func TestBinaryData(t *testing.T) {
+	ctx := context.Background()
+
+	type Packet struct {
+		Payload []byte `json:"payload"`
+	}
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	shared := []byte("abc")
+	assert.Nil(t, eng.RegisterGlobal("blob", shared))
+	assert.Nil(t, eng.RegisterGlobal("packet", Packet{Payload: []byte{1, 2, 3}}))
+	assert.Nil(t, eng.RegisterFunction("checksum", func(b []byte) int {
+		sum := 0
+		for _, c := range b {
+			sum += int(c)
+		}
+		return sum
+	}))
+	assert.Nil(t, eng.RegisterFunction("load", func(name string) (any, error) {
+		return map[string]any{"name": name, "data": []byte(name)}, nil
+	}))
+
+	_, err = eng.ExecuteString(ctx, `
+		function inspect() {
+			blob[0] = 0x78;
+			return [blob instanceof Uint8Array, blob.length, packet.Payload instanceof Uint8Array, load("go").data[1]];
+		}
+		function sums() {
+			return [checksum(new Uint8Array([1, 2, 3])), checksum(new Uint8Array([4, 5]).buffer), checksum("hi")];
+		}
+		function makeBuffer() { return new Uint8Array([9, 8, 7]).buffer; }
+		function makePacket() { return { payload: new Uint8Array([4, 5]) }; }
+	`)
+	assert.Nil(t, err)
+
+	result, err := eng.CallFunction(ctx, "inspect")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{true, int64(3), true, int64('o')}, result)
+	assert.Equal(t, []byte("xbc"), shared)
+
+	result, err = eng.CallFunction(ctx, "sums")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(6), int64(9), int64('h' + 'i')}, result)
+
+	result, err = eng.CallFunction(ctx, "makeBuffer")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{9, 8, 7}, result)
+
+	packet, err := scriptEngine.CallAs[Packet](ctx, eng, "makePacket")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{4, 5}, packet.Payload)
+
+	// 包含 []byte 的 struct 指针读取字段时才转换，脚本的写入对 Go 可见，传回 Go 时仍是原来的指针
+	type Header struct {
+		Name string
+	}
+	type Frame struct {
+		Header
+		Meta    Header
+		Payload []byte
+	}
+	frame := &Frame{Header: Header{Name: "a"}, Payload: []byte{1}}
+	assert.Nil(t, eng.RegisterGlobal("frame", frame))
+	assert.Nil(t, eng.RegisterFunction("same", func(f *Frame) bool { return f == frame }))
+	result, err = eng.ExecuteString(ctx, `
+		frame.Name = "b";
+		frame.Meta.Name = "m";
+		frame.Payload = new Uint8Array([5, 6]);
+		frame.extra = 1;
+		[Object.keys(frame), frame.extra, same(frame), JSON.stringify(frame.Meta)]
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{[]any{"Name", "Meta", "Payload"}, nil, true, `{"Name":"m"}`}, result)
+	assert.Equal(t, &Frame{Header: Header{Name: "b"}, Meta: Header{Name: "m"}, Payload: []byte{5, 6}}, frame)
+
+	result, err = eng.ExecuteString(ctx, `frame`)
+	assert.Nil(t, err)
+	assert.Same(t, frame, result)
+}
+
+func TestHostContext(t *testing.T) {
+	type traceKey struct{}
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterFunction("whoami", func(ctx context.Context, prefix string) string {
+		return fmt.Sprint(prefix, ctx.Value(traceKey{}), "/", ctx.Value("tenant"))
+	}))
+	assert.Nil(t, eng.RegisterFunction("join", func(ctx context.Context, sep string, parts ...string) string {
+		return fmt.Sprint(ctx.Value("tenant"), sep, len(parts))
+	}))
+	_, err = eng.ExecuteString(ctx, `
+		function handle() {
+			return [whoami("id:"), ctx.value("tenant"), join("#", "a", "b"), ctx.deadline() !== null, ctx.err()];
+		}
+	`)
+	assert.Nil(t, err)
+
+	opts := scriptEngine.ExecuteOptions{Values: map[string]any{"tenant": "acme"}, Timeout: time.Second}
+	result, err := eng.CallFunctionWithOptions(ctx, "handle", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"id:trace-1/acme", "acme", "acme#2", true, nil}, result)
+
+	// ctx.deadline() 返回截止时间的 Unix 毫秒数
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	deadlineCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	result, err = eng.ExecuteString(deadlineCtx, `ctx.deadline()`)
+	assert.Nil(t, err)
+	assert.Equal(t, deadline.UnixMilli(), result)
+
+	// 执行结束后不再能读到本次执行的值
+	result, err = eng.ExecuteString(context.Background(), `[ctx.value("tenant"), ctx.deadline(), whoami("")]`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{nil, nil, "<nil>/<nil>"}, result)
+}
+
+// notFoundError 带错误码的宿主错误
+type notFoundError struct {
+	key string
+}
+
+func (e *notFoundError) Error() string     { return e.key + " not found" }
+func (e *notFoundError) ErrorCode() string { return "NOT_FOUND" }
+
+func TestErrorPropagation(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterFunction("lookup", func(key string) (string, error) {
+		return "", fmt.Errorf("lookup failed: %w", &notFoundError{key: key})
+	}))
+	_, err = eng.ExecuteString(ctx, `
+		function catchLookup() {
+			try {
+				lookup("user");
+			} catch (e) {
+				return [e instanceof Error, e.code, e.message, e.cause.message, e.cause.code];
+			}
+		}
+		function rethrowLookup() {
+			try {
+				lookup("user");
+			} catch (e) {
+				throw { code: "WRAPPED", message: "wrapped: " + e.message, cause: e };
+			}
+		}
+		function throwObject() {
+			throw { code: 42, message: "bad input", field: "name" };
+		}
+		async function rejectLookup() {
+			await null;
+			lookup("async");
+		}
+	`)
+	assert.Nil(t, err)
+
+	// Go 错误在脚本中可以被 try/catch 捕获，并保留错误码与被包装的错误
+	result, err := eng.CallFunction(ctx, "catchLookup")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{true, "NOT_FOUND", "lookup failed: user not found", "user not found", "NOT_FOUND"}, result)
+
+	var (
+		se       *scriptEngine.ScriptError
+		thrown   *scriptEngine.ThrownError
+		notFound *notFoundError
+	)
+
+	// 未捕获的 Go 错误原样返回
+	_, err = eng.ExecuteString(ctx, `lookup("order")`)
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindHost, se.Kind)
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "order", notFound.key)
+
+	// 经过脚本包装后仍可取回原始错误
+	_, err = eng.CallFunction(ctx, "rethrowLookup")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindRuntime, se.Kind)
+	assert.True(t, errors.As(err, &thrown))
+	assert.Equal(t, "WRAPPED", thrown.Code)
+	assert.Equal(t, "wrapped: lookup failed: user not found", thrown.Message)
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "user", notFound.key)
+
+	// 脚本抛出的对象解码为 Go 值
+	_, err = eng.CallFunction(ctx, "throwObject")
+	assert.True(t, errors.As(err, &thrown))
+	assert.Equal(t, "42", thrown.Code)
+	assert.Equal(t, "bad input", thrown.Message)
+	assert.Nil(t, thrown.Cause)
+	var payload struct {
+		Code  int    `json:"code"`
+		Field string `json:"field"`
+	}
+	assert.Nil(t, thrown.Decode(&payload))
+	assert.Equal(t, 42, payload.Code)
+	assert.Equal(t, "name", payload.Field)
+
+	// 被拒绝的 Promise 同样可以取回原始错误
+	_, err = eng.CallFunction(ctx, "rejectLookup")
+	assert.True(t, errors.Is(err, ErrJavascriptPromiseRejected))
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "async", notFound.key)
+}
+
+func TestModuleLoader(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"lib/util.js":          {Data: []byte(`const helper = require("./helper"); exports.greet = (name) => helper.prefix + name;`)},
+		"lib/helper.js":        {Data: []byte(`module.exports = { prefix: "hi " };`)},
+		"vendor/json/index.js": {Data: []byte(`module.exports = { name: "json" };`)},
+		"data/config.json":     {Data: []byte(`{"port": 8080}`)},
+	}
+
+	eng, err := newJavascriptEngine(scriptEngine.WithModuleFS(fsys, "vendor"))
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	result, err := eng.ExecuteString(ctx, `[
+		require("./lib/util").greet("bob"),
+		require("json").name,
+		require("./data/config.json").port,
+		require("./lib/util") === require("/lib/util.js"),
+	]`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"hi bob", "json", int64(8080), true}, result)
+
+	_, err = eng.ExecuteString(ctx, `require("./missing")`)
+	assert.NotNil(t, err)
+}
+
+type user struct {
+	Name  string
+	token string
+}
+
+func (u *user) SetToken(t string) {
+	u.token = t
+}
+
+func (u *user) Token() string {
+	return u.token
+}
+
+func TestNodeModules(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("default", func(t *testing.T) {
+		eng, err := newJavascriptEngine()
+		assert.Nil(t, err)
+		assert.Nil(t, eng.Init(ctx))
+		defer eng.Close()
+
+		result, err := eng.ExecuteString(ctx, `[typeof require, typeof console, typeof process, typeof Buffer, typeof URL]`)
+		assert.Nil(t, err)
+		assert.Equal(t, []any{"function", "undefined", "undefined", "undefined", "undefined"}, result)
+
+		for _, name := range []string{"util", "node:util", "console", "process", "buffer", "url"} {
+			_, err = eng.ExecuteString(ctx, fmt.Sprintf(`require(%q)`, name))
+			assert.NotNil(t, err, name)
+		}
+	})
+
+	t.Run("all", func(t *testing.T) {
+		eng, err := newJavascriptEngine(scriptEngine.WithNodeModules(scriptEngine.NodeAll))
+		assert.Nil(t, err)
+		assert.Nil(t, eng.Init(ctx))
+		defer eng.Close()
+
+		result, err := eng.ExecuteString(ctx, `[
+			new URL("https://example.com/a?x=1").searchParams.get("x"),
+			Buffer.from("hi").toString("hex"),
+			require("util").format("%s-%d", "a", 1),
+			require("node:util") === require("util"),
+			typeof process.env,
+			require("console") === console,
+		]`)
+		assert.Nil(t, err)
+		assert.Equal(t, []any{"1", "6869", "a-1", true, "object", true}, result)
+
+		_, err = eng.ExecuteFile(ctx, "./script/test_require.js")
+		assert.Nil(t, err)
+
+		u := &user{Name: "Tim"}
+		assert.Nil(t, eng.RegisterGlobal("u", u))
+		assert.Nil(t, eng.RegisterFunction("sayHello", func(msg string) {
+			fmt.Printf("golang say Hello, %s.\n", msg)
+		}))
+		_, err = eng.ExecuteFile(ctx, "./script/test.js")
+		assert.Nil(t, err)
+		assert.Equal(t, "dddd", u.Token())
+
+		_, err = eng.CallFunction(ctx, "printMessage", "hello")
+		assert.Nil(t, err)
+	})
+
+	t.Run("selected", func(t *testing.T) {
+		eng, err := newJavascriptEngine(scriptEngine.WithNodeModules(scriptEngine.NodeRequire | scriptEngine.NodeConsole))
+		assert.Nil(t, err)
+		assert.Nil(t, eng.Init(ctx))
+		defer eng.Close()
+
+		result, err := eng.ExecuteString(ctx, `console.log("hello"); [typeof console.log, typeof process, typeof Buffer]`)
+		assert.Nil(t, err)
+		assert.Equal(t, []any{"function", "undefined", "undefined"}, result)
+
+		// console 依赖的 util 模块未启用时不能加载
+		_, err = eng.ExecuteString(ctx, `require("util")`)
+		assert.NotNil(t, err)
+		_, err = eng.ExecuteString(ctx, `require("node:url")`)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("without require", func(t *testing.T) {
+		eng, err := newJavascriptEngine(scriptEngine.WithNodeModules(scriptEngine.NodeConsole))
+		assert.Nil(t, err)
+		assert.Nil(t, eng.Init(ctx))
+		defer eng.Close()
+
+		result, err := eng.ExecuteString(ctx, `[typeof require, typeof console.log]`)
+		assert.Nil(t, err)
+		assert.Equal(t, []any{"undefined", "function"}, result)
+	})
+}
+
+func TestESModules(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"lib/math.mjs": {Data: []byte(`export function add(a, b) { return a + b }
+export const PI = 3.14, E = 2.72
+export default function mul(a, b) { return a * b }
+`)},
+		"lib/greet.js": {Data: []byte(`import { add } from "./math.mjs"
+import mul, * as math from "./math.mjs"
+import legacy from "./legacy.js"
+export * from "./math.mjs"
+export { add as plus, default as times } from "./math.mjs"
+
+let calls = 0
+export const greet = (name) => { calls++; return "hi " + name + " " + add(1, 2) + mul(2, 3) + math.E }
+export { calls, legacy }
+export async function later() {
+	const m = await import("./math.mjs")
+	return m.PI + "/" + m.default(1, 2)
+}
+export default { name: "greet", pattern: /export {}/g.source, text: ` + "`import ${legacy.name}`" + ` }
+`)},
+		"lib/legacy.js": {Data: []byte(`module.exports = { name: "legacy" };`)},
+		"lib/broken.js": {Data: []byte("const a = 1\nexport { a as }\n")},
+		"lib/quoted.mjs": {Data: []byte(`import { add } from "./math.mjs"
+export function quoted(s) {
+	let n = 0
+	if (s) /'/.test(s) && n++
+	for (const c of s) {
+		if (c === "/") n = add(n, 1)
+	}
+	/export {}/.test(s) && n++
+	return n
+}
+`)},
+		"lib/arrow.mjs": {Data: []byte(`export let count = 0
+export const inc = () => {
+	count++
+}
+/'/.test("it's") && inc()
+export const half = (n) => n / 2 / 1
+`)},
+		"lib/plain.js": {Data: []byte(`let n = 0
+n++
+{
+	n++
+}
+/'/.test("it's") && n++
+module.exports = { n: n }
+`)},
+	}
+
+	eng, err := newJavascriptEngine(scriptEngine.WithESModules(true), scriptEngine.WithModuleFS(fsys))
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	result, err := eng.ExecuteString(ctx, `
+		import greeting, { greet, plus, times, PI, calls } from "./lib/greet.js"
+		export const out = [greet("bob"), plus(2, 2), times(2, 2), PI, greeting.name, greeting.pattern, greeting.text, calls]
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{
+		"out": []any{"hi bob 362.72", int64(4), int64(4), 3.14, "greet", "export {}", "import legacy", int64(1)},
+	}, result)
+
+	// 模块只执行一次，ExecuteFile 与 CallFunction 使用同一个模块实例
+	result, err = eng.ExecuteFile(ctx, "lib/greet.js")
+	assert.Nil(t, err)
+	namespace, ok := result.(map[string]any)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), namespace["calls"])
+	assert.Equal(t, map[string]any{"name": "legacy"}, namespace["legacy"])
+
+	greeting, err := eng.CallFunction(ctx, "lib/greet.js#greet", "tom")
+	assert.Nil(t, err)
+	assert.Equal(t, "hi tom 362.72", greeting)
+
+	later, err := eng.CallFunction(ctx, "lib/greet.js#later")
+	assert.Nil(t, err)
+	assert.Equal(t, "3.14/2", later)
+
+	_, err = eng.CallFunction(ctx, "lib/greet.js#missing")
+	assert.True(t, errors.Is(err, ErrJavascriptFunctionNotFound))
+
+	_, err = eng.ExecuteFile(ctx, "lib/broken.js")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "lib/broken.js")
+
+	// 条件与语句块之后的 / 开始正则表达式，goja 能够解析的 CommonJS 模块不经过改写
+	result, err = eng.ExecuteString(ctx, `
+		import { quoted } from "./lib/quoted.mjs"
+		import plain from "./lib/plain.js"
+		export const out = [quoted("it's /export {}"), plain.n]
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"out": []any{int64(3), int64(3)}}, result)
+
+	// 箭头函数体之后的 / 开始正则表达式；导入的绑定是活绑定
+	result, err = eng.ExecuteString(ctx, `
+		import { count, inc, half } from "./lib/arrow.mjs"
+		const before = count
+		inc()
+		export const out = [before, count, half(8)]
+		export const { a, b: [c] } = { a: 1, b: [2] }
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"out": []any{int64(1), int64(2), int64(4)}, "a": int64(1), "c": int64(2)}, result)
+
+	// 模板字符串中的动态 import()
+	result, err = eng.ExecuteString(ctx, "export const out = `${typeof import(\"./lib/math.mjs\").then}`")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"out": "function"}, result)
+
+	// 错误位置指向原始源码
+	var se *scriptEngine.ScriptError
+	_, err = eng.ExecuteString(ctx, "export function bad() { null.x }\nbad()")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindRuntime, se.Kind)
+	assert.Equal(t, 1, se.Line)
+	assert.Equal(t, 30, se.Column)
+
+	_, err = eng.ExecuteString(ctx, "export const a = 1\nconst b = ;")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindCompile, se.Kind)
+	assert.Equal(t, 2, se.Line)
+	assert.Equal(t, 11, se.Column)
+
+	// 不支持的语法返回明确的错误
+	for source, message := range map[string]string{
+		"const a = 1\nexport const b = import.meta": "import.meta is not supported",
+		"const a = 1\nawait a":                      "top-level await is not supported",
+		"if (true) {\nexport const a = 1\n}":        "import and export statements must be at the top level of a module",
+	} {
+		_, err = eng.ExecuteString(ctx, source)
+		assert.True(t, errors.As(err, &se), source)
+		assert.Equal(t, scriptEngine.ErrorKindCompile, se.Kind, source)
+		assert.Equal(t, 2, se.Line, source)
+		assert.Contains(t, se.Message, message, source)
+	}
+}
+
+func TestScriptRoots(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"lib/helper.js":   `module.exports = { name: "helper" };`,
+		"scripts/main.js": `require("helper").name + "/main"`,
+	} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644))
+	}
+
+	eng, err := newJavascriptEngine(scriptEngine.WithWorkDir(dir), scriptEngine.WithScriptRoots("lib", "scripts"))
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	result, err := eng.ExecuteFile(ctx, "main.js")
+	assert.Nil(t, err)
+	assert.Equal(t, "helper/main", result)
+
+	result, err = eng.ExecuteFile(ctx, "scripts/main.js")
+	assert.Nil(t, err)
+	assert.Equal(t, "helper/main", result)
+
+	_, err = eng.ExecuteFile(ctx, "missing.js")
+	assert.NotNil(t, err)
+}
+
+func TestNativeModule(t *testing.T) {
+	ctx := context.Background()
+	module := &scriptEngine.NativeModule{
+		Name: "nativestr",
+		Functions: map[string]any{
+			"upper": strings.ToUpper,
+			"repeat": func(ctx context.Context, s string, n int) (string, error) {
+				if n < 0 {
+					return "", errors.New("negative count")
+				}
+				return strings.Repeat(s, n), nil
+			},
+		},
+		Constants: map[string]any{"version": "1.0"},
+		Modules: []*scriptEngine.NativeModule{{
+			Name:      "path",
+			Functions: map[string]any{"join": func(a, b string) string { return a + "/" + b }},
+		}},
+	}
+
+	eng, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterModule(module.Name, module))
+	result, err := eng.ExecuteString(ctx, `
+		const str = require("nativestr");
+		const path = require("nativestr/path");
+		[str.upper("abc"), str.repeat("ab", 2), str.version, str.path.join("a", "b"), str.path === path]
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"ABC", "abab", "1.0", "a/b", true}, result)
+
+	_, err = eng.ExecuteString(ctx, `require("nativestr").repeat("ab", -1)`)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "negative count")
+
+	err = eng.RegisterModule("nativebad", &scriptEngine.NativeModule{Name: "nativebad", Functions: map[string]any{"f": 1}})
+	assert.True(t, errors.Is(err, scriptEngine.ErrInvalidModule))
+
+	// Manager 记录的模块注册到已初始化的引擎与之后初始化的引擎
+	manager := scriptEngine.NewManager()
+	defer manager.CloseAll()
+	first, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, manager.Register("first", first))
+	assert.Nil(t, manager.RegisterNativeModule(&scriptEngine.NativeModule{Name: "nativemgr", Constants: map[string]any{"answer": 42}}))
+	assert.Nil(t, manager.InitAll(ctx))
+	second, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, second.Init(ctx))
+	assert.Nil(t, manager.Register("second", second))
+	for _, e := range []scriptEngine.Engine{first, second} {
+		result, err = e.ExecuteString(ctx, `require("nativemgr").answer`)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(42), result)
+	}
+
+	// 通过 Manager.Init 初始化的引擎注册模块，关闭后重新初始化时再次注册
+	third, err := newJavascriptEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, manager.Register("third", third))
+	assert.Nil(t, manager.RegisterNativeModule(&scriptEngine.NativeModule{Name: "nativelazy", Constants: map[string]any{"answer": 43}}))
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, manager.Init(ctx, "third"))
+		got, ok := manager.Get("third")
+		assert.True(t, ok)
+		result, err = got.ExecuteString(ctx, `require("nativelazy").answer`)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(43), result)
+		assert.Nil(t, got.Close())
+	}
+	assert.NotNil(t, manager.Init(ctx, "missing"))
+
+	// 引擎池注册的模块对已借出与按需创建的引擎同样生效
+	pool, err := scriptEngine.NewAutoGrowEnginePool(1, 2, scriptEngine.JavaScriptType)
+	assert.Nil(t, err)
+	defer pool.Close()
+	borrowed, err := pool.Acquire()
+	assert.Nil(t, err)
+	assert.Nil(t, pool.RegisterNativeModule(&scriptEngine.NativeModule{Name: "nativepool", Constants: map[string]any{"answer": 42}}))
+	pool.Release(borrowed)
+	for i := 0; i < 2; i++ {
+		result, err = pool.ExecuteString(ctx, `require("nativepool").answer`)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(42), result)
+	}
+}
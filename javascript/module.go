@@ -0,0 +1,222 @@
+package js
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/buffer"
+	"github.com/dop251/goja_nodejs/console"
+	"github.com/dop251/goja_nodejs/process"
+	"github.com/dop251/goja_nodejs/require"
+	"github.com/dop251/goja_nodejs/url"
+	"github.com/dop251/goja_nodejs/util"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+// nodeModules Node.js 兼容模块与其模块名、导出到全局命名空间的成员，与模块名相同的成员表示整个模块
+var nodeModules = []struct {
+	module  scriptEngine.NodeModule
+	name    string
+	globals []string
+}{
+	{scriptEngine.NodeUtil, util.ModuleName, nil},
+	{scriptEngine.NodeConsole, console.ModuleName, []string{console.ModuleName}},
+	{scriptEngine.NodeProcess, process.ModuleName, []string{process.ModuleName}},
+	{scriptEngine.NodeBuffer, buffer.ModuleName, []string{"Buffer"}},
+	{scriptEngine.NodeURL, url.ModuleName, []string{"URL", "URLSearchParams"}},
+}
+
+// registryOptions 返回 require 模块注册表的选项。设置了 ModuleLoader 时，require 从其加载模块文件：
+// 路径以 / 分隔并相对于 ModuleLoader 的根目录，以 / 开头的路径同样从根目录解析，相对路径从调用方模块所在的目录解析，
+// 非相对的模块名先在 ModulePaths 中查找，再按 Node.js 的规则查找 node_modules。
+// 没有设置 ModuleLoader 时从文件系统加载，非相对的模块名在 ScriptRoots 中查找。
+// 模块模式下 .js 与 .mjs 文件中的 ES 模块语法在加载时被改写为 CommonJS，加载的模块插入计量代码。
+func (e *engine) registryOptions() []require.Option {
+	loader := e.options.ModuleLoader
+	var opts []require.Option
+	load := require.DefaultSourceLoader
+	if loader == nil {
+		if len(e.options.ScriptRoots) > 0 {
+			roots := make([]string, len(e.options.ScriptRoots))
+			for i, root := range e.options.ScriptRoots {
+				roots[i] = e.options.ResolvePath(root)
+			}
+			opts = append(opts, require.WithGlobalFolders(roots...))
+		}
+	} else {
+		load = func(p string) ([]byte, error) {
+			p = strings.TrimPrefix(path.Clean(p), "/")
+			if !fs.ValidPath(p) {
+				return nil, require.ModuleFileDoesNotExistError
+			}
+			data, err := loader.LoadModule(p)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, require.ModuleFileDoesNotExistError
+			}
+			return data, err
+		}
+		opts = append(opts,
+			require.WithPathResolver(func(base, p string) string {
+				return strings.TrimPrefix(path.Join(base, p), "/")
+			}),
+			require.WithGlobalFolders(e.options.ModulePaths...),
+		)
+	}
+
+	if e.options.ESModules {
+		source := load
+		load = func(p string) ([]byte, error) {
+			data, err := source(p)
+			if err != nil || (path.Ext(p) != ".js" && path.Ext(p) != ".mjs") {
+				return data, err
+			}
+			code, err := transformModule(p, string(data))
+			return []byte(code), err
+		}
+	}
+
+	source := load
+	load = func(p string) ([]byte, error) {
+		data, err := source(p)
+		if err != nil {
+			return nil, err
+		}
+		return e.instrumentModule(p, data)
+	}
+
+	return append(opts, require.WithLoader(load))
+}
+
+// modulePath 将 ExecuteFile 等方法的文件路径转换为 require 的模块路径：设置了 ModuleLoader 时相对于其根目录，
+// 否则按 Options.ScriptPath 解析
+func (e *engine) modulePath(p string) string {
+	if e.options.ModuleLoader == nil {
+		p = e.options.ScriptPath(p)
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	p = filepath.ToSlash(p)
+	if strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") {
+		return p
+	}
+	return "./" + p
+}
+
+// compileModule 将字符串源码编译为匿名 ES 模块，程序的结果为模块的命名空间
+func compileModule(name, source string) (*goja.Program, error) {
+	m, err := rewriteModule(name, source, true)
+	if err != nil {
+		return nil, err
+	}
+	// 包装函数与模块开头的声明位于第一行，源码的行号保持不变
+	code := `(function(){const module={exports:{}};(function(exports,require,module,__filename,__dirname){` + m.code +
+		"\n}).call(module.exports,module.exports,require,module," + strconv.Quote(name) + `,".");return module.exports})()` +
+		"\n" + m.sourceMapComment(name)
+	return compileProgram(name, code, false)
+}
+
+// requireProgram 返回通过 require 加载模块文件 filePath 的程序，程序的结果为模块的命名空间
+func (e *engine) requireProgram(filePath string) (*goja.Program, error) {
+	return compileProgram("", "require("+strconv.Quote(e.modulePath(filePath))+")", false)
+}
+
+// moduleFunction 返回 "模块路径#函数名" 形式的 name 对应的模块导出成员，name 不是该形式时返回 nil。调用方需持有 runtime。
+func (e *engine) moduleFunction(rt *goja.Runtime, name string) (goja.Value, error) {
+	module, member, ok := strings.Cut(name, "#")
+	if !ok || !e.options.ESModules {
+		return nil, nil
+	}
+	requireFn, ok := goja.AssertFunction(rt.Get("require"))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJavascriptFunctionNotFound, name)
+	}
+	exports, err := requireFn(goja.Undefined(), rt.ToValue(e.modulePath(module)))
+	if err != nil {
+		return nil, err
+	}
+	v := exports.ToObject(rt).Get(member)
+	if v == nil {
+		return nil, fmt.Errorf("%w: %s", ErrJavascriptFunctionNotFound, name)
+	}
+	return v, nil
+}
+
+// enableNodeModules 按 Options.NodeModules 启用 Node.js 兼容模块：将启用的模块导出到全局命名空间，
+// 并包装 require，使未启用的模块（含 node: 前缀的写法）无法加载。未启用 NodeRequire 时删除全局 require。
+func (e *engine) enableNodeModules(rt *goja.Runtime, registry *require.Registry) error {
+	enabled := e.options.NodeModules
+	if e.options.ESModules {
+		enabled |= scriptEngine.NodeRequire
+	}
+	rm := registry.Enable(rt)
+
+	disabled := make(map[string]bool)
+	for _, m := range nodeModules {
+		if enabled&m.module == 0 {
+			disabled[m.name] = true
+			continue
+		}
+		if len(m.globals) == 0 {
+			continue
+		}
+
+		exports, err := rm.Require(m.name)
+		if err != nil {
+			return err
+		}
+		for _, name := range m.globals {
+			if name == m.name {
+				_ = rt.Set(name, exports)
+				continue
+			}
+			_ = rt.Set(name, exports.ToObject(rt).Get(name))
+		}
+	}
+
+	if enabled&scriptEngine.NodeRequire == 0 {
+		rt.GlobalObject().Delete("require")
+		return nil
+	}
+
+	// 模块文件中的 require 同样取自全局 require，包装后对所有模块生效
+	requireFn, _ := goja.AssertFunction(rt.Get("require"))
+	return rt.Set("require", func(call goja.FunctionCall) goja.Value {
+		name := call.Argument(0).String()
+		if disabled[strings.TrimPrefix(name, "node:")] {
+			panic(rt.NewGoError(fmt.Errorf("%w: %s", require.NoSuchBuiltInModuleError, name)))
+		}
+		v, err := requireFn(goja.Undefined(), call.Arguments...)
+		if err != nil {
+			panic(err)
+		}
+		return v
+	})
+}
+
+// registerNativeModule 注册 NativeModule 及其子模块，子模块注册为 name/子模块名，
+// 模块中与子模块同名的成员通过 require 取得，与单独加载的子模块为同一个对象
+func (e *engine) registerNativeModule(name string, module *scriptEngine.NativeModule) {
+	for _, sub := range module.Modules {
+		e.registerNativeModule(name+"/"+sub.Name, sub)
+	}
+
+	e.registry.RegisterNativeModule(name, func(rt *goja.Runtime, m *goja.Object) {
+		exports := m.Get("exports").(*goja.Object)
+		for _, members := range []map[string]any{module.Functions, module.Constants} {
+			for key, member := range members {
+				_ = exports.Set(key, e.toValue(rt, member))
+			}
+		}
+		for _, sub := range module.Modules {
+			_ = exports.Set(sub.Name, require.Require(rt, name+"/"+sub.Name))
+		}
+	})
+}
@@ -1,4 +1,4 @@
-var m = require("./script/m.js");
+var m = require("./m.js");
 m.test();
 m.sayHi('Tom');
 
@@ -1,3 +1,3 @@
-var m = require("./script/m.js");
+var m = require("./m.js");
 m.test();
 m.sayHi('Tom');
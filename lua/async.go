@@ -0,0 +1,340 @@
+package lua
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+
+	Lua "github.com/yuin/gopher-lua"
+)
+
+// Future 异步宿主函数的执行结果，由 Resolve 或 Reject 完成，只有第一次完成生效
+type Future struct {
+	done chan struct{}
+	once sync.Once
+
+	values []any
+	err    error
+}
+
+// NewFuture 创建未完成的 Future
+func NewFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Go 在新的 goroutine 中执行 fn，返回代表其结果的 Future
+func Go(fn func() ([]any, error)) *Future {
+	f := NewFuture()
+	go func() {
+		values, err := fn()
+		if err != nil {
+			f.Reject(err)
+			return
+		}
+		f.Resolve(values...)
+	}()
+	return f
+}
+
+// Resolve 以 values 作为返回值完成 Future
+func (f *Future) Resolve(values ...any) {
+	f.once.Do(func() {
+		f.values = values
+		close(f.done)
+	})
+}
+
+// Reject 以错误完成 Future，错误在脚本中以 Lua 错误的形式抛出
+func (f *Future) Reject(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
+
+// Done 返回 Future 完成时关闭的 channel
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result 返回 Future 的结果，需在 Future 完成后调用
+func (f *Future) Result() ([]any, error) {
+	return f.values, f.err
+}
+
+// AsyncFunction 异步宿主函数。函数应尽快返回 Future，耗时操作在 Future 中完成；
+// ctx 为本次执行的 context。
+type AsyncFunction func(ctx context.Context, args ...any) *Future
+
+// asyncChunk 驱动异步调用的 Lua 代码，返回两个函数：
+//   - 第一个在协程中调用 f 并以 pack 打包全部返回值，调用前先让出一次，以便 resume 设置协程出错时的处理；
+//   - 第二个将启动异步调用的宿主函数 start 包装为脚本调用的函数，settle 按 Future 的结果返回值或抛出错误。
+//
+// 协程出错时 resume 从调用栈中去掉 asyncChunk 的帧。
+const asyncChunk = `local ready, pack, settle = ...
+return function(f, ...)
+	ready()
+	return pack(f(...))
+end, function(start)
+	return function(...)
+		return settle(start(...))
+	end
+end`
+
+// asyncChunkName asyncChunk 的代码块名
+const asyncChunkName = "[async]"
+
+// vmLoops gopher-lua 逐条执行指令的函数，每次由 Go 发起的 Lua 调用都会在 Go 调用栈上嵌套一层
+var vmLoops = map[string]bool{
+	"github.com/yuin/gopher-lua.mainLoop":            true,
+	"github.com/yuin/gopher-lua.mainLoopWithContext": true,
+}
+
+// pendingCall 让出协程的异步调用，由 resume 等待 future 完成后恢复协程
+type pendingCall struct {
+	ctx    context.Context
+	future *Future
+}
+
+// RegisterAsyncFunction 注册一个全局的异步方法到lua。
+// 脚本调用异步方法时，执行脚本的协程让出，由引擎等待 Future 完成后以其结果恢复协程；
+// 由引擎创建的虚拟机在等待期间释放引擎锁，因此引擎可以执行其他调用。Future 失败时在调用处抛出由 errorValue 转换的错误对象。
+//
+// gopher-lua 无法跨越 pcall、元方法、迭代器与脚本创建的协程等由 Go 发起的调用让出协程，
+// 在这些调用中调用异步方法时，执行脚本的 goroutine 阻塞等待 Future，等待期间同样释放引擎锁。
+func (e *virtualMachine) RegisterAsyncFunction(name string, fn AsyncFunction) error {
+	lf, err := e.newAsyncFunction(name, fn)
+	if err != nil {
+		return err
+	}
+	e.L.SetGlobal(name, lf)
+	return nil
+}
+
+// newAsyncFunction 将异步宿主函数包装为 Lua 函数
+func (e *virtualMachine) newAsyncFunction(name string, fn AsyncFunction) (*Lua.LFunction, error) {
+	if err := e.openAsync(); err != nil {
+		return nil, err
+	}
+
+	start := e.L.NewFunction(func(L *Lua.LState) int {
+		// 异步函数收到的 Lua 函数在脚本等待期间调用，需要获取引擎锁
+		previous := e.hostActive
+		e.hostActive = nil
+		defer func() {
+			e.hostActive = previous
+		}()
+
+		args := make([]any, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			args = append(args, e.convertFromLValue(L.Get(i)))
+		}
+
+		ctx := e.context(L)
+
+		f := fn(ctx, args...)
+		if f == nil {
+			L.RaiseError("async function %s returned a nil future", name)
+		}
+
+		if e.yieldable(L) {
+			ud := L.NewUserData()
+			ud.Value = &pendingCall{ctx: ctx, future: f}
+			return L.Yield(ud)
+		}
+
+		// 无法让出时阻塞等待，等待期间其他调用可能切换当前协程
+		current := L.G.CurrentThread
+		err := e.wait(ctx, f)
+		L.G.CurrentThread = current
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+
+		values := e.settled(f)
+		for _, v := range values {
+			L.Push(v)
+		}
+		return len(values)
+	})
+
+	if err := e.L.CallByParam(Lua.P{Fn: e.asyncWrap, NRet: 1, Protect: true}, start); err != nil {
+		return nil, err
+	}
+	lf := e.L.Get(-1).(*Lua.LFunction)
+	e.L.Pop(1)
+	return lf, nil
+}
+
+// openAsync 加载 asyncChunk，此后 call 在由 resume 驱动的协程中执行
+func (e *virtualMachine) openAsync() error {
+	if e.async {
+		return nil
+	}
+
+	chunk, err := e.L.Load(strings.NewReader(asyncChunk), asyncChunkName)
+	if err != nil {
+		return err
+	}
+
+	ready := e.L.NewFunction(func(L *Lua.LState) int {
+		return L.Yield()
+	})
+	pack := e.L.NewFunction(func(L *Lua.LState) int {
+		n := L.GetTop()
+		L.Insert(Lua.LNumber(n), 1)
+		return n + 1
+	})
+	settle := e.L.NewFunction(func(L *Lua.LState) int {
+		if !Lua.LVAsBool(L.Get(1)) {
+			L.Error(L.Get(2), 1)
+		}
+		return L.GetTop() - 1
+	})
+
+	if err := e.L.CallByParam(Lua.P{Fn: chunk, NRet: 2, Protect: true}, ready, pack, settle); err != nil {
+		return err
+	}
+	e.asyncDrive = e.L.Get(-2).(*Lua.LFunction)
+	e.asyncWrap = e.L.Get(-1).(*Lua.LFunction)
+	e.L.Pop(2)
+	e.async = true
+	return nil
+}
+
+// yieldable 报告正在调用宿主函数的协程 L 能否让出：L 须是 resume 驱动的协程，
+// 且 Go 调用栈上只有一层虚拟机循环，即宿主函数与 resume 之间没有 pcall、元方法等由 Go 发起的调用。
+func (e *virtualMachine) yieldable(L *Lua.LState) bool {
+	if L.Parent != e.L || L.G.CurrentThread != L {
+		return false
+	}
+
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	if n == len(pcs) {
+		// 调用栈过深时无法确认，按不能让出处理
+		return false
+	}
+
+	loops := 0
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if vmLoops[frame.Function] {
+			loops++
+		}
+		if !more {
+			break
+		}
+	}
+	return loops == 1
+}
+
+// resume 在新的协程中调用 fn 并驱动协程直到结束，返回 fn 的全部返回值。
+// 协程因调用异步函数让出时等待 Future 完成，等待期间释放引擎锁，Future 完成后以其结果恢复协程。
+func (e *virtualMachine) resume(fn Lua.LValue, args []Lua.LValue) ([]Lua.LValue, error) {
+	callStackSize := e.L.Options.CallStackSize
+	if e.maxStack > 0 {
+		callStackSize = e.maxStack
+	}
+	th := e.newThread(callStackSize)
+
+	// Resume 启动协程时以不带调用栈的处理替换 Panic，协程在入口处让出后再换回带调用栈的处理，并记录调用栈
+	tracebackPanic := th.Panic
+	var trace string
+	catchTrace := func(L *Lua.LState) {
+		defer func() {
+			rcv := recover()
+			if apiErr, ok := rcv.(*Lua.ApiError); ok {
+				trace = apiErr.StackTrace
+			}
+			panic(rcv)
+		}()
+		tracebackPanic(L)
+	}
+
+	values := append([]Lua.LValue{fn}, args...)
+	for started := false; ; started = true {
+		state, err, results := e.L.Resume(th, e.asyncDrive, values...)
+		switch state {
+		case Lua.ResumeError:
+			if apiErr, ok := err.(*Lua.ApiError); ok && apiErr.StackTrace == "" {
+				apiErr.StackTrace = stripAsyncFrames(trace)
+			}
+			return nil, err
+		case Lua.ResumeOK:
+			n := int(Lua.LVAsNumber(results[0]))
+			return results[1 : n+1], nil
+		}
+
+		if !started {
+			th.Panic = catchTrace
+			values = nil
+			continue
+		}
+
+		pending, ok := yieldedCall(results[0])
+		if !ok {
+			return nil, &Lua.ApiError{Type: Lua.ApiErrorRun, Object: Lua.LString("can not yield from outside of a coroutine")}
+		}
+		if err := e.wait(pending.ctx, pending.future); err != nil {
+			return nil, err
+		}
+		values = e.settled(pending.future)
+	}
+}
+
+// stripAsyncFrames 从 gopher-lua 的调用栈中去掉 asyncChunk 的帧
+func stripAsyncFrames(trace string) string {
+	lines := strings.Split(trace, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), asyncChunkName+":") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// yieldedCall 返回协程让出时交给 resume 的异步调用，脚本直接调用 coroutine.yield 时 ok 为 false
+func yieldedCall(lv Lua.LValue) (pending *pendingCall, ok bool) {
+	if ud, isUserData := lv.(*Lua.LUserData); isUserData {
+		pending, ok = ud.Value.(*pendingCall)
+	}
+	return pending, ok
+}
+
+// settled 返回已完成的 Future 在脚本中的结果：成功时为 true 与转换后的返回值，
+// 失败或返回值无法转换时为 false 与错误对象
+func (e *virtualMachine) settled(f *Future) []Lua.LValue {
+	values, err := f.Result()
+	if err != nil {
+		return []Lua.LValue{Lua.LFalse, e.errorValue(err)}
+	}
+
+	results := make([]Lua.LValue, 0, len(values)+1)
+	results = append(results, Lua.LTrue)
+	for _, v := range values {
+		lv, err := e.convertToLValue(v)
+		if err != nil {
+			return []Lua.LValue{Lua.LFalse, e.errorValue(err)}
+		}
+		results = append(results, lv)
+	}
+	return results
+}
+
+// wait 等待 f 完成。由引擎创建的虚拟机在等待期间释放引擎锁。
+func (e *virtualMachine) wait(ctx context.Context, f *Future) error {
+	if e.await != nil {
+		return e.await(ctx, f)
+	}
+
+	select {
+	case <-f.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
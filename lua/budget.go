@@ -0,0 +1,100 @@
+package lua
+
+import (
+	"context"
+	"sync/atomic"
+
+	Lua "github.com/yuin/gopher-lua"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+// budgetContext 按虚拟机指令计量预算的 context，预算耗尽时被取消，Err 返回 *scriptEngine.BudgetExceededError。
+// 它的 Done 没有副作用，宿主函数可以照常等待；指令由绑定到虚拟机的 instructionMeter 计量。
+type budgetContext struct {
+	context.Context
+	cancel context.CancelFunc
+	limit  int64
+	used   atomic.Int64
+}
+
+func newBudgetContext(parent context.Context, limit int64) *budgetContext {
+	ctx, cancel := context.WithCancel(parent)
+	return &budgetContext{Context: ctx, cancel: cancel, limit: limit}
+}
+
+func (c *budgetContext) Err() error {
+	if c.exceeded() {
+		return &scriptEngine.BudgetExceededError{Limit: c.limit, Used: c.used.Load()}
+	}
+	return c.Context.Err()
+}
+
+// step 消耗一条指令的预算，预算耗尽时取消 context
+func (c *budgetContext) step() {
+	if c.used.Add(1) > c.limit {
+		c.cancel()
+	}
+}
+
+// exceeded 预算是否已耗尽
+func (c *budgetContext) exceeded() bool {
+	return c.used.Load() > c.limit
+}
+
+// instructionMeter 绑定到虚拟机的 context，为 budgetContext 计量指令。
+// gopher-lua 没有调试钩子，虚拟机在执行每条指令前调用一次所绑定 context 的 Done，instructionMeter 在其中计量；
+// 宿主函数与 ctx 表通过 virtualMachine.context 取得的是其中的 budgetContext，不会计入预算。
+type instructionMeter struct {
+	*budgetContext
+}
+
+func (m instructionMeter) Done() <-chan struct{} {
+	m.step()
+	return m.budgetContext.Done()
+}
+
+// shareContext 包装 coroutine.create / coroutine.wrap，使脚本创建的协程与调用方共用同一个 context，
+// 协程内执行的指令同样计入预算，并随调用方的 ctx 一同取消。
+// thread 从 fn 的返回值中取出新建的协程。
+func shareContext(fn Lua.LGFunction, thread func(L *Lua.LState) *Lua.LState) Lua.LGFunction {
+	return func(L *Lua.LState) int {
+		ctx := L.RemoveContext()
+		if ctx == nil {
+			return fn(L)
+		}
+		defer L.SetContext(ctx)
+
+		n := fn(L)
+		if th := thread(L); th != nil {
+			th.SetContext(ctx)
+		}
+		return n
+	}
+}
+
+// openCoroutine 以 shareContext 包装 coroutine 库中创建协程的函数
+func openCoroutine(L *Lua.LState) {
+	co, ok := L.GetGlobal(Lua.CoroutineLibName).(*Lua.LTable)
+	if !ok {
+		return
+	}
+
+	if create, ok := co.RawGetString("create").(*Lua.LFunction); ok && create.IsG {
+		co.RawSetString("create", L.NewFunction(shareContext(create.GFunction, func(L *Lua.LState) *Lua.LState {
+			th, _ := L.Get(-1).(*Lua.LState)
+			return th
+		})))
+	}
+
+	if wrap, ok := co.RawGetString("wrap").(*Lua.LFunction); ok && wrap.IsG {
+		co.RawSetString("wrap", L.NewFunction(shareContext(wrap.GFunction, func(L *Lua.LState) *Lua.LState {
+			fn, ok := L.Get(-1).(*Lua.LFunction)
+			if !ok || len(fn.Upvalues) == 0 {
+				return nil
+			}
+			th, _ := fn.Upvalues[0].Value().(*Lua.LState)
+			return th
+		})))
+	}
+}
@@ -0,0 +1,167 @@
+package lua
+
+import (
+	"bytes"
+	"reflect"
+
+	Lua "github.com/yuin/gopher-lua"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+// bufferTypeName buffer userdata 的元表名
+const bufferTypeName = "buffer"
+
+// byteBuffer buffer userdata 承载的值，append 可能替换 data
+type byteBuffer struct {
+	data []byte
+}
+
+// setBytesMode 设置 []byte 在 Lua 中的表示方式。
+// BytesAsBuffer 模式下注册 buffer userdata 的元表与全局构造函数 buffer(v)。
+func (e *virtualMachine) setBytesMode(mode scriptEngine.BytesMode) {
+	e.bytesMode = mode
+	if mode != scriptEngine.BytesAsBuffer {
+		return
+	}
+
+	L := e.L
+	mt := L.NewTypeMetatable(bufferTypeName)
+	L.SetFuncs(mt, map[string]Lua.LGFunction{
+		"__len": func(L *Lua.LState) int {
+			L.Push(Lua.LNumber(len(checkBuffer(L, 1).data)))
+			return 1
+		},
+		"__eq": func(L *Lua.LState) int {
+			L.Push(Lua.LBool(bytes.Equal(checkBuffer(L, 1).data, checkBuffer(L, 2).data)))
+			return 1
+		},
+		"__tostring": func(L *Lua.LState) int {
+			L.Push(Lua.LString(checkBuffer(L, 1).data))
+			return 1
+		},
+		"__concat": func(L *Lua.LState) int {
+			L.Push(Lua.LString(bufferString(L, 1) + bufferString(L, 2)))
+			return 1
+		},
+	})
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]Lua.LGFunction{
+		"len": func(L *Lua.LState) int {
+			L.Push(Lua.LNumber(len(checkBuffer(L, 1).data)))
+			return 1
+		},
+		// get 返回第 i 个字节（从 1 开始），超出范围时返回 nil
+		"get": func(L *Lua.LState) int {
+			buf, i := checkBuffer(L, 1), L.CheckInt(2)
+			if i < 1 || i > len(buf.data) {
+				L.Push(Lua.LNil)
+				return 1
+			}
+			L.Push(Lua.LNumber(buf.data[i-1]))
+			return 1
+		},
+		// set 设置第 i 个字节（从 1 开始），b 必须在 0 到 255 之间
+		"set": func(L *Lua.LState) int {
+			buf, i, b := checkBuffer(L, 1), L.CheckInt(2), L.CheckInt(3)
+			if i < 1 || i > len(buf.data) {
+				L.ArgError(2, "index out of range")
+			}
+			if b < 0 || b > 255 {
+				L.ArgError(3, "byte value out of range")
+			}
+			buf.data[i-1] = byte(b)
+			return 0
+		},
+		// sub 与 string.sub 相同，返回第 i 到第 j 个字节组成的字符串，负数表示从末尾计数
+		"sub": func(L *Lua.LState) int {
+			buf := checkBuffer(L, 1)
+			n := len(buf.data)
+			i, j := bufferIndex(L.CheckInt(2), n), bufferIndex(L.OptInt(3, -1), n)
+			i, j = max(i, 1), min(j, n)
+			if i > j {
+				L.Push(Lua.LString(""))
+				return 1
+			}
+			L.Push(Lua.LString(buf.data[i-1 : j]))
+			return 1
+		},
+		// append 在末尾追加字符串或 buffer 并返回 buf 本身，追加后可能不再与原来的 []byte 共享数据
+		"append": func(L *Lua.LState) int {
+			buf := checkBuffer(L, 1)
+			for i := 2; i <= L.GetTop(); i++ {
+				buf.data = append(buf.data, bufferString(L, i)...)
+			}
+			L.Push(L.Get(1))
+			return 1
+		},
+		"tostring": func(L *Lua.LState) int {
+			L.Push(Lua.LString(checkBuffer(L, 1).data))
+			return 1
+		},
+	}))
+
+	L.SetGlobal("buffer", L.NewFunction(func(L *Lua.LState) int {
+		switch v := L.Get(1).(type) {
+		case *Lua.LNilType:
+			L.Push(newBuffer(L, nil))
+		case Lua.LNumber:
+			if v < 0 {
+				L.ArgError(1, "negative size")
+			}
+			L.Push(newBuffer(L, make([]byte, int(v))))
+		default:
+			L.Push(newBuffer(L, []byte(bufferString(L, 1))))
+		}
+		return 1
+	}))
+}
+
+// bytesValue 按 BytesMode 将 b 转换为 Lua 字符串或与 b 共享数据的 buffer userdata
+func (e *virtualMachine) bytesValue(b []byte) Lua.LValue {
+	if e.bytesMode == scriptEngine.BytesAsBuffer {
+		return newBuffer(e.L, b)
+	}
+	return Lua.LString(b)
+}
+
+// newBuffer 创建承载 b 的 buffer userdata
+func newBuffer(L *Lua.LState, b []byte) *Lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = &byteBuffer{data: b}
+	ud.Metatable = L.GetTypeMetatable(bufferTypeName)
+	return ud
+}
+
+// checkBuffer 返回第 n 个参数承载的 byteBuffer
+func checkBuffer(L *Lua.LState, n int) *byteBuffer {
+	if ud, ok := L.Get(n).(*Lua.LUserData); ok {
+		if buf, ok := ud.Value.(*byteBuffer); ok {
+			return buf
+		}
+	}
+	L.ArgError(n, "buffer expected")
+	return nil
+}
+
+// bufferString 返回第 n 个参数的字节内容，参数必须是 buffer、字符串或数字
+func bufferString(L *Lua.LState, n int) string {
+	if ud, ok := L.Get(n).(*Lua.LUserData); ok {
+		if buf, ok := ud.Value.(*byteBuffer); ok {
+			return string(buf.data)
+		}
+	}
+	return L.CheckString(n)
+}
+
+// bufferIndex 将 sub 的位置参数转换为从 1 开始的位置，负数表示从末尾计数
+func bufferIndex(i, n int) int {
+	if i < 0 {
+		return n + i + 1
+	}
+	return i
+}
+
+// isBytes typ 是否为字节切片
+func isBytes(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8
+}
@@ -0,0 +1,70 @@
+package lua
+
+import (
+	"context"
+	"reflect"
+
+	Lua "github.com/yuin/gopher-lua"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// context 返回 L 正在进行的执行的 context：优先使用绑定到 L 的 context（计量指令的 instructionMeter 返回其中的 budgetContext），
+// 未绑定时使用执行开始时记录的 ctx，不在执行时返回 context.Background()
+func (e *virtualMachine) context(L *Lua.LState) context.Context {
+	if ctx := L.Context(); ctx != nil {
+		if meter, ok := ctx.(instructionMeter); ok {
+			return meter.budgetContext
+		}
+		return ctx
+	}
+	if e.ctx != nil {
+		return e.ctx
+	}
+	return context.Background()
+}
+
+// registerContext 注册全局 table ctx，脚本通过它读取当前执行的 context，函数同时支持 ctx.f() 与 ctx:f() 两种调用方式：
+//   - ctx.value(key) 返回 ctx.Value(key)，包括 ExecuteOptions.Values 中的值，不存在时返回 nil；
+//   - ctx.deadline() 返回截止时间的 Unix 毫秒数（整数，与 JavaScript 引擎相同），没有截止时间时返回 nil；
+//   - ctx.err() 返回 context 结束的原因，尚未结束时返回 nil。
+func (e *virtualMachine) registerContext() {
+	L := e.L
+	tbl := L.NewTable()
+	// arg 返回第 n 个参数，以 ctx:f() 方式调用时跳过 ctx 本身
+	arg := func(L *Lua.LState, n int) Lua.LValue {
+		if L.Get(1) == tbl {
+			n++
+		}
+		return L.Get(n)
+	}
+
+	L.SetFuncs(tbl, map[string]Lua.LGFunction{
+		"value": func(L *Lua.LState) int {
+			lv, err := e.convertToLValue(e.context(L).Value(Lua.LVAsString(arg(L, 1))))
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			L.Push(lv)
+			return 1
+		},
+		"deadline": func(L *Lua.LState) int {
+			deadline, ok := e.context(L).Deadline()
+			if !ok {
+				L.Push(Lua.LNil)
+				return 1
+			}
+			L.Push(Lua.LNumber(deadline.UnixMilli()))
+			return 1
+		},
+		"err": func(L *Lua.LState) int {
+			if err := e.context(L).Err(); err != nil {
+				L.Push(Lua.LString(err.Error()))
+				return 1
+			}
+			L.Push(Lua.LNil)
+			return 1
+		},
+	})
+	L.SetGlobal("ctx", tbl)
+}
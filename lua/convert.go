@@ -0,0 +1,314 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	Lua "github.com/yuin/gopher-lua"
+	luar "layeh.com/gopher-luar"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// visitKey 正在转换的引用类型值，用于检测循环引用
+type visitKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// toLValue 通过反射将 Go 值转换为 Lua 值：
+//   - 布尔、数字与字符串（包括以它们为底层类型的自定义类型）转换为对应的 Lua 值，
+//     int、int64、uint、uint64 与 uintptr 按 Int64Mode 转换为数字、int64 userdata 或字符串；
+//   - slice、array 转换为数组 table，map 转换为 table，键同样按本规则转换；
+//   - struct 按 StructMode 复制为 table 或绑定为 userdata，指针与接口转换其指向的值；
+//   - []byte 按 BytesMode 转换为字符串或 buffer userdata；
+//   - time.Time 转换为 Unix 时间戳（秒），time.Duration 转换为秒数；
+//   - Go 函数通过反射包装为 Lua 函数。
+//
+// nil 指针、slice、map 转换为 nil，其他类型返回 ErrLuaUnsupportedType。
+func (e *virtualMachine) toLValue(rv reflect.Value, visiting map[visitKey]bool) (Lua.LValue, error) {
+	if !rv.IsValid() {
+		return Lua.LNil, nil
+	}
+
+	if rv.Type().Implements(lValueType) {
+		if (rv.Kind() == reflect.Interface || rv.Kind() == reflect.Ptr) && rv.IsNil() {
+			return Lua.LNil, nil
+		}
+		return rv.Interface().(Lua.LValue), nil
+	}
+
+	switch rv.Type() {
+	case timeType:
+		t := rv.Interface().(time.Time)
+		return Lua.LNumber(float64(t.UnixNano()) / float64(time.Second)), nil
+	case durationType:
+		return Lua.LNumber(rv.Interface().(time.Duration).Seconds()), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return Lua.LBool(rv.Bool()), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return Lua.LNumber(rv.Int()), nil
+	case reflect.Int, reflect.Int64:
+		return e.int64Value(rv.Int()), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Lua.LNumber(rv.Uint()), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return e.int64Value(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return Lua.LNumber(rv.Float()), nil
+	case reflect.String:
+		return Lua.LString(rv.String()), nil
+
+	case reflect.Interface:
+		if rv.IsNil() {
+			return Lua.LNil, nil
+		}
+		return e.toLValue(rv.Elem(), visiting)
+
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return Lua.LNil, nil
+		}
+		if rv.Elem().Kind() == reflect.Struct && e.structMode == scriptEngine.StructAsUserData {
+			return luar.New(e.L, rv.Interface()), nil
+		}
+		return e.visit(rv, visiting, func() (Lua.LValue, error) {
+			return e.toLValue(rv.Elem(), visiting)
+		})
+
+	case reflect.Slice:
+		if rv.IsNil() {
+			return Lua.LNil, nil
+		}
+		if isBytes(rv.Type()) {
+			return e.bytesValue(rv.Bytes()), nil
+		}
+		return e.visit(rv, visiting, func() (Lua.LValue, error) {
+			return e.arrayToLTable(rv, visiting)
+		})
+
+	case reflect.Array:
+		return e.arrayToLTable(rv, visiting)
+
+	case reflect.Map:
+		if rv.IsNil() {
+			return Lua.LNil, nil
+		}
+		return e.visit(rv, visiting, func() (Lua.LValue, error) {
+			return e.mapToLTable(rv, visiting)
+		})
+
+	case reflect.Struct:
+		if e.structMode == scriptEngine.StructAsUserData {
+			return luar.New(e.L, rv.Interface()), nil
+		}
+		tbl := e.L.NewTable()
+		if err := e.setStructFields(tbl, rv, visiting); err != nil {
+			return nil, err
+		}
+		return tbl, nil
+
+	case reflect.Func:
+		if rv.IsNil() {
+			return Lua.LNil, nil
+		}
+		fn, err := e.newGoFunction(rv.Type().String(), rv.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return e.L.NewFunction(fn), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrLuaUnsupportedType, rv.Type())
+}
+
+// visit 转换引用类型的值，值已在转换路径上时说明存在循环引用
+func (e *virtualMachine) visit(rv reflect.Value, visiting map[visitKey]bool, convert func() (Lua.LValue, error)) (Lua.LValue, error) {
+	key := visitKey{ptr: rv.Pointer(), typ: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		key.len = rv.Len()
+	}
+	if visiting[key] {
+		return nil, fmt.Errorf("%w: cyclic reference in %s", ErrLuaUnsupportedType, rv.Type())
+	}
+
+	visiting[key] = true
+	defer delete(visiting, key)
+	return convert()
+}
+
+// arrayToLTable 将 slice 或 array 转换为数组 table
+func (e *virtualMachine) arrayToLTable(rv reflect.Value, visiting map[visitKey]bool) (Lua.LValue, error) {
+	tbl := e.L.CreateTable(rv.Len(), 0)
+	for i := 0; i < rv.Len(); i++ {
+		lv, err := e.toLValue(rv.Index(i), visiting)
+		if err != nil {
+			return nil, err
+		}
+		tbl.RawSetInt(i+1, lv)
+	}
+	return tbl, nil
+}
+
+// mapToLTable 将 map 转换为 table
+func (e *virtualMachine) mapToLTable(rv reflect.Value, visiting map[visitKey]bool) (Lua.LValue, error) {
+	tbl := e.L.CreateTable(0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		key, err := e.toLValue(iter.Key(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		if key == Lua.LNil {
+			continue
+		}
+		value, err := e.toLValue(iter.Value(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		tbl.RawSet(key, value)
+	}
+	return tbl, nil
+}
+
+// setStructFields 将 struct 的导出字段写入 tbl，字段名按 FieldNaming 转换，
+// 默认与 encoding/json 一致：字段名优先使用 json 标签，标签为 "-" 的字段被忽略。
+// 没有 json 标签的匿名嵌入 struct 的字段展开到外层，外层的同名字段优先。
+func (e *virtualMachine) setStructFields(tbl *Lua.LTable, rv reflect.Value, visiting map[visitKey]bool) error {
+	typ := rv.Type()
+
+	var fields []int
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if _, ok := e.fieldNaming.FieldName(field); !ok {
+			continue
+		}
+
+		if tag, _, _ := strings.Cut(field.Tag.Get("json"), ","); field.Anonymous && tag == "" {
+			fv := reflect.Indirect(rv.Field(i))
+			if fv.Kind() == reflect.Struct {
+				if err := e.setStructFields(tbl, fv, visiting); err != nil {
+					return err
+				}
+				continue
+			}
+			if !fv.IsValid() {
+				continue
+			}
+		}
+		fields = append(fields, i)
+	}
+
+	for _, i := range fields {
+		fv := rv.Field(i)
+		if !fv.CanInterface() {
+			continue
+		}
+		name, _ := e.fieldNaming.FieldName(typ.Field(i))
+		lv, err := e.toLValue(fv, visiting)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", typ.Field(i).Name, err)
+		}
+		tbl.RawSetString(name, lv)
+	}
+	return nil
+}
+
+// parseInteger 将十进制字符串解析为 typ 类型的整数
+func parseInteger(s string, typ reflect.Type) (any, error) {
+	out := reflect.New(typ).Elem()
+	if typ.Kind() == reflect.Uint64 {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		out.SetUint(n)
+		return out.Interface(), nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	out.SetInt(n)
+	return out.Interface(), nil
+}
+
+// fromLValue 将 Lua 值转换为 Go 值：
+//   - nil、布尔、字符串分别转换为 nil、bool、string，整数值的数字转换为 int64，其他数字转换为 float64；
+//   - 键恰好为 1..n 的非空 table 转换为 []any，其他 table 转换为 map[string]any，
+//     非字符串的键以其在 Lua 中的字符串形式（tostring）作为键；
+//   - 同一个 table 只转换一次，多处引用同一个 table 时得到同一个 map 或 slice，
+//     因此循环引用的 table 转换为引用自身的 map 或 slice；
+//   - 函数转换为绑定到所属引擎的 Function；
+//   - buffer userdata 转换为其承载的 []byte，其他 userdata 转换为其承载的值，协程与 channel 原样返回。
+func (e *virtualMachine) fromLValue(lv Lua.LValue, seen map[*Lua.LTable]any) any {
+	switch v := lv.(type) {
+	case *Lua.LNilType:
+		return nil
+	case Lua.LBool:
+		return bool(v)
+	case Lua.LString:
+		return string(v)
+	case Lua.LNumber:
+		if f := float64(v); f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f)
+		}
+		return float64(v)
+	case *Lua.LTable:
+		if ret, ok := seen[v]; ok {
+			return ret
+		}
+		return e.tableToGo(v, seen)
+	case *Lua.LFunction:
+		return e.newFunction(v)
+	case *Lua.LUserData:
+		if buf, ok := v.Value.(*byteBuffer); ok {
+			return buf.data
+		}
+		return v.Value
+	case *Lua.LState:
+		return v
+	case Lua.LChannel:
+		return (chan Lua.LValue)(v)
+	default:
+		return nil
+	}
+}
+
+// tableToGo 将 table 转换为 []any 或 map[string]any，转换结果记入 seen
+func (e *virtualMachine) tableToGo(tbl *Lua.LTable, seen map[*Lua.LTable]any) any {
+	count := 0
+	tbl.ForEach(func(_, _ Lua.LValue) {
+		count++
+	})
+
+	if n := tbl.MaxN(); n > 0 && n == count {
+		ret := make([]any, n)
+		seen[tbl] = ret
+		for i := 1; i <= n; i++ {
+			ret[i-1] = e.fromLValue(tbl.RawGetInt(i), seen)
+		}
+		return ret
+	}
+
+	ret := make(map[string]any, count)
+	seen[tbl] = ret
+	tbl.ForEach(func(key, value Lua.LValue) {
+		ret[key.String()] = e.fromLValue(value, seen)
+	})
+	return ret
+}
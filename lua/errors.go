@@ -1,14 +1,284 @@
 package lua
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	Lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
 
 var (
 	// ErrLuaEngineNotInitialized Lua 引擎未初始化错误
-	ErrLuaEngineNotInitialized = errors.New("lua engine not initialized")
+	ErrLuaEngineNotInitialized = fmt.Errorf("lua engine %w", scriptEngine.ErrNotInitialized)
 
 	// ErrLuaEngineAlreadyInitialized Lua 引擎已初始化错误
-	ErrLuaEngineAlreadyInitialized = errors.New("lua engine already initialized")
+	ErrLuaEngineAlreadyInitialized = fmt.Errorf("lua engine %w", scriptEngine.ErrAlreadyInitialized)
 
 	// ErrLuaVMNotInitialized Lua 虚拟机未初始化错误
-	ErrLuaVMNotInitialized = errors.New("lua VM not initialized")
+	ErrLuaVMNotInitialized = fmt.Errorf("lua VM %w", scriptEngine.ErrNotInitialized)
+
+	// ErrLuaFunctionNotFound Lua 函数不存在错误
+	ErrLuaFunctionNotFound = fmt.Errorf("lua %w", scriptEngine.ErrFunctionNotFound)
+
+	// ErrLuaNotAFunction Lua 全局变量不是函数错误
+	ErrLuaNotAFunction = fmt.Errorf("lua %w", scriptEngine.ErrNotAFunction)
+
+	// ErrLuaNoProgramLoaded 没有已加载的 Lua 脚本错误
+	ErrLuaNoProgramLoaded = fmt.Errorf("lua %w", scriptEngine.ErrNoProgramLoaded)
+
+	// ErrLuaUnsupportedType Go 值的类型无法转换为 Lua 值
+	ErrLuaUnsupportedType = errors.New("lua unsupported go type")
+)
+
+var (
+	// messagePattern 匹配运行时错误消息开头的位置信息，例如 `<string>:3: boom`
+	messagePattern = regexp.MustCompile(`(?s)^(.+?):(\d+): (.*)$`)
+
+	// framePattern 匹配调用栈中的一帧，例如 `<string>:3: in function 'f'` 或 `[G]: in function 'error'`
+	framePattern = regexp.MustCompile(`^(?:(.+?):(\d+)|\[G\]): in (.+)$`)
 )
+
+// newScriptError 将 Lua 错误转换为 *scriptEngine.ScriptError，已转换的错误原样返回
+func newScriptError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var se *scriptEngine.ScriptError
+	if errors.As(err, &se) {
+		return err
+	}
+	return toScriptError(err)
+}
+
+// newTimeoutError 返回因 cause（ctx 结束或预算耗尽）而中止的错误，err 为虚拟机中止时产生的错误，可为 nil
+func newTimeoutError(cause, err error) error {
+	se := &scriptEngine.ScriptError{Engine: scriptEngine.LuaType}
+	if err != nil {
+		se = toScriptError(err)
+	}
+	se.Kind = scriptEngine.ErrorKindTimeout
+	se.Message = cause.Error()
+	se.Cause = cause
+	return se
+}
+
+// errorTypeName 宿主函数错误对象的元表名
+const errorTypeName = "error"
+
+// maxCauseDepth 查找错误对象承载的 Go 错误时沿 cause 字段查找的最大深度
+const maxCauseDepth = 16
+
+// errorValue 将宿主函数返回的错误转换为 Lua 错误对象：message 为 err.Error() 的 table，value 为承载原始错误的 userdata，
+// 错误码不为空时设置 code，包装了其他错误时 cause 为被包装的错误对应的错误对象。
+// tostring 与 .. 运算使用 message，因此按字符串处理错误的脚本仍可工作。
+func (e *virtualMachine) errorValue(err error) *Lua.LTable {
+	L := e.L
+	mt, ok := L.GetTypeMetatable(errorTypeName).(*Lua.LTable)
+	if !ok {
+		mt = L.NewTypeMetatable(errorTypeName)
+		L.SetFuncs(mt, map[string]Lua.LGFunction{
+			"__tostring": func(L *Lua.LState) int {
+				L.Push(L.GetField(L.CheckTable(1), "message"))
+				return 1
+			},
+			"__concat": func(L *Lua.LState) int {
+				L.Push(Lua.LString(errorString(L.Get(1)) + errorString(L.Get(2))))
+				return 1
+			},
+		})
+	}
+
+	tbl := L.NewTable()
+	tbl.RawSetString("message", Lua.LString(err.Error()))
+	if code := scriptEngine.ErrorCode(err); code != "" {
+		tbl.RawSetString("code", Lua.LString(code))
+	}
+	if cause := errors.Unwrap(err); cause != nil {
+		tbl.RawSetString("cause", e.errorValue(cause))
+	}
+	ud := L.NewUserData()
+	ud.Value = err
+	tbl.RawSetString("value", ud)
+	tbl.Metatable = mt
+	return tbl
+}
+
+// errorString 返回 .. 运算中操作数的字符串形式，错误对象使用其 message
+func errorString(lv Lua.LValue) string {
+	if tbl, ok := lv.(*Lua.LTable); ok {
+		return Lua.LVAsString(tbl.RawGetString("message"))
+	}
+	return lv.String()
+}
+
+// withThrown 将脚本抛出的错误转换为以 *scriptEngine.ThrownError 为原因的 *scriptEngine.ScriptError，
+// 其他错误原样返回。直接抛出的宿主函数错误的类别为 ErrorKindHost。调用方需持有引擎锁。
+func (e *virtualMachine) withThrown(err error) error {
+	var apiErr *Lua.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Type != Lua.ApiErrorRun {
+		return err
+	}
+
+	se := toScriptError(err)
+	thrown := e.thrownError(apiErr.Object)
+	if _, ok := apiErr.Object.(Lua.LString); ok {
+		thrown.Message = se.Message
+	} else {
+		se.Message = thrown.Message
+	}
+	if tbl, ok := apiErr.Object.(*Lua.LTable); ok && hostError(tbl, 1) != nil {
+		se.Kind = scriptEngine.ErrorKindHost
+	}
+	se.Cause = thrown
+	return se
+}
+
+// thrownError 将脚本抛出的值转换为 *scriptEngine.ThrownError，table 的 message 与 code 字段分别作为消息与错误码
+func (e *virtualMachine) thrownError(lv Lua.LValue) *scriptEngine.ThrownError {
+	thrown := &scriptEngine.ThrownError{Message: lv.String(), Value: e.convertFromLValue(lv)}
+	switch v := lv.(type) {
+	case *Lua.LTable:
+		if message := v.RawGetString("message"); message != Lua.LNil {
+			thrown.Message = Lua.LVAsString(message)
+		}
+		if code := v.RawGetString("code"); code != Lua.LNil {
+			thrown.Code = Lua.LVAsString(code)
+		}
+		thrown.Cause = hostError(v, maxCauseDepth)
+	case *Lua.LUserData:
+		thrown.Cause, _ = v.Value.(error)
+	}
+	return thrown
+}
+
+// conventionalError 按 Lua 的多返回值惯例提取错误：
+// 至少两个返回值、第一个为 nil 且最后一个不为 nil 时，最后一个返回值即为错误。
+// 宿主函数的错误对象返回其承载的 Go 错误，其他 table 与抛出的 table 一样转换为 *scriptEngine.ThrownError。
+// 调用方需持有引擎锁。
+func (e *virtualMachine) conventionalError(values []Lua.LValue) error {
+	if len(values) < 2 || values[0] != Lua.LNil {
+		return nil
+	}
+
+	last := values[len(values)-1]
+	switch v := last.(type) {
+	case *Lua.LNilType:
+		return nil
+	case *Lua.LTable:
+		if err := hostError(v, 1); err != nil {
+			return err
+		}
+		return e.thrownError(v)
+	case *Lua.LUserData:
+		if err, ok := v.Value.(error); ok {
+			return err
+		}
+	}
+	return errors.New(last.String())
+}
+
+// hostError 返回错误对象 tbl 或其 cause 链中的错误对象承载的 Go 错误，最多查找 depth 层，没有时返回 nil
+func hostError(tbl *Lua.LTable, depth int) error {
+	for ; tbl != nil && depth > 0; depth-- {
+		if ud, ok := tbl.RawGetString("value").(*Lua.LUserData); ok {
+			if err, ok := ud.Value.(error); ok {
+				return err
+			}
+		}
+		tbl, _ = tbl.RawGetString("cause").(*Lua.LTable)
+	}
+	return nil
+}
+
+// toScriptError 按 *Lua.ApiError 的类型解析错误类别、出错位置与调用栈
+func toScriptError(err error) *scriptEngine.ScriptError {
+	se := &scriptEngine.ScriptError{
+		Engine:  scriptEngine.LuaType,
+		Kind:    scriptEngine.ErrorKindHost,
+		Message: err.Error(),
+		Cause:   err,
+	}
+
+	var apiErr *Lua.ApiError
+	if !errors.As(err, &apiErr) {
+		return se
+	}
+
+	se.Message = apiErr.Object.String()
+	se.Stack = parseStackTrace(apiErr.StackTrace)
+
+	switch apiErr.Type {
+	case Lua.ApiErrorSyntax:
+		se.Kind = scriptEngine.ErrorKindCompile
+		var parseErr *parse.Error
+		var compileErr *Lua.CompileError
+		switch {
+		case errors.As(apiErr.Cause, &parseErr):
+			se.File = parseErr.Pos.Source
+			se.Line = parseErr.Pos.Line
+			se.Column = parseErr.Pos.Column
+			se.Message = parseErr.Message
+			if parseErr.Token != "" {
+				se.Message += " near '" + parseErr.Token + "'"
+			}
+		case errors.As(apiErr.Cause, &compileErr):
+			se.Line = compileErr.Line
+			se.Message = compileErr.Message
+		}
+
+	case Lua.ApiErrorRun, Lua.ApiErrorError:
+		se.Kind = scriptEngine.ErrorKindRuntime
+		if m := messagePattern.FindStringSubmatch(se.Message); m != nil {
+			se.File = m[1]
+			se.Line, _ = strconv.Atoi(m[2])
+			se.Message = m[3]
+		}
+	}
+
+	// 消息中没有位置信息时，使用最内层脚本帧的位置
+	if se.Line == 0 {
+		for _, frame := range se.Stack {
+			if frame.Line > 0 {
+				se.File = frame.File
+				se.Line = frame.Line
+				break
+			}
+		}
+	}
+
+	return se
+}
+
+// parseStackTrace 解析 gopher-lua 的 `stack traceback:` 调用栈
+func parseStackTrace(trace string) []scriptEngine.StackFrame {
+	idx := strings.Index(trace, "stack traceback:")
+	if idx < 0 {
+		return nil
+	}
+
+	var frames []scriptEngine.StackFrame
+	for _, line := range strings.Split(trace[idx:], "\n")[1:] {
+		m := framePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+
+		frame := scriptEngine.StackFrame{File: m[1], Function: m[3]}
+		if frame.File == "" {
+			frame.File = "[G]"
+		}
+		frame.Line, _ = strconv.Atoi(m[2])
+		if name, ok := strings.CutPrefix(frame.Function, "function "); ok {
+			frame.Function = strings.Trim(name, "'")
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
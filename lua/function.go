@@ -0,0 +1,318 @@
+package lua
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	Lua "github.com/yuin/gopher-lua"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+var (
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	lValueType = reflect.TypeOf((*Lua.LValue)(nil)).Elem()
+)
+
+// newGoFunction 通过反射将任意 Go 函数包装为 Lua 函数：
+// 参数按函数签名转换，缺少的参数取零值，多余的参数被忽略，支持可变参数；
+// 第一个参数为 context.Context 时传入当前执行的 context，Lua 的参数从第二个参数开始对应；
+// 返回值转换为 Lua 值，最后一个返回值为 error 且不为 nil 时在调用处抛出由 errorValue 转换的错误对象。
+func (e *virtualMachine) newGoFunction(name string, fn any) (Lua.LGFunction, error) {
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("function %s must be a func, got %T", name, fn)
+	}
+	typ := rv.Type()
+
+	fixed := typ.NumIn()
+	if typ.IsVariadic() {
+		fixed--
+	}
+	offset := 0
+	if typ.NumIn() > 0 && typ.In(0) == contextType {
+		offset = 1
+	}
+	returnsError := typ.NumOut() > 0 && typ.Out(typ.NumOut()-1) == errorType
+
+	return func(L *Lua.LState) int {
+		top := L.GetTop()
+
+		// 调用期间宿主函数收到的 Lua 函数可以直接调用
+		active := new(atomic.Bool)
+		active.Store(true)
+		previous := e.hostActive
+		e.hostActive = active
+		defer func() {
+			active.Store(false)
+			e.hostActive = previous
+		}()
+
+		args := make([]reflect.Value, 0, max(top+offset, fixed))
+		if offset > 0 {
+			args = append(args, reflect.ValueOf(e.context(L)))
+		}
+		convert := func(i int, paramType reflect.Type) {
+			arg, err := e.toGoValue(L.Get(i+1), paramType)
+			if err != nil {
+				L.ArgError(i+1, err.Error())
+			}
+			args = append(args, arg)
+		}
+
+		for i := offset; i < fixed; i++ {
+			convert(i-offset, typ.In(i))
+		}
+		if typ.IsVariadic() {
+			for i := fixed - offset; i < top; i++ {
+				convert(i, typ.In(fixed).Elem())
+			}
+		}
+
+		results := rv.Call(args)
+		if returnsError {
+			if err, _ := results[len(results)-1].Interface().(error); err != nil {
+				L.Error(e.errorValue(err), 1)
+			}
+			results = results[:len(results)-1]
+		}
+
+		for _, result := range results {
+			lv, err := e.toLValue(result, make(map[visitKey]bool))
+			if err != nil {
+				L.RaiseError("%s", err.Error())
+			}
+			L.Push(lv)
+		}
+		return len(results)
+	}, nil
+}
+
+// toGoValue 将 Lua 值转换为 typ 类型的 Go 值，table 按 typ 解码为 struct、map 或 slice
+func (e *virtualMachine) toGoValue(lv Lua.LValue, typ reflect.Type) (reflect.Value, error) {
+	// 参数类型为 LValue 时原样传入
+	if typ.Implements(lValueType) {
+		if reflect.TypeOf(lv).AssignableTo(typ) {
+			return reflect.ValueOf(lv), nil
+		}
+		return reflect.Value{}, fmt.Errorf("%s expected, got %s", typ, lv.Type())
+	}
+
+	if lv == Lua.LNil {
+		return reflect.Zero(typ), nil
+	}
+
+	value := e.convertFromLValue(lv)
+	if value == nil {
+		return reflect.Zero(typ), nil
+	}
+
+	switch typ {
+	case timeType, durationType:
+		out := reflect.New(typ)
+		if err := scriptEngine.Decode(value, out.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return out.Elem(), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Type().AssignableTo(typ) {
+		return rv, nil
+	}
+
+	if _, ok := lv.(*Lua.LTable); ok {
+		out := reflect.New(typ)
+		if err := scriptEngine.Decode(value, out.Interface()); err != nil {
+			return reflect.Value{}, err
+		}
+		return out.Elem(), nil
+	}
+
+	if isNumberKind(rv.Kind()) && isNumberKind(typ.Kind()) || rv.Kind() == reflect.String && typ.Kind() == reflect.String {
+		return rv.Convert(typ), nil
+	}
+	if rv.Kind() == reflect.String && isBytes(typ) {
+		return reflect.ValueOf([]byte(rv.String())).Convert(typ), nil
+	}
+	if isBytes(rv.Type()) && typ.Kind() == reflect.String {
+		return rv.Convert(typ), nil
+	}
+	if rv.Kind() == reflect.String && (typ.Kind() == reflect.Int64 || typ.Kind() == reflect.Uint64) {
+		n, err := parseInteger(rv.String(), typ)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(n), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("%s expected, got %s", typ, lv.Type())
+}
+
+// isNumberKind 是否为整数或浮点数类型
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// RegisterGoFunction 通过反射注册一个全局的 Go 方法到lua
+func (e *virtualMachine) RegisterGoFunction(name string, fn any) error {
+	lf, err := e.newGoFunction(name, fn)
+	if err != nil {
+		return err
+	}
+	e.RegisterFunction(name, lf)
+	return nil
+}
+
+// RegisterModuleTable 以 members 创建模块 table，Go 函数通过反射转换为 Lua 函数，脚本通过 require(name) 加载模块
+func (e *virtualMachine) RegisterModuleTable(name string, members map[string]any) error {
+	mod := e.L.NewTable()
+	for key, member := range members {
+		lv, err := e.toModuleMember(name+"."+key, member)
+		if err != nil {
+			return err
+		}
+		mod.RawSetString(key, lv)
+	}
+
+	e.RegisterModule(name, func(L *Lua.LState) int {
+		L.Push(mod)
+		return 1
+	})
+	return nil
+}
+
+// RegisterNativeModule 注册 NativeModule，子模块同时作为模块的成员与名为 name/子模块名 的模块注册，两者为同一个 table
+func (e *virtualMachine) RegisterNativeModule(name string, module *scriptEngine.NativeModule) error {
+	if err := module.Validate(); err != nil {
+		return err
+	}
+	_, err := e.registerNativeModule(name, module)
+	return err
+}
+
+func (e *virtualMachine) registerNativeModule(name string, module *scriptEngine.NativeModule) (*Lua.LTable, error) {
+	mod := e.L.NewTable()
+	for _, members := range []map[string]any{module.Functions, module.Constants} {
+		for key, member := range members {
+			lv, err := e.toModuleMember(name+"."+key, member)
+			if err != nil {
+				return nil, err
+			}
+			mod.RawSetString(key, lv)
+		}
+	}
+	for _, sub := range module.Modules {
+		tbl, err := e.registerNativeModule(name+"/"+sub.Name, sub)
+		if err != nil {
+			return nil, err
+		}
+		mod.RawSetString(sub.Name, tbl)
+	}
+
+	e.RegisterModule(name, func(L *Lua.LState) int {
+		L.Push(mod)
+		return 1
+	})
+	return mod, nil
+}
+
+// structMembers 返回 struct（或指向 struct 的指针）的导出字段与方法，方法绑定到 module 上。
+// 成员名按 FieldNaming 转换，默认使用 Go 名称。
+func (e *virtualMachine) structMembers(module any) (map[string]any, bool) {
+	rv := reflect.ValueOf(module)
+	elem := reflect.Indirect(rv)
+	if elem.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	naming := e.fieldNaming
+	if naming == scriptEngine.FieldNamingDefault {
+		naming = scriptEngine.FieldNamingGo
+	}
+
+	members := make(map[string]any)
+	for i := 0; i < elem.NumField(); i++ {
+		field := elem.Type().Field(i)
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		if name, ok := naming.FieldName(field); ok {
+			members[name] = elem.Field(i).Interface()
+		}
+	}
+	for i := 0; i < rv.NumMethod(); i++ {
+		members[naming.MethodName(rv.Type().Method(i).Name)] = rv.Method(i).Interface()
+	}
+	return members, true
+}
+
+// toModuleMember 将模块成员转换为 Lua 值
+func (e *virtualMachine) toModuleMember(name string, member any) (Lua.LValue, error) {
+	switch m := member.(type) {
+	case Lua.LGFunction:
+		return e.L.NewFunction(m), nil
+	case func(*Lua.LState) int:
+		return e.L.NewFunction(m), nil
+	case AsyncFunction:
+		return e.newAsyncFunction(name, m)
+	case func(context.Context, ...any) *Future:
+		return e.newAsyncFunction(name, m)
+	}
+
+	if reflect.TypeOf(member) != nil && reflect.TypeOf(member).Kind() == reflect.Func {
+		lf, err := e.newGoFunction(name, member)
+		if err != nil {
+			return nil, err
+		}
+		return e.L.NewFunction(lf), nil
+	}
+	return e.convertToLValue(member)
+}
+
+// Function 转换为 Go 值的 Lua 函数，调用时在所属引擎中执行并返回函数的全部返回值。
+// 宿主函数收到的 Lua 函数在宿主函数返回前可以直接调用，此时不再获取引擎锁。
+type Function = func(ctx context.Context, args ...any) ([]any, error)
+
+// newFunction 将 Lua 函数包装为 Function
+func (e *virtualMachine) newFunction(fn *Lua.LFunction) Function {
+	active := e.hostActive
+	return func(ctx context.Context, args ...any) ([]any, error) {
+		if e.invoke == nil || active != nil && active.Load() {
+			return e.callValues(fn, args)
+		}
+		return e.invoke(ctx, fn, args)
+	}
+}
+
+// callValues 以 Go 值为参数调用 fn，并将返回值转换为 Go 值
+func (e *virtualMachine) callValues(fn *Lua.LFunction, args []any) ([]any, error) {
+	lArgs := make([]Lua.LValue, 0, len(args))
+	for i, arg := range args {
+		lv, err := e.convertToLValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument #%d: %w", i+1, err)
+		}
+		lArgs = append(lArgs, lv)
+	}
+
+	values, err := e.call(fn, lArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]any, 0, len(values))
+	for _, v := range values {
+		results = append(results, e.convertFromLValue(v))
+	}
+	return results, nil
+}
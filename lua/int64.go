@@ -0,0 +1,227 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	Lua "github.com/yuin/gopher-lua"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+// int64TypeName int64、uint64 userdata 的元表名
+const int64TypeName = "int64"
+
+// setInt64Mode 设置 int64 与 uint64 在 Lua 中的表示方式。
+// Int64AsObject 模式下注册 int64、uint64 userdata 的元表与全局构造函数 int64(v)、uint64(v)。
+func (e *virtualMachine) setInt64Mode(mode scriptEngine.Int64Mode) {
+	e.int64Mode = mode
+	if mode != scriptEngine.Int64AsObject {
+		return
+	}
+
+	L := e.L
+	mt := L.NewTypeMetatable(int64TypeName)
+	L.SetFuncs(mt, map[string]Lua.LGFunction{
+		"__add": int64Arith(false, func(a, b int64) int64 { return a + b }, func(a, b uint64) uint64 { return a + b }),
+		"__sub": int64Arith(false, func(a, b int64) int64 { return a - b }, func(a, b uint64) uint64 { return a - b }),
+		"__mul": int64Arith(false, func(a, b int64) int64 { return a * b }, func(a, b uint64) uint64 { return a * b }),
+		"__div": int64Arith(true, func(a, b int64) int64 { return a / b }, func(a, b uint64) uint64 { return a / b }),
+		"__mod": int64Arith(true, func(a, b int64) int64 { return a % b }, func(a, b uint64) uint64 { return a % b }),
+		"__unm": func(L *Lua.LState) int {
+			switch v := checkInt64(L, 1).(type) {
+			case int64:
+				L.Push(newInt64(L, -v))
+			case uint64:
+				L.Push(newInt64(L, -v))
+			}
+			return 1
+		},
+		"__eq": func(L *Lua.LState) int {
+			L.Push(Lua.LBool(int64Compare(L) == 0))
+			return 1
+		},
+		"__lt": func(L *Lua.LState) int {
+			L.Push(Lua.LBool(int64Compare(L) < 0))
+			return 1
+		},
+		"__le": func(L *Lua.LState) int {
+			L.Push(Lua.LBool(int64Compare(L) <= 0))
+			return 1
+		},
+		"__tostring": func(L *Lua.LState) int {
+			L.Push(Lua.LString(fmt.Sprint(checkInt64(L, 1))))
+			return 1
+		},
+		"__concat": func(L *Lua.LState) int {
+			L.Push(Lua.LString(int64String(L.Get(1)) + int64String(L.Get(2))))
+			return 1
+		},
+	})
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]Lua.LGFunction{
+		// tonumber 转换为 Lua 数字，超过 2^53 的值会丢失精度
+		"tonumber": func(L *Lua.LState) int {
+			switch v := checkInt64(L, 1).(type) {
+			case int64:
+				L.Push(Lua.LNumber(v))
+			case uint64:
+				L.Push(Lua.LNumber(v))
+			}
+			return 1
+		},
+	}))
+
+	L.SetGlobal("int64", L.NewFunction(func(L *Lua.LState) int {
+		n, ok, exact := toInt64Operand(L.CheckAny(1), false)
+		if !ok || !exact {
+			L.ArgError(1, "cannot convert "+L.Get(1).String()+" to int64")
+		}
+		L.Push(newInt64(L, n.(int64)))
+		return 1
+	}))
+	L.SetGlobal("uint64", L.NewFunction(func(L *Lua.LState) int {
+		n, ok, exact := toInt64Operand(L.CheckAny(1), true)
+		if !ok || !exact {
+			L.ArgError(1, "cannot convert "+L.Get(1).String()+" to uint64")
+		}
+		L.Push(newInt64(L, n.(uint64)))
+		return 1
+	}))
+}
+
+// int64Value 按 Int64Mode 将 64 位整数 v（int64 或 uint64）转换为 Lua 值
+func (e *virtualMachine) int64Value(v any) Lua.LValue {
+	switch e.int64Mode {
+	case scriptEngine.Int64AsObject:
+		return newInt64(e.L, v)
+	case scriptEngine.Int64AsString:
+		return Lua.LString(fmt.Sprint(v))
+	}
+
+	switch n := v.(type) {
+	case int64:
+		return Lua.LNumber(n)
+	case uint64:
+		return Lua.LNumber(n)
+	}
+	return Lua.LNil
+}
+
+// newInt64 创建承载 int64 或 uint64 的 userdata
+func newInt64(L *Lua.LState, v any) *Lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = v
+	ud.Metatable = L.GetTypeMetatable(int64TypeName)
+	return ud
+}
+
+// checkInt64 返回第 n 个参数承载的 int64 或 uint64
+func checkInt64(L *Lua.LState, n int) any {
+	if ud, ok := L.Get(n).(*Lua.LUserData); ok {
+		switch ud.Value.(type) {
+		case int64, uint64:
+			return ud.Value
+		}
+	}
+	L.ArgError(n, "int64 expected")
+	return nil
+}
+
+// toInt64Operand 将 int64 userdata、整数值的数字或十进制字符串转换为 int64，unsigned 为 true 时转换为 uint64。
+// lv 不是这些值时 ok 为 false，超出范围或不是整数时 exact 为 false。
+func toInt64Operand(lv Lua.LValue, unsigned bool) (n any, ok, exact bool) {
+	var b big.Int
+	switch v := lv.(type) {
+	case *Lua.LUserData:
+		switch x := v.Value.(type) {
+		case int64:
+			b.SetInt64(x)
+		case uint64:
+			b.SetUint64(x)
+		default:
+			return nil, false, false
+		}
+	case Lua.LNumber:
+		f := float64(v)
+		if f != math.Trunc(f) || math.IsInf(f, 0) {
+			return nil, true, false
+		}
+		new(big.Float).SetFloat64(f).Int(&b)
+	case Lua.LString:
+		if _, ok := b.SetString(string(v), 10); !ok {
+			return nil, false, false
+		}
+	default:
+		return nil, false, false
+	}
+
+	if unsigned {
+		return b.Uint64(), true, b.IsUint64()
+	}
+	return b.Int64(), true, b.IsInt64()
+}
+
+// int64Arith 返回 int64 userdata 的算术元方法。任一操作数为 uint64 时按 uint64 运算，否则按 int64 运算；
+// 与 Go 一致，除法向零取整，溢出时回绕；division 为 true 时除数为 0 抛出错误。
+func int64Arith(division bool, signed func(a, b int64) int64, unsigned func(a, b uint64) uint64) Lua.LGFunction {
+	return func(L *Lua.LState) int {
+		lhs, rhs := L.Get(1), L.Get(2)
+		_, lu := int64Of(lhs).(uint64)
+		_, ru := int64Of(rhs).(uint64)
+		isUnsigned := lu || ru
+
+		a, aok, aexact := toInt64Operand(lhs, isUnsigned)
+		b, bok, bexact := toInt64Operand(rhs, isUnsigned)
+		if !aok || !bok {
+			L.RaiseError("attempt to perform arithmetic on %s and %s", lhs.Type(), rhs.Type())
+		}
+		if !aexact || !bexact {
+			L.RaiseError("number has no exact int64 representation")
+		}
+
+		if isUnsigned {
+			if division && b.(uint64) == 0 {
+				L.RaiseError("integer divide by zero")
+			}
+			L.Push(newInt64(L, unsigned(a.(uint64), b.(uint64))))
+			return 1
+		}
+		if division && b.(int64) == 0 {
+			L.RaiseError("integer divide by zero")
+		}
+		L.Push(newInt64(L, signed(a.(int64), b.(int64))))
+		return 1
+	}
+}
+
+// int64Of 返回 int64 userdata 承载的值，其他值返回 nil
+func int64Of(lv Lua.LValue) any {
+	if ud, ok := lv.(*Lua.LUserData); ok {
+		return ud.Value
+	}
+	return nil
+}
+
+// int64Compare 比较前两个参数，两者都必须是 int64 userdata（Lua 只对同类型的值调用比较元方法）
+func int64Compare(L *Lua.LState) int {
+	toBig := func(v any) *big.Int {
+		switch n := v.(type) {
+		case int64:
+			return big.NewInt(n)
+		case uint64:
+			return new(big.Int).SetUint64(n)
+		}
+		return nil
+	}
+	return toBig(checkInt64(L, 1)).Cmp(toBig(checkInt64(L, 2)))
+}
+
+// int64String 返回 .. 运算中操作数的字符串形式
+func int64String(lv Lua.LValue) string {
+	switch v := int64Of(lv).(type) {
+	case int64, uint64:
+		return fmt.Sprint(v)
+	}
+	return lv.String()
+}
@@ -12,14 +12,21 @@ import (
 )
 
 func init() {
-	_ = scriptEngine.Register(scriptEngine.LuaType, func() (scriptEngine.Engine, error) {
-		return newLuaEngine()
+	_ = scriptEngine.Register(scriptEngine.LuaType, func(opts ...scriptEngine.Option) (scriptEngine.Engine, error) {
+		return newLuaEngine(opts...)
 	})
 }
 
+// callResult 工作协程返回的执行结果
+type callResult struct {
+	value any
+	err   error
+}
+
 // engine Lua 脚本引擎实现
 type engine struct {
 	vm          *virtualMachine
+	options     *scriptEngine.Options
 	initialized bool
 	lastError   error
 
@@ -28,8 +35,9 @@ type engine struct {
 }
 
 // newLuaEngine 创建 Lua 引擎实例
-func newLuaEngine() (*engine, error) {
+func newLuaEngine(opts ...scriptEngine.Option) (*engine, error) {
 	return &engine{
+		options:     scriptEngine.NewOptions(opts...),
 		initialized: false,
 	}, nil
 }
@@ -44,11 +52,25 @@ func (e *engine) Init(_ context.Context) error {
 	defer e.mu.Unlock()
 
 	if e.initialized {
-		e.setLastError(ErrLuaEngineAlreadyInitialized)
-		return ErrLuaEngineAlreadyInitialized
+		err := newScriptError(ErrLuaEngineAlreadyInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	e.vm = newVirtualMachine()
+	vm := newVirtualMachine()
+	vm.structMode = e.options.StructMode
+	vm.setFieldNaming(e.options.FieldNaming)
+	vm.setInt64Mode(e.options.Int64Mode)
+	vm.setBytesMode(e.options.BytesMode)
+	vm.setPackagePath(e.options)
+	vm.setModuleLoader(e.options.ModuleLoader, e.options.ModulePaths)
+	vm.await = func(ctx context.Context, f *Future) error {
+		return e.await(vm, ctx, f)
+	}
+	vm.invoke = func(ctx context.Context, fn *Lua.LFunction, args []any) ([]any, error) {
+		return e.invoke(vm, ctx, fn, args)
+	}
+	e.vm = vm
 	e.initialized = true
 	e.ClearError()
 
@@ -61,11 +83,16 @@ func (e *engine) Close() error {
 	defer e.mu.Unlock()
 
 	if !e.initialized {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return ErrLuaEngineNotInitialized
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	e.vm.Destroy()
+	// 仍有执行在等待 Future 时，由最后一个结束的执行销毁虚拟机
+	close(e.vm.closed)
+	if e.vm.waiting == 0 {
+		e.vm.Destroy()
+	}
 	e.vm = nil
 	e.initialized = false
 
@@ -85,11 +112,13 @@ func (e *engine) LoadString(_ context.Context, source string) error {
 	defer e.mu.Unlock()
 
 	if !e.initialized {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return ErrLuaEngineNotInitialized
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
 	if err := e.vm.LoadString(source); err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return err
 	}
@@ -117,17 +146,19 @@ func (e *engine) LoadFiles(ctx context.Context, filePaths []string) error {
 	return nil
 }
 
-// LoadFile 加载脚本文件
+// LoadFile 加载脚本文件，相对路径按 Options.ScriptPath 解析
 func (e *engine) LoadFile(_ context.Context, filePath string) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	if !e.initialized {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return ErrLuaEngineNotInitialized
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	if err := e.vm.LoadFile(filePath); err != nil {
+	if err := e.vm.LoadFile(e.options.ScriptPath(filePath)); err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return err
 	}
@@ -141,6 +172,7 @@ func (e *engine) LoadFile(_ context.Context, filePath string) error {
 func (e *engine) LoadReader(ctx context.Context, reader io.Reader, _ string) error {
 	source, err := io.ReadAll(reader)
 	if err != nil {
+		err = newScriptError(err)
 		e.setLastError(err)
 		return err
 	}
@@ -150,39 +182,10 @@ func (e *engine) LoadReader(ctx context.Context, reader io.Reader, _ string) err
 
 // ExecuteLoaded 执行已加载的脚本
 func (e *engine) ExecuteLoaded(ctx context.Context) (any, error) {
-	if !e.IsInitialized() {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return nil, ErrLuaEngineNotInitialized
-	}
-
-	// 使用 channel 处理超时
-	done := make(chan error, 1)
-
-	go func() {
-		e.mu.Lock()
-		defer e.mu.Unlock()
-
-		if !e.initialized {
-			done <- ErrLuaEngineNotInitialized
-			return
-		}
-
-		done <- e.vm.Execute()
-	}()
-
-	select {
-	case <-ctx.Done():
-		e.setLastError(ctx.Err())
-		return nil, ctx.Err()
-
-	case err := <-done:
-		if err != nil {
-			e.setLastError(err)
-			return nil, err
-		}
-		e.ClearError()
-		return nil, nil
-	}
+	return e.execute(ctx, scriptEngine.ExecuteOptions{}, func(vm *virtualMachine) (any, error) {
+		values, err := vm.Execute()
+		return e.packResults(vm, values), err
+	})
 }
 
 func (e *engine) ExecuteStrings(ctx context.Context, sources []string) ([]any, error) {
@@ -211,87 +214,45 @@ func (e *engine) ExecuteFiles(ctx context.Context, filePaths []string) ([]any, e
 
 // ExecuteString 执行字符串脚本
 func (e *engine) ExecuteString(ctx context.Context, source string) (any, error) {
-	if !e.IsInitialized() {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return nil, ErrLuaEngineNotInitialized
-	}
-
-	done := make(chan error, 1)
-
-	go func() {
-		e.mu.Lock()
-		defer e.mu.Unlock()
-
-		if !e.initialized {
-			done <- ErrLuaEngineNotInitialized
-			return
-		}
-
-		done <- e.vm.ExecuteString(source)
-	}()
-
-	select {
-	case <-ctx.Done():
-		e.setLastError(ctx.Err())
-		return nil, ctx.Err()
+	return e.ExecuteStringWithOptions(ctx, source, scriptEngine.ExecuteOptions{})
+}
 
-	case err := <-done:
-		if err != nil {
-			e.setLastError(err)
-			return nil, err
-		}
-		e.ClearError()
-		return nil, nil
-	}
+// ExecuteStringWithOptions 按 opts 执行字符串脚本，opts 仅对本次执行生效
+func (e *engine) ExecuteStringWithOptions(ctx context.Context, source string, opts scriptEngine.ExecuteOptions) (any, error) {
+	return e.execute(ctx, opts, func(vm *virtualMachine) (any, error) {
+		values, err := vm.ExecuteString(source)
+		return e.packResults(vm, values), err
+	})
 }
 
-// ExecuteFile 执行脚本文件
+// ExecuteFile 执行脚本文件，相对路径按 Options.ScriptPath 解析
 func (e *engine) ExecuteFile(ctx context.Context, filePath string) (any, error) {
-	if !e.IsInitialized() {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return nil, ErrLuaEngineNotInitialized
-	}
-
-	done := make(chan error, 1)
-
-	go func() {
-		e.mu.Lock()
-		defer e.mu.Unlock()
-
-		if !e.initialized {
-			done <- ErrLuaEngineNotInitialized
-			return
-		}
-
-		done <- e.vm.ExecuteFile(filePath)
-	}()
-
-	select {
-	case <-ctx.Done():
-		e.setLastError(ctx.Err())
-		return nil, ctx.Err()
-
-	case err := <-done:
-		if err != nil {
-			e.setLastError(err)
-			return nil, err
-		}
-		e.ClearError()
-		return nil, nil
-	}
+	return e.execute(ctx, scriptEngine.ExecuteOptions{}, func(vm *virtualMachine) (any, error) {
+		values, err := vm.ExecuteFile(e.options.ScriptPath(filePath))
+		return e.packResults(vm, values), err
+	})
 }
 
-// RegisterGlobal 注册全局变量
+// RegisterGlobal 注册全局变量。
+// 值通过反射按 StructMode、Int64Mode 与 BytesMode 转换，struct 默认复制为 table，
+// 需要脚本读写字段并调用方法时使用 StructAsUserData；无法转换的值返回错误。
 func (e *engine) RegisterGlobal(name string, value any) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	if !e.initialized {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return ErrLuaEngineNotInitialized
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	e.vm.BindStruct(name, value)
+	lv, err := e.vm.convertToLValue(value)
+	if err != nil {
+		err = newScriptError(err)
+		e.setLastError(err)
+		return err
+	}
+	e.vm.L.SetGlobal(name, lv)
 
 	e.ClearError()
 	return nil
@@ -303,8 +264,9 @@ func (e *engine) GetGlobal(name string) (any, error) {
 	defer e.mu.RUnlock()
 
 	if !e.initialized {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return nil, ErrLuaEngineNotInitialized
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
 	}
 
 	lv := e.vm.L.GetGlobal(name)
@@ -319,104 +281,343 @@ func (e *engine) RegisterFunction(name string, fn any) error {
 	defer e.mu.Unlock()
 
 	if !e.initialized {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return ErrLuaEngineNotInitialized
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return err
 	}
 
-	// 类型断言检查是否为 Lua.LGFunction
-	if lf, ok := fn.(Lua.LGFunction); ok {
-		e.vm.RegisterFunction(name, lf)
-		e.ClearError()
-		return nil
+	var err error
+	switch f := fn.(type) {
+	case Lua.LGFunction:
+		e.vm.RegisterFunction(name, f)
+	case func(*Lua.LState) int:
+		e.vm.RegisterFunction(name, f)
+	case AsyncFunction:
+		err = e.vm.RegisterAsyncFunction(name, f)
+	case func(context.Context, ...any) *Future:
+		err = e.vm.RegisterAsyncFunction(name, f)
+	default:
+		// 其他 Go 函数通过反射转换参数与返回值
+		err = e.vm.RegisterGoFunction(name, fn)
+	}
+	if err != nil {
+		err = newScriptError(err)
+		e.setLastError(err)
+		return err
 	}
 
-	err := fmt.Errorf("function must be of type Lua.LGFunction")
-	e.setLastError(err)
+	e.ClearError()
+	return nil
+}
+
+// invoke 在引擎中调用转换为 Go 值的 Lua 函数 fn，引擎被关闭或重新初始化后返回 ErrLuaEngineNotInitialized
+func (e *engine) invoke(vm *virtualMachine, ctx context.Context, fn *Lua.LFunction, args []any) ([]any, error) {
+	values, err := e.execute(ctx, scriptEngine.ExecuteOptions{}, func(current *virtualMachine) (any, error) {
+		if current != vm {
+			return nil, ErrLuaEngineNotInitialized
+		}
+		return vm.callValues(fn, args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values.([]any), nil
+}
+
+// await 释放引擎锁等待 f 完成，等待期间引擎可以执行其他调用。
+// 调用方需持有引擎锁，返回时重新持有引擎锁；等待期间引擎被关闭时返回 ErrLuaEngineNotInitialized。
+func (e *engine) await(vm *virtualMachine, ctx context.Context, f *Future) error {
+	vm.waiting++
+	// 等待期间其他执行会替换 vm.ctx
+	current := vm.ctx
+	e.mu.Unlock()
+
+	var err error
+	select {
+	case <-f.Done():
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-vm.closed:
+		err = ErrLuaEngineNotInitialized
+	}
+
+	e.mu.Lock()
+	vm.waiting--
+	vm.ctx = current
+
+	if err == nil && e.vm != vm {
+		err = ErrLuaEngineNotInitialized
+	}
 	return err
 }
 
-// CallFunction 调用 Lua 函数
+// CallFunction 调用 Lua 函数，返回函数的第一个返回值
 func (e *engine) CallFunction(ctx context.Context, name string, args ...any) (any, error) {
-	if !e.IsInitialized() {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return nil, ErrLuaEngineNotInitialized
+	return e.CallFunctionWithOptions(ctx, name, scriptEngine.ExecuteOptions{}, args...)
+}
+
+// CallFunctionWithOptions 按 opts 调用 Lua 函数，返回函数的第一个返回值，opts 仅对本次调用生效
+func (e *engine) CallFunctionWithOptions(ctx context.Context, name string, opts scriptEngine.ExecuteOptions, args ...any) (any, error) {
+	return e.execute(ctx, opts, func(vm *virtualMachine) (any, error) {
+		values, err := e.callFunction(vm, name, args)
+		if err != nil || len(values) == 0 {
+			return nil, err
+		}
+		return vm.convertFromLValue(values[0]), nil
+	})
+}
+
+// CallFunctionMulti 调用 Lua 函数，返回函数的全部返回值。
+// 函数按 Lua 惯例以 `return nil, err` 报告失败时，err 会被放入 CallResult.Error。
+func (e *engine) CallFunctionMulti(ctx context.Context, name string, args ...any) (scriptEngine.CallResult, error) {
+	res, err := e.execute(ctx, scriptEngine.ExecuteOptions{}, func(vm *virtualMachine) (any, error) {
+		values, err := e.callFunction(vm, name, args)
+		if err != nil {
+			return nil, err
+		}
+
+		result := scriptEngine.CallResult{
+			Values: make([]any, 0, len(values)),
+		}
+		for _, v := range values {
+			result.Values = append(result.Values, vm.convertFromLValue(v))
+		}
+		result.Error = vm.conventionalError(values)
+		return result, nil
+	})
+	if err != nil {
+		return scriptEngine.CallResult{}, err
 	}
+	return res.(scriptEngine.CallResult), nil
+}
+
+// InspectFunction 返回全局函数 name 的参数信息。
+// Go 实现的函数与带有 __call 元方法的值视为接受任意个数的参数。
+func (e *engine) InspectFunction(name string) (scriptEngine.FunctionSignature, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
-	type result struct {
-		value any
-		err   error
+	if !e.initialized {
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return scriptEngine.FunctionSignature{}, err
 	}
 
-	done := make(chan result, 1)
+	switch fn := e.vm.L.GetGlobal(name).(type) {
+	case *Lua.LNilType:
+		return scriptEngine.FunctionSignature{}, newScriptError(fmt.Errorf("%w: %s", ErrLuaFunctionNotFound, name))
+	case *Lua.LFunction:
+		if fn.IsG {
+			return scriptEngine.FunctionSignature{Variadic: true}, nil
+		}
+		return scriptEngine.FunctionSignature{
+			Params:   int(fn.Proto.NumParameters),
+			Variadic: fn.Proto.IsVarArg&Lua.VarArgIsVarArg != 0,
+		}, nil
+	default:
+		if e.vm.L.GetMetaField(fn, "__call") == Lua.LNil {
+			return scriptEngine.FunctionSignature{}, newScriptError(fmt.Errorf("%w: %s", ErrLuaNotAFunction, name))
+		}
+		return scriptEngine.FunctionSignature{Variadic: true}, nil
+	}
+}
+
+// callFunction 在 vm 中转换参数并调用全局函数 name，返回全部返回值。调用方需持有引擎锁。
+func (e *engine) callFunction(vm *virtualMachine, name string, args []any) ([]Lua.LValue, error) {
+	fn := vm.L.GetGlobal(name)
+	switch fn.Type() {
+	case Lua.LTNil:
+		return nil, fmt.Errorf("%w: %s", ErrLuaFunctionNotFound, name)
+	case Lua.LTFunction:
+	default:
+		// 带有 __call 元方法的值同样可以调用
+		if vm.L.GetMetaField(fn, "__call") == Lua.LNil {
+			return nil, fmt.Errorf("%w: %s", ErrLuaNotAFunction, name)
+		}
+	}
+
+	lArgs := make([]Lua.LValue, 0, len(args))
+	for i, arg := range args {
+		lv, err := vm.convertToLValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument #%d: %w", i+1, err)
+		}
+		lArgs = append(lArgs, lv)
+	}
+
+	return vm.call(fn, lArgs...)
+}
+
+// RegisterModule 注册模块，脚本通过 require(name) 加载模块。
+// module 可以是模块的加载函数 Lua.LGFunction、成员为 Go 函数或值的 map[string]any，
+// struct（及其指针），struct 的导出字段与方法作为模块成员，或者 *scriptEngine.NativeModule，
+// 其子模块同时可以通过 require("name/子模块名") 加载。
+func (e *engine) RegisterModule(name string, module any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.initialized {
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return err
+	}
+
+	var err error
+	switch mod := module.(type) {
+	case Lua.LGFunction:
+		e.vm.RegisterModule(name, mod)
+	case func(*Lua.LState) int:
+		e.vm.RegisterModule(name, mod)
+	case map[string]any:
+		err = e.vm.RegisterModuleTable(name, mod)
+	case *scriptEngine.NativeModule:
+		err = e.vm.RegisterNativeModule(name, mod)
+	default:
+		if members, ok := e.vm.structMembers(module); ok {
+			err = e.vm.RegisterModuleTable(name, members)
+		} else {
+			err = fmt.Errorf("module must be of type Lua.LGFunction, map[string]any, struct or *NativeModule, got %T", module)
+		}
+	}
+	if err != nil {
+		err = newScriptError(err)
+		e.setLastError(err)
+		return err
+	}
+
+	e.ClearError()
+	return nil
+}
+
+// execute 在工作协程中持有引擎锁，按 opts 执行 fn。
+// 执行期间 ctx 被绑定到 LState，ctx 超时或取消时虚拟机会在下一条指令处中止脚本并释放引擎锁，
+// 因此死循环脚本不会永久占用引擎。设置了预算时，指令数超出预算同样会中止脚本。
+// 脚本调用异步函数时执行脚本的 Lua 协程让出，工作协程释放引擎锁等待 Future，完成后重新持有引擎锁并恢复 Lua 协程。
+// fn 收到本次执行固定使用的虚拟机：等待期间引擎可能被关闭，e.vm 随之改变，fn 只能使用收到的虚拟机。
+func (e *engine) execute(ctx context.Context, opts scriptEngine.ExecuteOptions, fn func(vm *virtualMachine) (any, error)) (any, error) {
+	if !e.IsInitialized() {
+		err := newScriptError(ErrLuaEngineNotInitialized)
+		e.setLastError(err)
+		return nil, err
+	}
+
+	ctx, cancel := opts.Context(ctx)
+	defer cancel()
+
+	// 使用 channel 处理超时
+	done := make(chan callResult, 1)
 
 	go func() {
 		e.mu.Lock()
 		defer e.mu.Unlock()
 
 		if !e.initialized {
-			done <- result{nil, ErrLuaEngineNotInitialized}
+			done <- callResult{nil, ErrLuaEngineNotInitialized}
 			return
 		}
 
-		// 转换参数
-		var lArgs []Lua.LValue
-		for _, arg := range args {
-			lArgs = append(lArgs, e.vm.convertToLValue(arg))
+		// 等待 Future 期间引擎锁会被释放，引擎可能被关闭，因此执行期间固定使用当前虚拟机
+		vm := e.vm
+		defer func() {
+			if e.vm != vm && vm.waiting == 0 {
+				vm.Destroy()
+			}
+		}()
+
+		// 等待锁期间 ctx 已结束，无需再执行
+		if err := ctx.Err(); err != nil {
+			done <- callResult{nil, newTimeoutError(err, nil)}
+			return
 		}
 
-		// 调用函数
-		err := e.vm.L.CallByParam(Lua.P{
-			Fn:      e.vm.L.GetGlobal(name),
-			NRet:    1,
-			Protect: true,
-		}, lArgs...)
+		var budget *budgetContext
+		if limit := opts.BudgetOr(e.options.Budget); limit > 0 {
+			budget = newBudgetContext(ctx, limit)
+			defer budget.cancel()
+			vm.L.SetContext(instructionMeter{budget})
+			defer vm.L.RemoveContext()
+		} else if ctx.Done() != nil {
+			// 不可取消的 ctx 无需绑定，避免虚拟机逐条指令检查带来的开销
+			vm.L.SetContext(ctx)
+			defer vm.L.RemoveContext()
+		}
 
+		restore, err := e.applyOptions(vm, opts)
 		if err != nil {
-			done <- result{nil, err}
+			done <- callResult{nil, err}
 			return
 		}
+		defer restore()
 
-		// 获取返回值
-		ret := e.vm.L.Get(-1)
-		e.vm.L.Pop(1)
+		previous := vm.ctx
+		vm.ctx = ctx
+		defer func() { vm.ctx = previous }()
 
-		done <- result{e.vm.convertFromLValue(ret), nil}
+		value, err := fn(vm)
+		if err != nil {
+			// 脚本因预算耗尽或 ctx 结束而中止时，以对应的错误作为原因
+			if budget != nil && budget.exceeded() {
+				err = newTimeoutError(budget.Err(), err)
+			} else if ctxErr := ctx.Err(); ctxErr != nil {
+				err = newTimeoutError(ctxErr, err)
+			} else {
+				err = vm.withThrown(err)
+			}
+		}
+		done <- callResult{value, err}
 	}()
 
 	select {
 	case <-ctx.Done():
-		e.setLastError(ctx.Err())
-		return nil, ctx.Err()
+		err := newTimeoutError(ctx.Err(), nil)
+		e.setLastError(err)
+		return nil, err
 
 	case res := <-done:
 		if res.err != nil {
+			res.err = newScriptError(res.err)
 			e.setLastError(res.err)
-			return res.value, res.err
+			return nil, res.err
 		}
 		e.ClearError()
-		return res.value, res.err
+		return res.value, nil
 	}
 }
 
-// RegisterModule 注册模块
-func (e *engine) RegisterModule(name string, module any) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+// applyOptions 为本次执行注入 opts 中的全局变量并限制调用栈深度，返回恢复先前状态的函数；
+// 全局变量无法转换时返回错误。调用方需持有引擎锁。
+func (e *engine) applyOptions(vm *virtualMachine, opts scriptEngine.ExecuteOptions) (restore func(), err error) {
+	restoreGlobals, err := vm.SetGlobals(opts.Globals)
+	if err != nil {
+		return nil, err
+	}
 
-	if !e.initialized {
-		e.setLastError(ErrLuaEngineNotInitialized)
-		return ErrLuaEngineNotInitialized
+	maxStack := vm.maxStack
+	if opts.MaxStack > 0 {
+		vm.maxStack = opts.MaxStack
 	}
 
-	if mod, ok := module.(Lua.LGFunction); ok {
-		e.vm.RegisterModule(name, mod)
-		e.ClearError()
+	return func() {
+		vm.maxStack = maxStack
+		restoreGlobals()
+	}, nil
+}
+
+// packResults 将 vm 中代码块的返回值转换为 Go 值：
+// 无返回值时为 nil，单个返回值直接返回，多个返回值以切片返回。
+func (e *engine) packResults(vm *virtualMachine, values []Lua.LValue) any {
+	switch len(values) {
+	case 0:
 		return nil
+	case 1:
+		return vm.convertFromLValue(values[0])
+	default:
+		results := make([]any, 0, len(values))
+		for _, v := range values {
+			results = append(results, vm.convertFromLValue(v))
+		}
+		return results
 	}
-
-	err := fmt.Errorf("module must be of type Lua.LGFunction")
-	e.setLastError(err)
-	return err
 }
 
 // GetLastError 获取最后一个错误
@@ -1,15 +1,25 @@
 package lua
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	Lua "github.com/yuin/gopher-lua"
+
+	scriptEngine "github.com/tx7do/go-scripts"
 )
 
 func TestLuaEngine(t *testing.T) {
@@ -196,3 +206,1543 @@ func TestConcurrentInitClose(t *testing.T) {
 
 	fmt.Println("concurrent init/close test completed")
 }
+
+func TestExecuteReturnValues(t *testing.T) {
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, eng.Init(ctx))
+
+	// 无返回值
+	result, err := eng.ExecuteString(ctx, `local a = 1`)
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+
+	// 单个返回值
+	result, err = eng.ExecuteString(ctx, `return 1 + 2`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), result)
+
+	// 多个返回值
+	result, err = eng.ExecuteString(ctx, `return "a", true, 1.5`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"a", true, 1.5}, result)
+
+	// 已加载的脚本
+	assert.Nil(t, eng.LoadString(ctx, `return { name = "lua" }`))
+	result, err = eng.ExecuteLoaded(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"name": "lua"}, result)
+
+	// 脚本文件
+	result, err = eng.ExecuteFile(ctx, "./script/test_module1.lua")
+	assert.Nil(t, err)
+	assert.IsType(t, map[string]any{}, result)
+}
+
+func TestContextCancelStopsScript(t *testing.T) {
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+
+	assert.Nil(t, eng.Init(context.Background()))
+
+	// 超时应中止死循环脚本
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = eng.ExecuteString(ctx, `while true do end`)
+	cancel()
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// 超时后引擎应能立即执行下一个脚本
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	result, err := eng.ExecuteString(ctx, `
+		function spin()
+			while true do end
+		end
+		return 1
+	`)
+	cancel()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), result)
+
+	// 主动取消应中止函数调用
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = eng.CallFunction(ctx, "spin")
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err = eng.ExecuteString(ctx, `return 2`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), result)
+}
+
+func TestCallFunctionMulti(t *testing.T) {
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, eng.Init(ctx))
+
+	_, err = eng.ExecuteString(ctx, `
+		function divide(a, b)
+			if b == 0 then
+				return nil, "division by zero"
+			end
+			return a / b, a % b
+		end
+	`)
+	assert.Nil(t, err)
+
+	res, err := eng.CallFunctionMulti(ctx, "divide", 7, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{3.5, int64(1)}, res.Values)
+	assert.Nil(t, res.Error)
+
+	res, err = eng.CallFunctionMulti(ctx, "divide", 1, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{nil, "division by zero"}, res.Values)
+	assert.EqualError(t, res.Error, "division by zero")
+
+	// CallFunction 仍只返回第一个返回值
+	result, err := eng.CallFunction(ctx, "divide", 7, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.5, result)
+}
+
+func TestExecuteOptions(t *testing.T) {
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, eng.Init(ctx))
+
+	// Timeout 转换为截止时间
+	_, err = eng.ExecuteStringWithOptions(ctx, `while true do end`, scriptEngine.ExecuteOptions{
+		Timeout: 50 * time.Millisecond,
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// Globals 仅在本次执行期间可见
+	assert.Nil(t, eng.RegisterGlobal("tenant", "default"))
+	result, err := eng.ExecuteStringWithOptions(ctx, `return tenant, region`, scriptEngine.ExecuteOptions{
+		Globals: map[string]any{"tenant": "acme", "region": "cn"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"acme", "cn"}, result)
+
+	result, err = eng.ExecuteString(ctx, `return tenant, region`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"default", nil}, result)
+
+	// MaxStack 限制调用栈深度
+	_, err = eng.ExecuteString(ctx, `
+		function depth(n)
+			if n == 0 then
+				return 0
+			end
+			return 1 + depth(n - 1)
+		end
+	`)
+	assert.Nil(t, err)
+
+	_, err = eng.CallFunctionWithOptions(ctx, "depth", scriptEngine.ExecuteOptions{MaxStack: 50}, 100)
+	assert.NotNil(t, err)
+
+	result, err = eng.CallFunctionWithOptions(ctx, "depth", scriptEngine.ExecuteOptions{MaxStack: 50}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), result)
+
+	// 调用结束后恢复默认调用栈
+	result, err = eng.CallFunction(ctx, "depth", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), result)
+}
+
+func TestBudget(t *testing.T) {
+	eng, err := newLuaEngine(scriptEngine.WithBudget(10000))
+	assert.Nil(t, err)
+	defer eng.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, eng.Init(ctx))
+
+	// 死循环在预算耗尽后中止，pcall 无法吞掉该错误
+	_, err = eng.ExecuteString(ctx, `while true do pcall(function() while true do end end) end`)
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+
+	var exceeded *scriptEngine.BudgetExceededError
+	assert.True(t, errors.As(err, &exceeded))
+	assert.Equal(t, int64(10000), exceeded.Limit)
+	assert.Greater(t, exceeded.Used, exceeded.Limit)
+
+	// 协程内执行的指令同样计入预算
+	_, err = eng.ExecuteString(ctx, `coroutine.wrap(function() while true do end end)()`)
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+
+	// 预算内的脚本正常执行，引擎可继续使用
+	result, err := eng.ExecuteString(ctx, `
+		local sum = 0
+		for i = 1, 10 do
+			sum = sum + i
+		end
+		return sum
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(55), result)
+
+	// 单次调用的预算覆盖引擎默认值
+	_, err = eng.ExecuteString(ctx, `
+		function spin(n)
+			for i = 1, n do end
+			return n
+		end
+	`)
+	assert.Nil(t, err)
+
+	_, err = eng.CallFunctionWithOptions(ctx, "spin", scriptEngine.ExecuteOptions{Budget: 10}, 100)
+	assert.True(t, errors.Is(err, scriptEngine.ErrBudgetExceeded))
+
+	result, err = eng.CallFunctionWithOptions(ctx, "spin", scriptEngine.ExecuteOptions{Budget: 1000}, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), result)
+
+	// 只计量虚拟机指令，宿主函数等待 ctx.Done() 不消耗预算
+	assert.Nil(t, eng.RegisterFunction("pollBudget", func(ctx context.Context, n int) bool {
+		for i := 0; i < n; i++ {
+			select {
+			case <-ctx.Done():
+				return false
+			default:
+			}
+		}
+		return ctx.Err() == nil
+	}))
+	result, err = eng.ExecuteStringWithOptions(ctx, `return pollBudget(100000)`, scriptEngine.ExecuteOptions{Budget: 100})
+	assert.Nil(t, err)
+	assert.Equal(t, true, result)
+}
+
+func TestScriptError(t *testing.T) {
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	defer eng.Close()
+
+	ctx := context.Background()
+	assert.Nil(t, eng.Init(ctx))
+
+	var se *scriptEngine.ScriptError
+
+	// 编译错误
+	err = eng.LoadString(ctx, "local a = 1\nx = = 2")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.LuaType, se.Engine)
+	assert.Equal(t, scriptEngine.ErrorKindCompile, se.Kind)
+	assert.Equal(t, 2, se.Line)
+	assert.Equal(t, 5, se.Column)
+
+	// 运行时错误，包含调用栈
+	_, err = eng.ExecuteString(ctx, `
+		function inner()
+			error("boom")
+		end
+		function outer()
+			inner()
+		end
+	`)
+	assert.Nil(t, err)
+
+	_, err = eng.CallFunction(ctx, "outer")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindRuntime, se.Kind)
+	assert.Equal(t, "<string>", se.File)
+	assert.Equal(t, 3, se.Line)
+	assert.Equal(t, "boom", se.Message)
+	assert.GreaterOrEqual(t, len(se.Stack), 3)
+	assert.Equal(t, "error", se.Stack[0].Function)
+	assert.Equal(t, "inner", se.Stack[1].Function)
+	assert.Equal(t, 6, se.Stack[2].Line)
+
+	// Go 函数中的 panic
+	assert.Nil(t, eng.RegisterFunction("fail", Lua.LGFunction(func(L *Lua.LState) int {
+		panic("host failure")
+	})))
+	_, err = eng.ExecuteString(ctx, `fail()`)
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindHost, se.Kind)
+	assert.Equal(t, "host failure", se.Message)
+
+	// 超时
+	_, err = eng.ExecuteStringWithOptions(ctx, `while true do end`, scriptEngine.ExecuteOptions{Timeout: 20 * time.Millisecond})
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindTimeout, se.Kind)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// 注册、检查函数与生命周期方法返回的错误同样是宿主错误
+	uninitialized, err := newLuaEngine()
+	assert.Nil(t, err)
+	for _, err := range []error{
+		eng.RegisterGlobal("ch", make(chan int)),
+		eng.Init(ctx),
+		uninitialized.Close(),
+		uninitialized.RegisterFunction("f", func() {}),
+		uninitialized.RegisterModule("m", map[string]any{}),
+		func() error { _, err := eng.InspectFunction("missing"); return err }(),
+		func() error { _, err := uninitialized.GetGlobal("a"); return err }(),
+	} {
+		assert.True(t, errors.As(err, &se), err)
+		assert.Equal(t, scriptEngine.ErrorKindHost, se.Kind, err)
+	}
+	assert.True(t, errors.Is(eng.RegisterGlobal("ch", make(chan int)), ErrLuaUnsupportedType))
+	assert.True(t, errors.Is(uninitialized.Close(), scriptEngine.ErrNotInitialized))
+}
+
+func TestSharedErrors(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+
+	_, err = eng.ExecuteString(ctx, `return 1`)
+	assert.True(t, errors.Is(err, scriptEngine.ErrNotInitialized))
+
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+	assert.True(t, errors.Is(eng.Init(ctx), scriptEngine.ErrAlreadyInitialized))
+
+	_, err = eng.ExecuteLoaded(ctx)
+	assert.True(t, errors.Is(err, scriptEngine.ErrNoProgramLoaded))
+
+	_, err = eng.CallFunction(ctx, "missing")
+	assert.True(t, errors.Is(err, scriptEngine.ErrFunctionNotFound))
+
+	_, err = eng.ExecuteString(ctx, `answer = 42`)
+	assert.Nil(t, err)
+	_, err = eng.CallFunction(ctx, "answer")
+	assert.True(t, errors.Is(err, scriptEngine.ErrNotAFunction))
+
+	_, err = eng.ExecuteStringWithOptions(ctx, `while true do end`, scriptEngine.ExecuteOptions{Timeout: 20 * time.Millisecond})
+	assert.True(t, errors.Is(err, scriptEngine.ErrTimeout))
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = eng.ExecuteString(cancelCtx, `while true do end`)
+	assert.True(t, errors.Is(err, scriptEngine.ErrCancelled))
+}
+
+func TestAsyncFunction(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	release := make(chan struct{})
+	err = eng.RegisterFunction("fetch", AsyncFunction(func(ctx context.Context, args ...any) *Future {
+		return Go(func() ([]any, error) {
+			<-release
+			if args[0] == "bad" {
+				return nil, errors.New("fetch failed")
+			}
+			return []any{"got " + args[0].(string), 2}, nil
+		})
+	}))
+	assert.Nil(t, err)
+
+	_, err = eng.ExecuteString(ctx, `
+		function load(key)
+			local value, n = fetch(key)
+			return value .. " " .. n
+		end
+	`)
+	assert.Nil(t, err)
+
+	// 挂起期间引擎可以执行其他调用
+	done := make(chan callResult, 1)
+	go func() {
+		value, err := eng.CallFunction(ctx, "load", "a")
+		done <- callResult{value, err}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	value, err := eng.ExecuteString(ctx, `return 1 + 1`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, value)
+
+	close(release)
+	res := <-done
+	assert.Nil(t, res.err)
+	assert.Equal(t, "got a 2", res.value)
+
+	// Future 失败时在脚本中抛出错误，可以被 pcall 捕获
+	value, err = eng.ExecuteString(ctx, `
+		local ok, msg = pcall(fetch, "bad")
+		return tostring(msg)
+	`)
+	assert.Nil(t, err)
+	assert.Contains(t, value, "fetch failed")
+
+	_, err = eng.CallFunction(ctx, "load", "bad")
+	var se *scriptEngine.ScriptError
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, 3, se.Line)
+	assert.Contains(t, se.Message, "fetch failed")
+
+	// 在脚本创建的协程中调用时同步等待
+	value, err = eng.ExecuteString(ctx, `
+		local co = coroutine.wrap(function() return load("c") end)
+		return co()
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, "got c 2", value)
+
+	// 等待期间 ctx 超时
+	err = eng.RegisterFunction("never", AsyncFunction(func(ctx context.Context, args ...any) *Future {
+		return NewFuture()
+	}))
+	assert.Nil(t, err)
+	_, err = eng.ExecuteStringWithOptions(ctx, `never()`, scriptEngine.ExecuteOptions{Timeout: 20 * time.Millisecond})
+	assert.True(t, errors.Is(err, scriptEngine.ErrTimeout))
+
+	// 等待期间引擎被关闭，脚本捕获错误后继续在原虚拟机中执行
+	closing, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, closing.Init(ctx))
+	assert.Nil(t, closing.RegisterFunction("never", AsyncFunction(func(ctx context.Context, args ...any) *Future {
+		return NewFuture()
+	})))
+	go func() {
+		value, err := closing.ExecuteString(ctx, `
+			local ok, msg = pcall(never)
+			return { ok, tostring(msg), function() end }
+		`)
+		done <- callResult{value, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, closing.Close())
+	res = <-done
+	assert.Nil(t, res.err)
+	assert.Equal(t, false, res.value.([]any)[0])
+	assert.Contains(t, res.value.([]any)[1], "not initialized")
+	assert.NotNil(t, res.value.([]any)[2])
+
+	// 让出协程期间引擎被关闭，执行返回错误
+	closing, err = newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, closing.Init(ctx))
+	assert.Nil(t, closing.RegisterFunction("never", AsyncFunction(func(ctx context.Context, args ...any) *Future {
+		return NewFuture()
+	})))
+	go func() {
+		value, err := closing.ExecuteString(ctx, `never()`)
+		done <- callResult{value, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, closing.Close())
+	res = <-done
+	assert.True(t, errors.Is(res.err, ErrLuaEngineNotInitialized))
+}
+
+func TestAsyncYield(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterFunction("fetch", AsyncFunction(func(ctx context.Context, args ...any) *Future {
+		f := NewFuture()
+		f.Resolve(args...)
+		return f
+	})))
+
+	// 只有直接由脚本调用、中间没有由 Go 发起的调用时才能让出
+	var yieldable []bool
+	assert.Nil(t, eng.RegisterFunction("probe", func(L *Lua.LState) int {
+		yieldable = append(yieldable, eng.vm.yieldable(L))
+		return 0
+	}))
+	_, err = eng.ExecuteString(ctx, `
+		probe()
+		local function f() probe() end
+		f()
+		pcall(probe)
+		local _ = setmetatable({}, { __index = function() probe() end }).x
+		for _ in function(_, i) if i == nil then probe() return 1 end end do end
+		coroutine.wrap(probe)()
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{true, true, false, false, false, false}, yieldable)
+
+	// 无论是否让出，异步函数的返回值相同
+	value, err := eng.ExecuteString(ctx, `
+		local a, b = fetch(1, "x")
+		local ok, c, d = pcall(fetch, 2, "y")
+		local e = setmetatable({}, { __index = function(_, k) return fetch(k) end }).z
+		return { a, b, c, d, e, select("#", fetch()) }
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(1), "x", int64(2), "y", "z", int64(0)}, value)
+
+	// 没有返回值的函数与脚本在顶层调用 coroutine.yield
+	result, err := eng.CallFunctionMulti(ctx, "probe")
+	assert.Nil(t, err)
+	assert.Empty(t, result.Values)
+	_, err = eng.ExecuteString(ctx, `coroutine.yield(1)`)
+	assert.ErrorContains(t, err, "can not yield from outside of a coroutine")
+}
+
+type greeter struct {
+	Prefix string
+}
+
+func (g *greeter) Greet(prefix, name string) string {
+	return prefix + name
+}
+
+func TestRegisterGoFunction(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	type User struct {
+		Name string
+		Age  int
+	}
+
+	assert.Nil(t, eng.RegisterFunction("add", func(a, b int) int { return a + b }))
+	assert.Nil(t, eng.RegisterFunction("sum", func(base float64, values ...int) float64 {
+		for _, v := range values {
+			base += float64(v)
+		}
+		return base
+	}))
+	assert.Nil(t, eng.RegisterFunction("greet", func(u User) (string, error) {
+		if u.Name == "" {
+			return "", errors.New("name is required")
+		}
+		return fmt.Sprintf("%s is %d", u.Name, u.Age), nil
+	}))
+	assert.NotNil(t, eng.RegisterFunction("bad", 42))
+
+	value, err := eng.ExecuteString(ctx, `return add(1, 2)`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, value)
+
+	value, err = eng.ExecuteString(ctx, `return sum(0.5, 1, 2, 3)`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 6.5, value)
+
+	value, err = eng.ExecuteString(ctx, `return sum(0.5)`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0.5, value)
+
+	value, err = eng.ExecuteString(ctx, `return greet({Name = "tom", Age = 18})`)
+	assert.Nil(t, err)
+	assert.Equal(t, "tom is 18", value)
+
+	// 返回的 error 以 Lua 错误的形式抛出
+	value, err = eng.ExecuteString(ctx, `
+		local ok, msg = pcall(greet, {})
+		return tostring(msg)
+	`)
+	assert.Nil(t, err)
+	assert.Contains(t, value, "name is required")
+
+	_, err = eng.ExecuteString(ctx, `return add("x", 1)`)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "bad argument #1")
+
+	assert.Nil(t, eng.RegisterModule("mathx", map[string]any{
+		"pi":  3.14,
+		"mul": func(a, b float64) float64 { return a * b },
+	}))
+	value, err = eng.ExecuteString(ctx, `
+		local mathx = require("mathx")
+		return mathx.mul(mathx.pi, 2)
+	`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 6.28, value)
+
+	// struct 的导出字段与方法作为模块成员
+	assert.Nil(t, eng.RegisterModule("greeter", &greeter{Prefix: "hello "}))
+	value, err = eng.ExecuteString(ctx, `
+		local greeter = require("greeter")
+		return greeter.Greet(greeter.Prefix .. "and ", "tom")
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello and tom", value)
+
+	value, err = eng.ExecuteString(ctx, `return type(mathx)`)
+	assert.Nil(t, err)
+	assert.Equal(t, "nil", value)
+}
+
+func TestConvertGoValues(t *testing.T) {
+	ctx := context.Background()
+
+	type Address struct {
+		City string `json:"city"`
+	}
+	type Person struct {
+		Address
+		Name     string            `json:"name"`
+		Tags     []string          `json:"tags"`
+		Scores   map[string]int    `json:"scores"`
+		Born     time.Time         `json:"born"`
+		Timeout  time.Duration     `json:"timeout"`
+		Manager  *Person           `json:"manager"`
+		Secret   string            `json:"-"`
+		Ids      [2]int            `json:"ids"`
+		Metadata map[int]any       `json:"metadata"`
+		Extra    map[string]string `json:"extra,omitempty"`
+	}
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	_, err = eng.ExecuteString(ctx, `
+		function describe(p)
+			return string.format("%s/%s/%s/%d/%d/%d/%s/%s/%s/%d",
+				p.name, p.city, p.tags[2], p.scores.math, p.born, p.timeout,
+				p.manager.name, tostring(p.Secret), p.metadata[1], p.ids[2])
+		end
+		function echo(p)
+			return p
+		end
+	`)
+	assert.Nil(t, err)
+
+	born := time.Date(2000, 1, 2, 3, 4, 5, 0, time.UTC)
+	p := &Person{
+		Address:  Address{City: "paris"},
+		Name:     "tom",
+		Tags:     []string{"a", "b"},
+		Scores:   map[string]int{"math": 90},
+		Born:     born,
+		Timeout:  3 * time.Second,
+		Manager:  &Person{Name: "bob"},
+		Secret:   "hidden",
+		Ids:      [2]int{1, 2},
+		Metadata: map[int]any{1: "one"},
+	}
+	value, err := eng.CallFunction(ctx, "describe", p)
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("tom/paris/b/90/%d/3/bob/nil/one/2", born.Unix()), value)
+
+	// 循环引用与不支持的类型返回明确的错误
+	p.Manager = p
+	_, err = eng.CallFunction(ctx, "describe", p)
+	assert.True(t, errors.Is(err, ErrLuaUnsupportedType))
+
+	_, err = eng.CallFunction(ctx, "echo", make(chan int))
+	assert.True(t, errors.Is(err, ErrLuaUnsupportedType))
+
+	// 全局变量同样按 StructMode 转换，无法转换时返回错误
+	assert.True(t, errors.Is(eng.RegisterGlobal("events", make(chan int)), ErrLuaUnsupportedType))
+	assert.True(t, errors.Is(eng.RegisterGlobal("person", p), ErrLuaUnsupportedType))
+	p.Manager = &Person{Name: "bob"}
+	assert.Nil(t, eng.RegisterGlobal("person", p))
+	value, err = eng.ExecuteString(ctx, `return type(person) .. ":" .. person.manager.name`)
+	assert.Nil(t, err)
+	assert.Equal(t, "table:bob", value)
+
+	// Go 函数的 time.Time、time.Duration 参数可以接收时间戳与秒数
+	assert.Nil(t, eng.RegisterFunction("later", func(t time.Time, d time.Duration) time.Time {
+		return t.Add(d)
+	}))
+	value, err = eng.ExecuteString(ctx, fmt.Sprintf(`return later(%d, 60)`, born.Unix()))
+	assert.Nil(t, err)
+	assert.EqualValues(t, born.Add(time.Minute).Unix(), value)
+
+	// table 参数按 scriptEngine.Decode 的规则解码：script 标签优先于 json 标签，失败时返回带路径的错误
+	type Order struct {
+		ID    string `script:"id" json:"order_id"`
+		Count int    `json:"count"`
+	}
+	assert.Nil(t, eng.RegisterFunction("order", func(o Order) string {
+		return fmt.Sprintf("%s/%d", o.ID, o.Count)
+	}))
+	value, err = eng.ExecuteString(ctx, `return order({ id = "a1", order_id = "ignored", count = 2 })`)
+	assert.Nil(t, err)
+	assert.Equal(t, "a1/2", value)
+
+	_, err = eng.ExecuteString(ctx, `return order({ id = "a1", count = 1.5 })`)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "decode count: number 1.5 overflows int")
+
+	// StructAsUserData 模式下 struct 绑定为 userdata
+	udEng, err := newLuaEngine(scriptEngine.WithStructMode(scriptEngine.StructAsUserData))
+	assert.Nil(t, err)
+	assert.Nil(t, udEng.Init(ctx))
+	defer udEng.Close()
+
+	_, err = udEng.ExecuteString(ctx, `
+		function rename(p)
+			p.Name = "jerry"
+			return type(p)
+		end
+	`)
+	assert.Nil(t, err)
+	p.Manager = nil
+	value, err = udEng.CallFunction(ctx, "rename", p)
+	assert.Nil(t, err)
+	assert.Equal(t, "userdata", value)
+	assert.Equal(t, "jerry", p.Name)
+
+	assert.Nil(t, udEng.RegisterGlobal("person", p))
+	value, err = udEng.ExecuteString(ctx, `return rename(person)`)
+	assert.Nil(t, err)
+	assert.Equal(t, "userdata", value)
+}
+
+func TestConvertLuaValues(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	// 宿主函数收到的 Lua 函数可以在调用期间直接调用
+	assert.Nil(t, eng.RegisterFunction("each", func(items []any, fn Function) (int, error) {
+		total := 0
+		for _, item := range items {
+			results, err := fn(ctx, item)
+			if err != nil {
+				return 0, err
+			}
+			total += int(results[0].(int64))
+		}
+		return total, nil
+	}))
+
+	_, err = eng.ExecuteString(ctx, `
+		function double(x) return x * 2, "doubled" end
+		function adder(n) return function(x) return x + n end end
+		mixed = {1, 2, name = "tom"}
+		cyclic = {name = "loop"}
+		cyclic.self = cyclic
+		list = {10, 20}
+		shared = {a = list, b = list}
+	`)
+	assert.Nil(t, err)
+
+	value, err := eng.ExecuteString(ctx, `return each({1, 2, 3}, double)`)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 12, value)
+
+	// 全局函数与返回的闭包转换为可调用的 Go 函数
+	global, err := eng.GetGlobal("double")
+	assert.Nil(t, err)
+	double, ok := global.(func(context.Context, ...any) ([]any, error))
+	assert.True(t, ok)
+	results, err := double(ctx, 21)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(42), "doubled"}, results)
+
+	value, err = eng.CallFunction(ctx, "adder", 10)
+	assert.Nil(t, err)
+	results, err = value.(Function)(ctx, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(15)}, results)
+
+	// 混合 table 转换为 map，数字键以字符串形式保留
+	mixed, err := eng.GetGlobal("mixed")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]any{"1": int64(1), "2": int64(2), "name": "tom"}, mixed)
+
+	// 循环引用的 table 转换为引用自身的 map
+	cyclic, err := eng.GetGlobal("cyclic")
+	assert.Nil(t, err)
+	m := cyclic.(map[string]any)
+	assert.Equal(t, "loop", m["name"])
+	assert.Equal(t, reflect.ValueOf(m).Pointer(), reflect.ValueOf(m["self"]).Pointer())
+
+	shared, err := eng.GetGlobal("shared")
+	assert.Nil(t, err)
+	s := shared.(map[string]any)
+	assert.Equal(t, []any{int64(10), int64(20)}, s["a"])
+	assert.Equal(t, reflect.ValueOf(s["a"]).Pointer(), reflect.ValueOf(s["b"]).Pointer())
+
+	// 引擎关闭后调用返回错误
+	assert.Nil(t, eng.Close())
+	_, err = double(ctx, 1)
+	assert.True(t, errors.Is(err, scriptEngine.ErrNotInitialized))
+	assert.Nil(t, eng.Init(ctx))
+}
+
+func TestDecodeHelpers(t *testing.T) {
+	ctx := context.Background()
+
+	type Item struct {
+		Name  string `script:"name"`
+		Count int    `json:"count"`
+	}
+	type Order struct {
+		ID    int64          `json:"id"`
+		Items []Item         `json:"items"`
+		Tags  map[string]int `json:"tags"`
+		Note  *string        `json:"note"`
+	}
+
+	const source = `
+		order = {id = 7, items = {{name = "apple", count = 2}, {name = "pear", count = 3}}, tags = {vip = 1}, note = "fast"}
+		broken = {id = 1, items = {{name = "apple", count = "many"}}}
+		function total(o)
+			local n = 0
+			for _, item in ipairs(o.items) do n = n + item.count end
+			return {id = o.id, items = o.items, tags = {total = n}}
+		end
+	`
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	pool, err := scriptEngine.NewEnginePool(1, scriptEngine.LuaType)
+	assert.Nil(t, err)
+	defer pool.Close()
+
+	autoPool, err := scriptEngine.NewAutoGrowEnginePool(1, 1, scriptEngine.LuaType)
+	assert.Nil(t, err)
+	defer autoPool.Close()
+
+	for _, target := range []interface {
+		scriptEngine.GlobalGetter
+		scriptEngine.FunctionCaller
+		ExecuteString(ctx context.Context, source string) (any, error)
+	}{eng, pool, autoPool} {
+		_, err = target.ExecuteString(ctx, source)
+		assert.Nil(t, err)
+
+		order, err := scriptEngine.GetGlobalAs[Order](target, "order")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(7), order.ID)
+		assert.Equal(t, []Item{{"apple", 2}, {"pear", 3}}, order.Items)
+		assert.Equal(t, map[string]int{"vip": 1}, order.Tags)
+		assert.Equal(t, "fast", *order.Note)
+
+		summary, err := scriptEngine.CallAs[Order](ctx, target, "total", order)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, summary.Tags["total"])
+		assert.Equal(t, order.Items, summary.Items)
+
+		var items []Item
+		assert.Nil(t, scriptEngine.GetGlobalInto(target, "order", &struct {
+			Items *[]Item `json:"items"`
+		}{Items: &items}))
+		assert.Len(t, items, 2)
+
+		// 解码错误指出出错字段的路径
+		_, err = scriptEngine.GetGlobalAs[Order](target, "broken")
+		var decodeErr *scriptEngine.DecodeError
+		assert.True(t, errors.As(err, &decodeErr))
+		assert.Equal(t, "items[0].count", decodeErr.Path)
+	}
+
+	// GetLuaTableToStruct 解码到 out 指向的 struct
+	var header struct{ ID int }
+	assert.Nil(t, eng.vm.GetLuaTableToStruct("order", &header))
+	assert.Equal(t, 7, header.ID)
+}
+
+func TestBindFunction(t *testing.T) {
+	ctx := context.Background()
+
+	type Order struct {
+		Price    float64 `json:"price"`
+		Quantity int     `json:"quantity"`
+	}
+	type Price struct {
+		Total    float64 `json:"total"`
+		Currency string  `json:"currency"`
+	}
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	_, err = eng.ExecuteString(ctx, `
+		function calcPrice(order)
+			if order.quantity < 0 then return nil, "negative quantity" end
+			return {total = order.price * order.quantity, currency = "CNY"}
+		end
+		function divmod(a, b)
+			if b == 0 then return nil, "division by zero" end
+			return math.floor(a / b), a % b
+		end
+		function sum(...)
+			local n = 0
+			for _, v in ipairs({...}) do n = n + v end
+			return n
+		end
+		notAFunction = 1
+	`)
+	assert.Nil(t, err)
+
+	var calcPrice func(ctx context.Context, order Order) (Price, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "calcPrice", &calcPrice))
+	for i := 1; i <= 3; i++ {
+		price, err := calcPrice(ctx, Order{Price: 2.5, Quantity: i})
+		assert.Nil(t, err)
+		assert.Equal(t, Price{Total: 2.5 * float64(i), Currency: "CNY"}, price)
+	}
+	price, err := calcPrice(ctx, Order{Price: 2.5, Quantity: -1})
+	assert.EqualError(t, err, "negative quantity")
+	assert.Equal(t, Price{}, price)
+
+	// 多个返回值依次解码，按惯例返回的错误作为调用的错误
+	var divmod func(a, b int) (int, int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "divmod", &divmod))
+	q, r, err := divmod(7, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, q)
+	assert.Equal(t, 1, r)
+	_, _, err = divmod(1, 0)
+	assert.EqualError(t, err, "division by zero")
+
+	var sum func(values ...int) (int, error)
+	assert.Nil(t, scriptEngine.BindFunction(eng, "sum", &sum))
+	n, err := sum(1, 2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, n)
+
+	// 绑定时检查误用
+	var tooMany func(ctx context.Context, order Order, extra int) (Price, error)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "calcPrice", &tooMany), scriptEngine.ErrArityMismatch)
+	var tooFew func() (Price, error)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "calcPrice", &tooFew), scriptEngine.ErrArityMismatch)
+	var variadic func(values ...int) (int, error)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "calcPrice", &variadic), scriptEngine.ErrArityMismatch)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "notAFunction", &calcPrice), scriptEngine.ErrNotAFunction)
+	assert.ErrorIs(t, scriptEngine.BindFunction(eng, "missing", &calcPrice), scriptEngine.ErrFunctionNotFound)
+	var noError func(order Order) Price
+	assert.NotNil(t, scriptEngine.BindFunction(eng, "calcPrice", &noError))
+	assert.NotNil(t, scriptEngine.BindFunction(eng, "calcPrice", calcPrice))
+
+	// 引擎池中每次调用获取一个引擎
+	pool, err := scriptEngine.NewEnginePool(1, scriptEngine.LuaType)
+	assert.Nil(t, err)
+	defer pool.Close()
+	_, err = pool.ExecuteString(ctx, `function double(x) return x * 2 end`)
+	assert.Nil(t, err)
+
+	var double func(x float64) (float64, error)
+	assert.Nil(t, scriptEngine.BindFunction(pool, "double", &double))
+	v, err := double(1.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, v)
+}
+
+type account struct {
+	UserID      int64 `json:"userId"`
+	DisplayName string
+	HTTPPort    int
+	Secret      string `json:"-"`
+}
+
+func (a account) Greeting() string {
+	return "hi " + a.DisplayName
+}
+
+func TestFieldNaming(t *testing.T) {
+	ctx := context.Background()
+	acc := account{UserID: 42, DisplayName: "alice", HTTPPort: 8080, Secret: "s3cret"}
+
+	for _, tc := range []struct {
+		naming                    scriptEngine.FieldNaming
+		id, display, port, method string
+	}{
+		{scriptEngine.FieldNamingGo, "UserID", "DisplayName", "HTTPPort", "Greeting"},
+		{scriptEngine.FieldNamingJSON, "userId", "DisplayName", "HTTPPort", "Greeting"},
+		{scriptEngine.FieldNamingLowerCamel, "userID", "displayName", "httpPort", "greeting"},
+		{scriptEngine.FieldNamingSnakeCase, "user_id", "display_name", "http_port", "greeting"},
+	} {
+		for _, mode := range []scriptEngine.StructMode{scriptEngine.StructAsTable, scriptEngine.StructAsUserData} {
+			eng, err := newLuaEngine(scriptEngine.WithFieldNaming(tc.naming), scriptEngine.WithStructMode(mode))
+			assert.Nil(t, err)
+			assert.Nil(t, eng.Init(ctx))
+
+			// 全局变量
+			assert.Nil(t, eng.RegisterGlobal("account", acc))
+			result, err := eng.ExecuteString(ctx, fmt.Sprintf(`return account.%s .. ":" .. account.%s .. ":" .. account.%s .. ":" .. tostring(account.Secret)`,
+				tc.id, tc.display, tc.port))
+			assert.Nil(t, err)
+			assert.Equal(t, "42:alice:8080:nil", result, tc.naming)
+
+			// 函数参数与返回值
+			assert.Nil(t, eng.RegisterFunction("loadAccount", func() account { return acc }))
+			assert.Nil(t, eng.RegisterFunction("saveAccount", func(a account) string { return a.DisplayName }))
+			_, err = eng.ExecuteString(ctx, fmt.Sprintf(`
+				function displayOf(a) return a.%[2]s end
+				function roundTrip() return saveAccount({%[1]s = 1, %[2]s = "bob"}) end
+				function loaded() return loadAccount().%[1]s end
+			`, tc.id, tc.display))
+			assert.Nil(t, err)
+
+			result, err = eng.CallFunction(ctx, "displayOf", acc)
+			assert.Nil(t, err)
+			assert.Equal(t, "alice", result, tc.naming)
+			result, err = eng.CallFunction(ctx, "roundTrip")
+			assert.Nil(t, err)
+			assert.Equal(t, "bob", result, tc.naming)
+			result, err = eng.CallFunction(ctx, "loaded")
+			assert.Nil(t, err)
+			assert.Equal(t, int64(42), result, tc.naming)
+
+			// 模块与 userdata 的方法
+			assert.Nil(t, eng.RegisterModule("acct", acc))
+			result, err = eng.ExecuteString(ctx, fmt.Sprintf(`return require("acct").%s()`, tc.method))
+			assert.Nil(t, err)
+			assert.Equal(t, "hi alice", result, tc.naming)
+			if mode == scriptEngine.StructAsUserData {
+				result, err = eng.ExecuteString(ctx, fmt.Sprintf(`return account:%s()`, tc.method))
+				assert.Nil(t, err)
+				assert.Equal(t, "hi alice", result, tc.naming)
+			}
+
+			assert.Nil(t, eng.Close())
+		}
+	}
+}
+
+func TestInt64Mode(t *testing.T) {
+	ctx := context.Background()
+	const id = int64(1<<62 + 1)
+
+	type Order struct {
+		ID    int64  `json:"id"`
+		Owner uint64 `json:"owner"`
+	}
+
+	// 默认转换为数字，超过 2^53 的整数丢失精度
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	_, err = eng.ExecuteString(ctx, `function echo(v) return v end`)
+	assert.Nil(t, err)
+	result, err := eng.CallFunction(ctx, "echo", id)
+	assert.Nil(t, err)
+	assert.NotEqual(t, id, result)
+	assert.Nil(t, eng.Close())
+
+	// int64 userdata
+	eng, err = newLuaEngine(scriptEngine.WithInt64Mode(scriptEngine.Int64AsObject))
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterGlobal("order", Order{ID: id, Owner: math.MaxUint64}))
+	assert.Nil(t, eng.RegisterFunction("lookup", func(id int64) string { return fmt.Sprint("order-", id) }))
+	_, err = eng.ExecuteString(ctx, `
+		function echo(v) return v end
+		function next(v) return v + 1 end
+		function describe()
+			return tostring(order.id) .. "/" .. tostring(order.owner) .. "/" .. ("id=" .. order.id)
+		end
+		function compare(v)
+			return v == int64("4611686018427387905"), v < int64(0), v > int64(1), -v < int64(0)
+		end
+	`)
+	assert.Nil(t, err)
+
+	result, err = eng.CallFunction(ctx, "echo", id)
+	assert.Nil(t, err)
+	assert.Equal(t, id, result)
+
+	result, err = eng.CallFunction(ctx, "next", id)
+	assert.Nil(t, err)
+	assert.Equal(t, id+1, result)
+
+	// int、uint 与 uintptr 同样按 64 位整数转换
+	n, u := id, uint64(math.MaxUint64-1)
+	result, err = eng.CallFunction(ctx, "echo", int(n))
+	assert.Nil(t, err)
+	assert.Equal(t, id, result)
+	result, err = eng.CallFunction(ctx, "next", uint(u))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), result)
+	result, err = eng.CallFunction(ctx, "echo", []uintptr{uintptr(u)})
+	assert.Nil(t, err)
+	assert.Equal(t, []any{u}, result)
+
+	result, err = eng.CallFunction(ctx, "describe")
+	assert.Nil(t, err)
+	assert.Equal(t, "4611686018427387905/18446744073709551615/id=4611686018427387905", result)
+
+	res, err := eng.CallFunctionMulti(ctx, "compare", id)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{true, false, true, true}, res.Values)
+
+	result, err = eng.ExecuteString(ctx, `return lookup((int64("4611686018427387905") - 5) / 2 * 2 + 5)`)
+	assert.Nil(t, err)
+	assert.Equal(t, "order-4611686018427387905", result)
+
+	order, err := scriptEngine.GetGlobalAs[Order](eng, "order")
+	assert.Nil(t, err)
+	assert.Equal(t, Order{ID: id, Owner: math.MaxUint64}, order)
+
+	_, err = eng.ExecuteString(ctx, `return int64(1) / 0`)
+	assert.ErrorContains(t, err, "integer divide by zero")
+
+	// 十进制字符串
+	str, err := newLuaEngine(scriptEngine.WithInt64Mode(scriptEngine.Int64AsString))
+	assert.Nil(t, err)
+	assert.Nil(t, str.Init(ctx))
+	defer str.Close()
+
+	assert.Nil(t, str.RegisterFunction("lookup", func(id int64) string { return fmt.Sprint("order-", id) }))
+	_, err = str.ExecuteString(ctx, `function echo(v) return type(v), lookup(v), {id = v} end`)
+	assert.Nil(t, err)
+
+	res, err = str.CallFunctionMulti(ctx, "echo", id)
+	assert.Nil(t, err)
+	assert.Equal(t, "string", res.Values[0])
+	assert.Equal(t, "order-4611686018427387905", res.Values[1])
+
+	var decoded Order
+	assert.Nil(t, scriptEngine.Decode(res.Values[2], &decoded))
+	assert.Equal(t, id, decoded.ID)
+
+	res, err = str.CallFunctionMulti(ctx, "echo", int(n))
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"string", "order-4611686018427387905"}, res.Values[:2])
+
+	// 单次执行的全局变量同样按 Int64Mode 转换
+	result, err = str.ExecuteStringWithOptions(ctx, `return lookup(orderID) .. "/" .. type(order.owner)`, scriptEngine.ExecuteOptions{
+		Globals: map[string]any{"orderID": id, "order": Order{ID: id, Owner: math.MaxUint64}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "order-4611686018427387905/string", result)
+
+	_, err = str.ExecuteStringWithOptions(ctx, `return 1`, scriptEngine.ExecuteOptions{
+		Globals: map[string]any{"events": make(chan int)},
+	})
+	assert.True(t, errors.Is(err, ErrLuaUnsupportedType))
+}
+
+func TestBinaryData(t *testing.T) {
+	ctx := context.Background()
+	data := []byte{0x00, 0xff, 'h', 'i'}
+
+	type Packet struct {
+		Payload []byte `json:"payload"`
+	}
+
+	// 默认转换为字符串
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	assert.Nil(t, eng.RegisterGlobal("blob", data))
+	assert.Nil(t, eng.RegisterFunction("checksum", func(b []byte) int {
+		sum := 0
+		for _, c := range b {
+			sum += int(c)
+		}
+		return sum
+	}))
+	_, err = eng.ExecuteString(ctx, `
+		function blobInfo() return #blob, string.byte(blob, 2), checksum(blob) end
+		function reverseBytes(s) return s:reverse() end
+		function makePacket() return { payload = "\1\2\3" } end
+	`)
+	assert.Nil(t, err)
+
+	res, err := eng.CallFunctionMulti(ctx, "blobInfo")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(4), int64(0xff), int64(0x00 + 0xff + 'h' + 'i')}, res.Values)
+
+	reversed, err := scriptEngine.CallAs[[]byte](ctx, eng, "reverseBytes", data)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'i', 'h', 0xff, 0x00}, reversed)
+
+	packet, err := scriptEngine.CallAs[Packet](ctx, eng, "makePacket")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, packet.Payload)
+
+	// 嵌套在全局变量中的 []byte 同样按 BytesMode 转换
+	assert.Nil(t, eng.RegisterGlobal("cfg", map[string]any{"payload": []byte("x"), "packet": Packet{Payload: data}}))
+	result, err := eng.ExecuteString(ctx, `return type(cfg.payload) .. ":" .. cfg.payload .. ":" .. #cfg.packet.payload`)
+	assert.Nil(t, err)
+	assert.Equal(t, "string:x:4", result)
+	assert.Nil(t, eng.Close())
+
+	// buffer userdata 与 []byte 共享数据
+	eng, err = newLuaEngine(scriptEngine.WithBytesMode(scriptEngine.BytesAsBuffer))
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	shared := []byte("abc")
+	assert.Nil(t, eng.RegisterFunction("getPacket", func() Packet { return Packet{Payload: shared} }))
+	assert.Nil(t, eng.RegisterFunction("upper", func(b []byte) []byte { return bytes.ToUpper(b) }))
+	_, err = eng.ExecuteString(ctx, `
+		function patch()
+			local p = getPacket().payload
+			p:set(1, string.byte("x"))
+			return #p, p:get(1), p:get(9), p:sub(2), tostring(p)
+		end
+		function build()
+			local b = buffer("he")
+			b:append("ll", buffer("o"))
+			return upper(b), b == buffer("hello"), b .. "!"
+		end
+	`)
+	assert.Nil(t, err)
+
+	res, err = eng.CallFunctionMulti(ctx, "patch")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{int64(3), int64('x'), nil, "bc", "xbc"}, res.Values)
+	assert.Equal(t, []byte("xbc"), shared)
+
+	res, err = eng.CallFunctionMulti(ctx, "build")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{[]byte("HELLO"), true, "hello!"}, res.Values)
+
+	_, err = eng.ExecuteString(ctx, `getPacket().payload:set(1, 256)`)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, eng.RegisterGlobal("cfg", map[string]any{"payload": shared}))
+	result, err = eng.ExecuteString(ctx, `cfg.payload:set(2, string.byte("y")) return type(cfg.payload)`)
+	assert.Nil(t, err)
+	assert.Equal(t, "userdata", result)
+	assert.Equal(t, []byte("xyc"), shared)
+}
+
+func TestHostContext(t *testing.T) {
+	type traceKey struct{}
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterFunction("whoami", func(ctx context.Context, prefix string) string {
+		return fmt.Sprint(prefix, ctx.Value(traceKey{}), "/", ctx.Value("tenant"))
+	}))
+	assert.Nil(t, eng.RegisterFunction("hasDeadline", func(ctx context.Context) bool {
+		_, ok := ctx.Deadline()
+		return ok
+	}))
+	_, err = eng.ExecuteString(ctx, `
+		function handle()
+			return whoami("id:"), ctx.value("tenant"), ctx:value("tenant"), hasDeadline(), ctx.deadline() ~= nil, ctx.err()
+		end
+	`)
+	assert.Nil(t, err)
+
+	opts := scriptEngine.ExecuteOptions{Values: map[string]any{"tenant": "acme"}}
+	result, err := eng.CallFunctionWithOptions(ctx, "handle", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "id:trace-1/acme", result)
+
+	// 可取消的 ctx 绑定到虚拟机，宿主函数同样能读到截止时间与 Values
+	opts.Timeout = time.Second
+	_, err = eng.ExecuteStringWithOptions(ctx, `
+		local a, b, c, d, e, f = handle()
+		assert(a == "id:trace-1/acme" and b == "acme" and c == "acme" and d and e and f == nil)
+	`, opts)
+	assert.Nil(t, err)
+
+	// ctx.deadline() 返回截止时间的 Unix 毫秒数
+	deadline := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	deadlineCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	result, err = eng.ExecuteString(deadlineCtx, `return ctx.deadline()`)
+	assert.Nil(t, err)
+	assert.Equal(t, deadline.UnixMilli(), result)
+
+	// 执行结束后不再能读到本次执行的值
+	_, err = eng.ExecuteString(context.Background(), `assert(ctx.value("tenant") == nil and whoami("") == "<nil>/<nil>")`)
+	assert.Nil(t, err)
+}
+
+// notFoundError 带错误码的宿主错误
+type notFoundError struct {
+	key string
+}
+
+func (e *notFoundError) Error() string     { return e.key + " not found" }
+func (e *notFoundError) ErrorCode() string { return "NOT_FOUND" }
+
+func TestErrorPropagation(t *testing.T) {
+	ctx := context.Background()
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterFunction("lookup", func(key string) (string, error) {
+		return "", fmt.Errorf("lookup failed: %w", &notFoundError{key: key})
+	}))
+	_, err = eng.ExecuteString(ctx, `
+		function catchLookup()
+			local ok, err = pcall(lookup, "user")
+			return ok, err.code, err.message, err.cause.message, "error: " .. err
+		end
+		function rethrowLookup()
+			local _, err = pcall(lookup, "user")
+			error({ code = "WRAPPED", message = "wrapped: " .. tostring(err), cause = err })
+		end
+		function throwTable()
+			error({ code = 42, message = "bad input", field = "name" })
+		end
+		function returnLookup()
+			local _, err = pcall(lookup, "user")
+			return nil, err
+		end
+		function returnTable()
+			return nil, { code = "INVALID", message = "bad input" }
+		end
+	`)
+	assert.Nil(t, err)
+
+	// Go 错误在脚本中可以被 pcall 捕获，并保留错误码与被包装的错误
+	res, err := eng.CallFunctionMulti(ctx, "catchLookup")
+	assert.Nil(t, err)
+	assert.Equal(t, []any{false, "NOT_FOUND", "lookup failed: user not found", "user not found", "error: lookup failed: user not found"}, res.Values)
+
+	var (
+		se       *scriptEngine.ScriptError
+		thrown   *scriptEngine.ThrownError
+		notFound *notFoundError
+	)
+
+	// 未捕获的 Go 错误原样返回
+	_, err = eng.ExecuteString(ctx, `lookup("order")`)
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindHost, se.Kind)
+	assert.Equal(t, "lookup failed: order not found", se.Message)
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "order", notFound.key)
+
+	// 经过脚本包装后仍可取回原始错误
+	_, err = eng.CallFunction(ctx, "rethrowLookup")
+	assert.True(t, errors.As(err, &se))
+	assert.Equal(t, scriptEngine.ErrorKindRuntime, se.Kind)
+	assert.Equal(t, "wrapped: lookup failed: user not found", se.Message)
+	assert.True(t, errors.As(err, &thrown))
+	assert.Equal(t, "WRAPPED", thrown.Code)
+	assert.Equal(t, "WRAPPED", scriptEngine.ErrorCode(err))
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "user", notFound.key)
+
+	// 脚本抛出的 table 解码为 Go 值
+	_, err = eng.CallFunction(ctx, "throwTable")
+	assert.True(t, errors.As(err, &thrown))
+	assert.Equal(t, "42", thrown.Code)
+	assert.Equal(t, "bad input", thrown.Message)
+	assert.Nil(t, thrown.Cause)
+	var payload struct {
+		Code  int    `json:"code"`
+		Field string `json:"field"`
+	}
+	assert.Nil(t, thrown.Decode(&payload))
+	assert.Equal(t, 42, payload.Code)
+	assert.Equal(t, "name", payload.Field)
+
+	// 按惯例返回的错误对象同样保留消息、错误码与原始错误
+	res, err = eng.CallFunctionMulti(ctx, "returnLookup")
+	assert.Nil(t, err)
+	assert.EqualError(t, res.Error, "lookup failed: user not found")
+	assert.Equal(t, "NOT_FOUND", scriptEngine.ErrorCode(res.Error))
+	assert.True(t, errors.As(res.Error, &notFound))
+	assert.Equal(t, "user", notFound.key)
+
+	res, err = eng.CallFunctionMulti(ctx, "returnTable")
+	assert.Nil(t, err)
+	assert.True(t, errors.As(res.Error, &thrown))
+	assert.Equal(t, "INVALID", thrown.Code)
+	assert.Equal(t, "bad input", thrown.Message)
+
+	// 字符串错误的消息不含位置信息
+	_, err = eng.ExecuteString(ctx, `error("boom")`)
+	assert.True(t, errors.As(err, &thrown))
+	assert.Equal(t, "boom", thrown.Message)
+}
+
+func TestModuleLoader(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"lib/loaderutil.lua":        {Data: []byte(`local helper = require("./loaderhelper") return { greet = function(name) return helper.prefix .. name end }`)},
+		"lib/loaderhelper.lua":      {Data: []byte(`loaderHelperLoads = (loaderHelperLoads or 0) + 1 return { prefix = "hi " }`)},
+		"vendor/loaderjson.lua":     {Data: []byte(`return { name = "json" }`)},
+		"vendor/loaderpkg/init.lua": {Data: []byte(`return { name = "pkg" }`)},
+	}
+
+	eng, err := newLuaEngine(scriptEngine.WithModuleFS(fsys, ".", "vendor"))
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	result, err := eng.ExecuteString(ctx, `
+		local util = require("lib.loaderutil")
+		local helper = require("lib/loaderhelper")
+		return { util.greet("bob"), require("loaderjson").name, require("loaderpkg").name, helper.prefix, loaderHelperLoads }
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"hi bob", "json", "pkg", "hi ", int64(1)}, result)
+
+	_, err = eng.ExecuteString(ctx, `require("loadermissing")`)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no file 'vendor/loadermissing.lua'")
+}
+
+func TestSearchPaths(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"lib/searchlib.lua":       `return { name = "lib" }`,
+		"vendor/searchvendor.lua": `return { name = "vendor" }`,
+		"scripts/searchmain.lua":  `return require("searchlib").name .. "/" .. require("searchvendor").name .. "/" .. GetLuaPath()`,
+	} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644))
+	}
+
+	eng, err := newLuaEngine(
+		scriptEngine.WithWorkDir(dir),
+		scriptEngine.WithScriptRoots("lib", "scripts"),
+		scriptEngine.WithLuaPath("./vendor/?.lua"),
+		scriptEngine.WithLuaCPath("./native/?.so"),
+	)
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+
+	// searchmain.lua 不在 WorkDir 中，在脚本根目录中找到
+	result, err := eng.ExecuteFile(ctx, "searchmain.lua")
+	assert.Nil(t, err)
+	assert.Equal(t, "lib/vendor/"+filepath.Join(dir, "lib"), result)
+
+	result, err = eng.ExecuteString(ctx, `return { package.path, package.cpath }`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{
+		strings.Join([]string{
+			filepath.Join(dir, "lib", "?.lua"), filepath.Join(dir, "lib", "?", "init.lua"),
+			filepath.Join(dir, "scripts", "?.lua"), filepath.Join(dir, "scripts", "?", "init.lua"),
+			filepath.Join(dir, "vendor", "?.lua"),
+		}, ";"),
+		filepath.Join(dir, "native", "?.so"),
+	}, result)
+	assert.Nil(t, eng.Close())
+
+	// 没有脚本根目录时 GetLuaPath 不可用，即使 LState 来自状态池
+	eng, err = newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	result, err = eng.ExecuteString(ctx, `return GetLuaPath == nil`)
+	assert.Nil(t, err)
+	assert.Equal(t, true, result)
+}
+
+func TestNativeModule(t *testing.T) {
+	ctx := context.Background()
+	module := &scriptEngine.NativeModule{
+		Name: "nativestr",
+		Functions: map[string]any{
+			"upper": strings.ToUpper,
+			"repeat": func(ctx context.Context, s string, n int) (string, error) {
+				if n < 0 {
+					return "", errors.New("negative count")
+				}
+				return strings.Repeat(s, n), nil
+			},
+		},
+		Constants: map[string]any{"version": "1.0"},
+		Modules: []*scriptEngine.NativeModule{{
+			Name:      "path",
+			Functions: map[string]any{"join": func(a, b string) string { return a + "/" + b }},
+		}},
+	}
+
+	eng, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, eng.Init(ctx))
+	defer eng.Close()
+
+	assert.Nil(t, eng.RegisterModule(module.Name, module))
+	result, err := eng.ExecuteString(ctx, `
+		local str = require("nativestr")
+		local path = require("nativestr/path")
+		return { str.upper("abc"), str["repeat"]("ab", 2), str.version, str.path.join("a", "b"), str.path == path }
+	`)
+	assert.Nil(t, err)
+	assert.Equal(t, []any{"ABC", "abab", "1.0", "a/b", true}, result)
+
+	_, err = eng.ExecuteString(ctx, `require("nativestr")["repeat"]("ab", -1)`)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "negative count")
+
+	err = eng.RegisterModule("nativebad", &scriptEngine.NativeModule{Name: "nativebad", Functions: map[string]any{"f": 1}})
+	assert.True(t, errors.Is(err, scriptEngine.ErrInvalidModule))
+
+	// Manager 记录的模块注册到已初始化的引擎与之后初始化的引擎
+	manager := scriptEngine.NewManager()
+	defer manager.CloseAll()
+	first, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, manager.Register("first", first))
+	assert.Nil(t, manager.RegisterNativeModule(&scriptEngine.NativeModule{Name: "nativemgr", Constants: map[string]any{"answer": 42}}))
+	assert.Nil(t, manager.InitAll(ctx))
+	second, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, second.Init(ctx))
+	assert.Nil(t, manager.Register("second", second))
+	for _, e := range []scriptEngine.Engine{first, second} {
+		result, err = e.ExecuteString(ctx, `return require("nativemgr").answer`)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(42), result)
+	}
+
+	// 通过 Manager.Init 初始化的引擎注册模块，关闭后重新初始化时再次注册
+	third, err := newLuaEngine()
+	assert.Nil(t, err)
+	assert.Nil(t, manager.Register("third", third))
+	assert.Nil(t, manager.RegisterNativeModule(&scriptEngine.NativeModule{Name: "nativelazy", Constants: map[string]any{"answer": 43}}))
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, manager.Init(ctx, "third"))
+		got, ok := manager.Get("third")
+		assert.True(t, ok)
+		result, err = got.ExecuteString(ctx, `return require("nativelazy").answer`)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(43), result)
+		assert.Nil(t, got.Close())
+	}
+	assert.NotNil(t, manager.Init(ctx, "missing"))
+
+	// 引擎池注册的模块对已借出与按需创建的引擎同样生效
+	pool, err := scriptEngine.NewAutoGrowEnginePool(1, 2, scriptEngine.LuaType)
+	assert.Nil(t, err)
+	defer pool.Close()
+	borrowed, err := pool.Acquire()
+	assert.Nil(t, err)
+	assert.Nil(t, pool.RegisterNativeModule(&scriptEngine.NativeModule{Name: "nativepool", Constants: map[string]any{"answer": 42}}))
+	pool.Release(borrowed)
+	for i := 0; i < 2; i++ {
+		result, err = pool.ExecuteString(ctx, `return require("nativepool").answer`)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(42), result)
+	}
+}
@@ -0,0 +1,138 @@
+package lua
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	Lua "github.com/yuin/gopher-lua"
+
+	scriptEngine "github.com/tx7do/go-scripts"
+)
+
+// setModuleLoader 使 require 从 loader 加载模块，loader 为 nil 时保持 package.path 的查找方式。
+// 在 package.loaders 中 preload 之后插入从 loader 查找模块的 searcher：模块名 a.b 依次在 paths 中查找
+// a/b.lua 与 a/b/init.lua，包含 / 的模块名按路径查找，可以省略 .lua 扩展名。同时包装 require，
+// 将以 ./ 或 ../ 开头的模块名转换为相对于调用方模块所在目录的路径，使同一个模块只加载一次。
+func (e *virtualMachine) setModuleLoader(loader scriptEngine.ModuleLoader, paths []string) {
+	if loader == nil {
+		return
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	L := e.L
+	e.moduleFiles = make(map[string]bool)
+
+	searcher := L.NewFunction(func(L *Lua.LState) int {
+		name := L.CheckString(1)
+		rel := strings.TrimSuffix(name, ".lua")
+		if !strings.Contains(name, "/") {
+			rel = strings.ReplaceAll(name, ".", "/")
+		}
+
+		var tried strings.Builder
+		for _, dir := range paths {
+			for _, file := range []string{path.Join(dir, rel) + ".lua", path.Join(dir, rel, "init.lua")} {
+				if !fs.ValidPath(file) {
+					continue
+				}
+				data, err := loader.LoadModule(file)
+				if errors.Is(err, fs.ErrNotExist) {
+					tried.WriteString("\n\tno file '" + file + "'")
+					continue
+				}
+				if err != nil {
+					L.RaiseError("error loading module '%s' from file '%s':\n\t%s", name, file, err.Error())
+				}
+
+				fn, err := L.Load(bytes.NewReader(data), file)
+				if err != nil {
+					L.RaiseError("%s", err.Error())
+				}
+				e.moduleFiles[file] = true
+				L.Push(fn)
+				return 1
+			}
+		}
+		L.Push(Lua.LString(tried.String()))
+		return 1
+	})
+
+	loaders, ok := L.GetField(L.GetGlobal("package"), "loaders").(*Lua.LTable)
+	if ok {
+		loaders.Insert(2, searcher)
+	}
+
+	require := L.GetGlobal("require")
+	L.SetGlobal("require", L.NewFunction(func(L *Lua.LState) int {
+		name := L.CheckString(1)
+		if strings.HasPrefix(name, "./") || strings.HasPrefix(name, "../") {
+			name = path.Join(e.moduleDir(L), name)
+		}
+		L.Push(require)
+		L.Push(Lua.LString(name))
+		L.Call(1, 1)
+		return 1
+	}))
+}
+
+// moduleDir 返回调用 require 的模块所在的目录，调用方不是从 ModuleLoader 加载的模块时返回根目录
+func (e *virtualMachine) moduleDir(L *Lua.LState) string {
+	dbg, ok := L.GetStack(1)
+	if !ok {
+		return "."
+	}
+	if _, err := L.GetInfo("S", dbg, Lua.LNil); err != nil || !e.moduleFiles[dbg.Source] {
+		return "."
+	}
+	return path.Dir(dbg.Source)
+}
+
+// setPackagePath 按 Options 设置 package.path、package.cpath 与 GetLuaPath，使模块的查找与进程的当前目录无关。
+// package.path 依次为各脚本根目录下的 ?.lua 与 ?/init.lua 以及 LuaPath，LuaPath 为空时使用 gopher-lua 的默认值；
+// 其中的相对路径相对于 WorkDir。GetLuaPath 返回第一个脚本根目录，没有脚本根目录时不可用。
+func (e *virtualMachine) setPackagePath(options *scriptEngine.Options) {
+	L := e.L
+	pkg, ok := L.GetGlobal("package").(*Lua.LTable)
+	if !ok {
+		return
+	}
+
+	var templates []string
+	for _, root := range options.ScriptRoots {
+		root = options.ResolvePath(root)
+		templates = append(templates, filepath.Join(root, "?.lua"), filepath.Join(root, "?", "init.lua"))
+	}
+	L.SetField(pkg, "path", Lua.LString(searchPath(options, templates, options.LuaPath, Lua.LVAsString(L.GetField(pkg, "path")))))
+	L.SetField(pkg, "cpath", Lua.LString(searchPath(options, nil, options.LuaCPath, Lua.LVAsString(L.GetField(pkg, "cpath")))))
+
+	// LState 可能来自状态池，没有脚本根目录时清除上一个使用者注册的 GetLuaPath
+	if len(options.ScriptRoots) == 0 {
+		L.SetGlobal("GetLuaPath", Lua.LNil)
+		return
+	}
+	root := Lua.LString(options.ResolvePath(options.ScriptRoots[0]))
+	L.SetGlobal("GetLuaPath", L.NewFunction(func(L *Lua.LState) int {
+		L.Push(root)
+		return 1
+	}))
+}
+
+// searchPath 返回以 ; 分隔的搜索路径：prefix 之后为 paths，paths 为空时为 defaults 中的各项，相对路径相对于 WorkDir
+func searchPath(options *scriptEngine.Options, prefix, paths []string, defaults string) string {
+	if len(paths) == 0 {
+		paths = strings.Split(defaults, ";")
+	}
+	templates := prefix
+	for _, p := range paths {
+		if p != "" {
+			templates = append(templates, options.ResolvePath(p))
+		}
+	}
+	return strings.Join(templates, ";")
+}
@@ -1,6 +1,4 @@
-local my_path = "D:\\GoProject\\go-lua\\script"
-package.path = package.path .. [[;]] .. GetLuaPath() .. [[/?.lua;]]
-package.path = package.path .. [[;]] .. my_path .. [[/?.lua;]]
+-- 模块在引擎的脚本根目录中查找，GetLuaPath 返回第一个脚本根目录
 print(package.path)
 print(GetLuaPath())
 
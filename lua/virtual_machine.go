@@ -1,9 +1,12 @@
 package lua
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync/atomic"
 
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/tengattack/gluacrypto"
@@ -11,6 +14,8 @@ import (
 	"github.com/yuin/gluamapper"
 	Lua "github.com/yuin/gopher-lua"
 	luar "layeh.com/gopher-luar"
+
+	scriptEngine "github.com/tx7do/go-scripts"
 )
 
 type TableMap map[string]interface{}
@@ -18,11 +23,45 @@ type TableMap map[string]interface{}
 type virtualMachine struct {
 	L *Lua.LState
 	F *Lua.LFunction
+
+	// structMode Go struct 转换为 Lua 值的方式
+	structMode scriptEngine.StructMode
+	// fieldNaming Go struct 的字段与方法在 Lua 中的命名方式
+	fieldNaming scriptEngine.FieldNaming
+	// int64Mode int64 与 uint64 在 Lua 中的表示方式
+	int64Mode scriptEngine.Int64Mode
+	// bytesMode []byte 在 Lua 中的表示方式
+	bytesMode scriptEngine.BytesMode
+	// moduleFiles 从 ModuleLoader 加载的模块文件，用于解析相对的模块名
+	moduleFiles map[string]bool
+
+	// maxStack 大于 0 时，call 在调用栈深度不超过该值的协程中执行
+	maxStack int
+
+	// async 注册过异步函数后，call 在由 resume 驱动的协程中执行，调用异步函数的协程让出后等待 Future 完成再恢复
+	async bool
+	// asyncDrive 与 asyncWrap 为 asyncChunk 返回的两个函数
+	asyncDrive *Lua.LFunction
+	asyncWrap  *Lua.LFunction
+
+	// await 等待 Future 完成，为 nil 时直接阻塞等待
+	await func(ctx context.Context, f *Future) error
+	// invoke 在引擎中调用转换为 Go 值的 Lua 函数，为 nil 时直接调用
+	invoke func(ctx context.Context, fn *Lua.LFunction, args []any) ([]any, error)
+	// ctx 正在进行的执行的 context，ctx 不可取消而未绑定到 LState 时，宿主函数与脚本通过它读取 context
+	ctx context.Context
+	// hostActive 正在执行的宿主函数调用，调用期间宿主函数收到的 Lua 函数可以直接调用
+	hostActive *atomic.Bool
+
+	// waiting 正在等待 Future 的执行数，closed 在引擎关闭时关闭
+	waiting int
+	closed  chan struct{}
 }
 
 func newVirtualMachine() *virtualMachine {
 	exec := &virtualMachine{
-		L: luaPool.Borrow(),
+		L:      luaPool.Borrow(),
+		closed: make(chan struct{}),
 	}
 	exec.init()
 	return exec
@@ -37,18 +76,39 @@ func GetRunPath() string {
 func (e *virtualMachine) init() {
 
 	e.L.OpenLibs()
+	openCoroutine(e.L)
 
 	libs.Preload(e.L)
 
 	gluacrypto.Preload(e.L)
 
+	e.registerContext()
+
 	//lua_debugger.Preload(e.L)
+}
 
-	e.RegisterFunction("GetLuaPath", func(vm *Lua.LState) int {
-		// 绝对路径
-		e.L.Push(Lua.LString(GetRunPath() + "/script"))
-		return 1
-	})
+// luarConfigKey gopher-luar 在 registry 中保存配置的键
+const luarConfigKey = "github.com/layeh/gopher-luar"
+
+// setFieldNaming 设置 Go struct 的字段与方法在 Lua 中的命名方式。
+// LState 可能来自状态池，先丢弃上一个使用者留下的 gopher-luar 配置及其缓存的元表。
+func (e *virtualMachine) setFieldNaming(naming scriptEngine.FieldNaming) {
+	e.fieldNaming = naming
+	e.L.Get(Lua.RegistryIndex).(*Lua.LTable).RawSetString(luarConfigKey, Lua.LNil)
+	if naming == scriptEngine.FieldNamingDefault {
+		return
+	}
+
+	config := luar.GetConfig(e.L)
+	config.FieldNames = func(_ reflect.Type, field reflect.StructField) []string {
+		if name, ok := naming.FieldName(field); ok {
+			return []string{name}
+		}
+		return nil
+	}
+	config.MethodNames = func(_ reflect.Type, method reflect.Method) []string {
+		return []string{naming.MethodName(method.Name)}
+	}
 }
 
 // Destroy 销毁虚拟机，为了性能考虑，现在只是将之还给虚拟机池。
@@ -84,35 +144,38 @@ func (e *virtualMachine) LoadFile(filePath string) error {
 	return nil
 }
 
-// Execute 执行已编译的lua代码
-func (e *virtualMachine) Execute() error {
-	if err := e.doCompiledFile(); err != nil {
-		return err
-	}
-	return nil
+// Execute 执行已编译的lua代码，返回代码块的全部返回值
+func (e *virtualMachine) Execute() ([]Lua.LValue, error) {
+	return e.doCompiledFile()
 }
 
-// ExecuteString 直接执行字符串
-func (e *virtualMachine) ExecuteString(source string) error {
-	if err := e.L.DoString(source); err != nil {
-		return err
+// ExecuteString 直接执行字符串，返回代码块的全部返回值
+func (e *virtualMachine) ExecuteString(source string) ([]Lua.LValue, error) {
+	lFunc, err := e.L.LoadString(source)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return e.call(lFunc)
 }
 
-// ExecuteFile 直接执行lua文件
-func (e *virtualMachine) ExecuteFile(filePath string) error {
-	if err := e.L.DoFile(filePath); err != nil {
-		return err
+// ExecuteFile 直接执行lua文件，返回代码块的全部返回值
+func (e *virtualMachine) ExecuteFile(filePath string) ([]Lua.LValue, error) {
+	lFunc, err := e.L.LoadFile(filePath)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return e.call(lFunc)
 }
 
 // CallFunction 调用lua当中的方法
 func (e *virtualMachine) CallFunction(name string, args ...interface{}) {
 	var lArgs []Lua.LValue
 	for _, arg := range args {
-		lArgs = append(lArgs, e.convertToLValue(arg))
+		lv, err := e.convertToLValue(arg)
+		if err != nil {
+			panic(err)
+		}
+		lArgs = append(lArgs, lv)
 	}
 
 	if err := e.L.CallByParam(Lua.P{
@@ -125,9 +188,15 @@ func (e *virtualMachine) CallFunction(name string, args ...interface{}) {
 }
 
 func (e *virtualMachine) PCall(f string, args ...interface{}) {
+	top := e.L.GetTop()
 	e.L.Push(e.L.GetGlobal(f))
 	for _, arg := range args {
-		val := e.convertToLValue(arg)
+		val, err := e.convertToLValue(arg)
+		if err != nil {
+			e.L.SetTop(top)
+			log.Errorf("lua pcall err:%v", err)
+			return
+		}
 		e.L.Push(val)
 	}
 	if err := e.L.PCall(len(args), -1, nil); err != nil {
@@ -160,11 +229,9 @@ func (e *virtualMachine) RegisterFunction(name string, fn Lua.LGFunction) {
 	e.L.SetGlobal(name, e.L.NewFunction(fn))
 }
 
-// RegisterModule 注册一个模块到lua
+// RegisterModule 注册一个模块到lua，mod 为模块的加载函数，脚本通过 require(name) 加载模块
 func (e *virtualMachine) RegisterModule(name string, mod Lua.LGFunction) {
-	e.L.Push(e.L.NewFunction(mod))
-	e.L.Push(Lua.LString(name))
-	e.L.Call(1, 0)
+	e.L.PreloadModule(name, mod)
 }
 
 // BindStruct 绑定一个struct到lua，可以双向操作。
@@ -174,117 +241,118 @@ func (e *virtualMachine) BindStruct(name string, data interface{}) {
 
 // GetLuaTableToStruct 从lua读取一个table到go的struct
 func (e *virtualMachine) GetLuaTableToStruct(name string, out interface{}) error {
-	return gluamapper.Map(e.L.GetGlobal(name).(*Lua.LTable), &out)
+	tbl, ok := e.L.GetGlobal(name).(*Lua.LTable)
+	if !ok {
+		return fmt.Errorf("global %s is not a table", name)
+	}
+	return gluamapper.Map(tbl, out)
 }
 
-// 执行已经编译的字节码
-func (e *virtualMachine) doCompiledFile() error {
-	e.L.Push(e.F)
-	return e.L.PCall(0, Lua.MultRet, nil)
+// 执行已经编译的字节码，没有已加载的代码时返回 ErrLuaNoProgramLoaded
+func (e *virtualMachine) doCompiledFile() ([]Lua.LValue, error) {
+	if e.F == nil {
+		return nil, ErrLuaNoProgramLoaded
+	}
+	return e.call(e.F)
 }
 
-// convertToLValue 将go的值转换为LValue
-func (e *virtualMachine) convertToLValue(val interface{}) Lua.LValue {
-	if val == nil {
-		return Lua.LNil
+// call 以保护模式调用函数，收集全部返回值并恢复栈顶
+func (e *virtualMachine) call(fn Lua.LValue, args ...Lua.LValue) ([]Lua.LValue, error) {
+	if e.async {
+		return e.resume(fn, args)
 	}
-	switch v := val.(type) {
-	case Lua.LValue:
-		return v
-	case bool:
-		return Lua.LBool(v)
-	case float32:
-		return Lua.LNumber(v)
-	case float64:
-		return Lua.LNumber(v)
-	case int:
-		return Lua.LNumber(v)
-	case int8:
-		return Lua.LNumber(v)
-	case int16:
-		return Lua.LNumber(v)
-	case int32:
-		return Lua.LNumber(v)
-	case int64:
-		return Lua.LNumber(v)
-	case uint8:
-		return Lua.LNumber(v)
-	case uint16:
-		return Lua.LNumber(v)
-	case uint32:
-		return Lua.LNumber(v)
-	case uint64:
-		return Lua.LNumber(v)
-	case string:
-		return Lua.LString(v)
-	case []byte:
-		ud := e.L.NewUserData()
-		ud.Value = v
-		return ud
-	case map[string]interface{}:
-		return e.convertToLTable(v)
-	case []interface{}:
-		lt := e.L.NewTable()
-		for k, v := range v {
-			lt.RawSetInt(k+1, e.convertToLValue(v))
+
+	L := e.L
+	if e.maxStack > 0 {
+		L = e.newThread(e.maxStack)
+	}
+
+	top := L.GetTop()
+
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	if err := L.PCall(len(args), Lua.MultRet, nil); err != nil {
+		return nil, err
+	}
+
+	values := make([]Lua.LValue, 0, L.GetTop()-top)
+	for i := top + 1; i <= L.GetTop(); i++ {
+		values = append(values, L.Get(i))
+	}
+	L.SetTop(top)
+
+	return values, nil
+}
+
+// newThread 创建与主状态共享全局环境的协程，其调用栈深度上限为 callStackSize。
+// 若主状态绑定了 context，协程与主状态共用同一个 context。
+func (e *virtualMachine) newThread(callStackSize int) *Lua.LState {
+	options := e.L.Options
+	e.L.Options.CallStackSize = callStackSize
+	ctx := e.L.RemoveContext()
+	defer func() {
+		e.L.Options = options
+		if ctx != nil {
+			e.L.SetContext(ctx)
 		}
-		return lt
-	default:
-		return nil
+	}()
+
+	th, _ := e.L.NewThread()
+	if ctx != nil {
+		th.SetContext(ctx)
 	}
+	return th
 }
 
-// convertFromLValue 将LValue转换为go的值
-func (e *virtualMachine) convertFromLValue(lv Lua.LValue) interface{} {
-	switch v := lv.(type) {
-	case *Lua.LNilType:
-		return nil
-	case *Lua.LUserData:
-		return v.Value
-	case Lua.LBool:
-		return bool(v)
-	case Lua.LString:
-		return string(v)
-	case Lua.LNumber:
-		f64i := float64(v)
-		I64i := int64(v)
-		if f64i == float64(I64i) {
-			return I64i
+// SetGlobals 临时设置一组全局变量，返回恢复原值的函数。值按 convertToLValue 的规则转换，
+// 任一值无法转换时恢复已设置的全局变量并返回错误。
+func (e *virtualMachine) SetGlobals(globals map[string]any) (restore func(), err error) {
+	previous := make(map[string]Lua.LValue, len(globals))
+	restore = func() {
+		for name, value := range previous {
+			e.L.SetGlobal(name, value)
 		}
-		return f64i
-	case *Lua.LTable:
-		maxN := v.MaxN()
-		if maxN == 0 {
-			// table
-			ret := make(map[string]interface{})
-			v.ForEach(func(key, value Lua.LValue) {
-				keyStr := fmt.Sprint(e.convertFromLValue(key))
-				ret[keyStr] = e.convertFromLValue(value)
-			})
-			return ret
-		} else {
-			// array
-			ret := make([]interface{}, 0, maxN)
-			for i := 1; i <= maxN; i++ {
-				ret = append(ret, e.convertFromLValue(v.RawGetInt(i)))
-			}
-			return ret
+	}
+
+	for name, value := range globals {
+		lv, err := e.convertToLValue(value)
+		if err != nil {
+			restore()
+			return nil, fmt.Errorf("global %s: %w", name, err)
 		}
-	default:
-		log.Errorf("error lua type %v", lv)
-		return nil
+		previous[name] = e.L.GetGlobal(name)
+		e.L.SetGlobal(name, lv)
 	}
+	return restore, nil
 }
 
-// convertToLTable 将go的map转换成LTable
-func (e *virtualMachine) convertToLTable(data map[string]interface{}) *Lua.LTable {
-	lt := e.L.NewTable()
-
-	for k, v := range data {
-		lt.RawSetString(k, e.convertToLValue(v))
+// convertToLValue 将go的值转换为LValue，转换规则见 toLValue
+func (e *virtualMachine) convertToLValue(val interface{}) (Lua.LValue, error) {
+	switch v := val.(type) {
+	case nil:
+		return Lua.LNil, nil
+	case Lua.LValue:
+		return v, nil
+	case bool:
+		return Lua.LBool(v), nil
+	case string:
+		return Lua.LString(v), nil
+	case int:
+		return e.int64Value(int64(v)), nil
+	case int64:
+		return e.int64Value(v), nil
+	case float64:
+		return Lua.LNumber(v), nil
+	default:
+		return e.toLValue(reflect.ValueOf(val), make(map[visitKey]bool))
 	}
+}
 
-	return lt
+// convertFromLValue 将LValue转换为go的值，转换规则见 fromLValue
+func (e *virtualMachine) convertFromLValue(lv Lua.LValue) interface{} {
+	return e.fromLValue(lv, make(map[*Lua.LTable]any))
 }
 
 // convertFromLTable 将LTable转换成map。
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	scriptEngine "github.com/tx7do/go-scripts"
 )
 
 type user struct {
@@ -48,7 +50,7 @@ print("hello")`
 	var role Role
 	var menu Menu
 
-	err = exe.Execute()
+	_, err = exe.Execute()
 	assert.Nil(t, err)
 
 	_ = exe.GetLuaTableToStruct("role", &role)
@@ -71,7 +73,7 @@ func TestVirtualMachine_ExecuteFile(t *testing.T) {
 
 	exe.BindStruct("u", u)
 
-	err = exe.Execute()
+	_, err = exe.Execute()
 	assert.Nil(t, err)
 
 	fmt.Println("Lua set your token to:", u.Token())
@@ -84,18 +86,19 @@ func TestVirtualMachine_HttpModule(t *testing.T) {
 	err := exe.LoadFile("./script/test_http.lua")
 	assert.Nil(t, err)
 
-	err = exe.Execute()
+	_, err = exe.Execute()
 	assert.Nil(t, err)
 }
 
 func TestVirtualMachine_LoadModule(t *testing.T) {
 	exe := newVirtualMachine()
 	defer exe.Destroy()
+	exe.setPackagePath(scriptEngine.NewOptions(scriptEngine.WithScriptRoots("./script")))
 
 	err := exe.LoadFile("./script/test_load_module.lua")
 	assert.Nil(t, err)
 
-	err = exe.Execute()
+	_, err = exe.Execute()
 	assert.Nil(t, err)
 }
 
@@ -106,7 +109,7 @@ func TestVirtualMachine_CryptoModule(t *testing.T) {
 	err := exe.LoadFile("./script/test_crypto.lua")
 	assert.Nil(t, err)
 
-	err = exe.Execute()
+	_, err = exe.Execute()
 	assert.Nil(t, err)
 }
 
@@ -117,6 +120,6 @@ func TestVirtualMachine_Debugger(t *testing.T) {
 	err := exe.LoadFile("./script/test_debugger.lua")
 	assert.Nil(t, err)
 
-	err = exe.Execute()
+	_, err = exe.Execute()
 	assert.Nil(t, err)
 }
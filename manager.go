@@ -3,6 +3,7 @@ package script_engine
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -12,6 +13,8 @@ import (
 type Manager struct {
 	mu      sync.RWMutex
 	engines map[string]Engine
+	// modules RegisterNativeModule 注册的模块，通过 Register、Init 与 InitAll 加入或初始化的引擎同样获得这些模块
+	modules []*NativeModule
 	// optional: 记录默认引擎名或全局配置
 	defaultName string
 }
@@ -24,7 +27,8 @@ func NewManager() *Manager {
 }
 
 // Register 注册一个 Engine（不初始化）。
-// 若 name 已存在返回错误。
+// 若 name 已存在返回错误；eng 已初始化时同时注册 RegisterNativeModule 记录的模块，注册失败时返回错误且不注册 eng。
+// 未初始化的 eng 应通过 Init 或 InitAll 初始化，以获得这些模块。
 func (m *Manager) Register(name string, eng Engine) error {
 	if name == "" || eng == nil {
 		return errors.New("invalid name or engine")
@@ -34,10 +38,49 @@ func (m *Manager) Register(name string, eng Engine) error {
 	if _, ok := m.engines[name]; ok {
 		return errors.New("engine already registered")
 	}
+	if eng.IsInitialized() {
+		if err := registerModules(eng, m.modules); err != nil {
+			return err
+		}
+	}
 	m.engines[name] = eng
 	return nil
 }
 
+// RegisterNativeModule 将 module 注册到所有已初始化的引擎，并记录下来，
+// 之后通过 Register、Init 或 InitAll 加入或初始化的引擎同样注册该模块。
+// 注册到某个引擎失败时返回错误，module 仍被记录。
+func (m *Manager) RegisterNativeModule(module *NativeModule) error {
+	if err := module.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modules = append(m.modules, module)
+
+	var errs []error
+	for name, e := range m.engines {
+		if !e.IsInitialized() {
+			continue
+		}
+		if err := e.RegisterModule(module.Name, module); err != nil {
+			errs = append(errs, fmt.Errorf("engine %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// registerModules 将 modules 注册到 eng
+func registerModules(eng Engine, modules []*NativeModule) error {
+	for _, module := range modules {
+		if err := eng.RegisterModule(module.Name, module); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Get 返回已注册的 Engine。
 func (m *Manager) Get(name string) (Engine, bool) {
 	m.mu.RLock()
@@ -46,7 +89,18 @@ func (m *Manager) Get(name string) (Engine, bool) {
 	return eng, ok
 }
 
-// InitAll 对所有已注册引擎执行 Init。
+// Init 对已注册的引擎 name 执行 Init，并注册 RegisterNativeModule 记录的模块。
+func (m *Manager) Init(ctx context.Context, name string) error {
+	m.mu.RLock()
+	eng, ok := m.engines[name]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("engine %s not registered", name)
+	}
+	return m.initEngine(ctx, eng)
+}
+
+// InitAll 对所有已注册引擎执行 Init，并注册 RegisterNativeModule 记录的模块。
 func (m *Manager) InitAll(ctx context.Context) error {
 	m.mu.RLock()
 	list := make([]Engine, 0, len(m.engines))
@@ -56,13 +110,24 @@ func (m *Manager) InitAll(ctx context.Context) error {
 	m.mu.RUnlock()
 
 	for _, e := range list {
-		if err := e.Init(ctx); err != nil {
+		if err := m.initEngine(ctx, e); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// initEngine 初始化 eng 并注册 RegisterNativeModule 记录的模块。
+// 初始化与注册期间持有读锁，并发 RegisterNativeModule 记录的模块要么在此注册，要么由 RegisterNativeModule 注册。
+func (m *Manager) initEngine(ctx context.Context, eng Engine) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := eng.Init(ctx); err != nil {
+		return err
+	}
+	return registerModules(eng, m.modules)
+}
+
 // CloseAll 关闭所有已注册引擎（并忽略单个 Close 错误，返回最后一个错误）。
 func (m *Manager) CloseAll() error {
 	m.mu.Lock()
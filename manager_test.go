@@ -0,0 +1,159 @@
+package script_engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeType 测试用引擎的类型
+const fakeType Type = "fake"
+
+func init() {
+	_ = Register(fakeType, func(...Option) (Engine, error) {
+		return newFakeEngine(), nil
+	})
+}
+
+// fakeEngine 记录生命周期与模块注册的测试用引擎，未实现的方法调用时 panic
+type fakeEngine struct {
+	Engine
+
+	mu          sync.Mutex
+	initialized bool
+	modules     map[string]int // 本次初始化后各模块的注册次数
+	failModule  string         // 注册该模块时返回错误
+}
+
+func newFakeEngine() *fakeEngine {
+	return &fakeEngine{}
+}
+
+func (e *fakeEngine) GetType() Type {
+	return fakeType
+}
+
+func (e *fakeEngine) Init(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.initialized {
+		return ErrAlreadyInitialized
+	}
+	e.initialized = true
+	e.modules = make(map[string]int)
+	return nil
+}
+
+func (e *fakeEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.initialized {
+		return ErrNotInitialized
+	}
+	e.initialized = false
+	e.modules = nil
+	return nil
+}
+
+func (e *fakeEngine) IsInitialized() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.initialized
+}
+
+func (e *fakeEngine) RegisterModule(name string, _ any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.initialized {
+		return ErrNotInitialized
+	}
+	if name == e.failModule {
+		return errors.New("register " + name + " failed")
+	}
+	e.modules[name]++
+	return nil
+}
+
+// registered 返回本次初始化后各模块的注册次数
+func (e *fakeEngine) registered() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result := make(map[string]int, len(e.modules))
+	for name, n := range e.modules {
+		result[name] = n
+	}
+	return result
+}
+
+func testModule(name string) *NativeModule {
+	return &NativeModule{Name: name, Constants: map[string]any{"name": name}}
+}
+
+func TestManagerModules(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager()
+
+	lazy, ready := newFakeEngine(), newFakeEngine()
+	assert.Nil(t, ready.Init(ctx))
+	assert.Nil(t, m.Register("lazy", lazy))
+	assert.Nil(t, m.Register("ready", ready))
+
+	// 只注册到已初始化的引擎
+	assert.Nil(t, m.RegisterNativeModule(testModule("a")))
+	assert.Equal(t, map[string]int{"a": 1}, ready.registered())
+	assert.False(t, lazy.IsInitialized())
+
+	// Get 没有副作用，在 Manager 之外初始化的引擎不会在 Get 时注册模块
+	assert.Nil(t, lazy.Init(ctx))
+	got, ok := m.Get("lazy")
+	assert.True(t, ok)
+	assert.Equal(t, lazy, got)
+	assert.Empty(t, lazy.registered())
+	assert.Nil(t, lazy.Close())
+
+	// Init 初始化并注册全部模块，关闭后重新初始化时再次注册
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, m.Init(ctx, "lazy"))
+		assert.Equal(t, map[string]int{"a": 1}, lazy.registered())
+		assert.Nil(t, lazy.Close())
+	}
+	assert.NotNil(t, m.Init(ctx, "missing"))
+
+	// 已初始化的引擎在 Register 时注册模块，失败时返回错误且不注册引擎
+	late := newFakeEngine()
+	assert.Nil(t, late.Init(ctx))
+	assert.Nil(t, m.Register("late", late))
+	assert.Equal(t, map[string]int{"a": 1}, late.registered())
+
+	failing := newFakeEngine()
+	failing.failModule = "a"
+	assert.Nil(t, failing.Init(ctx))
+	assert.NotNil(t, m.Register("failing", failing))
+	_, ok = m.Get("failing")
+	assert.False(t, ok)
+
+	// RegisterNativeModule 返回注册失败的错误，模块仍被记录
+	late.failModule = "b"
+	assert.NotNil(t, m.RegisterNativeModule(testModule("b")))
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, ready.registered())
+	assert.Nil(t, m.Init(ctx, "lazy"))
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, lazy.registered())
+
+	// InitAll 初始化所有引擎并注册全部模块
+	all := NewManager()
+	engines := []*fakeEngine{newFakeEngine(), newFakeEngine(), newFakeEngine()}
+	for i, e := range engines {
+		assert.Nil(t, all.Register(string(rune('a'+i)), e))
+	}
+	assert.Nil(t, all.RegisterNativeModule(testModule("c")))
+	assert.Nil(t, all.InitAll(ctx))
+	for _, e := range engines {
+		assert.Equal(t, map[string]int{"c": 1}, e.registered())
+	}
+	assert.Nil(t, all.CloseAll())
+	_, ok = all.Get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,100 @@
+package script_engine
+
+import (
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+)
+
+// ModuleLoader 为脚本的 require 加载模块文件，例如从 embed.FS 加载随程序发布的脚本
+type ModuleLoader interface {
+	// LoadModule 返回 path 处模块文件的内容，path 为以 / 分隔的相对路径，例如 lib/util.lua；
+	// 文件不存在或是目录时返回的错误需满足 errors.Is(err, fs.ErrNotExist)
+	LoadModule(path string) ([]byte, error)
+}
+
+// ModuleLoaderFunc 以函数实现 ModuleLoader
+type ModuleLoaderFunc func(path string) ([]byte, error)
+
+func (f ModuleLoaderFunc) LoadModule(path string) ([]byte, error) {
+	return f(path)
+}
+
+// FSModuleLoader 返回从 fsys 加载模块文件的 ModuleLoader
+func FSModuleLoader(fsys fs.FS) ModuleLoader {
+	return ModuleLoaderFunc(func(path string) ([]byte, error) {
+		info, err := fs.Stat(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+		}
+		return fs.ReadFile(fsys, path)
+	})
+}
+
+// NativeModule 与引擎类型无关的 Go 原生模块定义，通过各引擎的 RegisterModule 注册，
+// 或通过 Manager、引擎池的 RegisterNativeModule 一次注册到所有引擎，脚本通过 require(Name) 加载。
+// 函数的参数与返回值按引擎的 Go 函数规则转换：第一个参数为 context.Context 时传入本次执行的 ctx，
+// 最后一个返回值为 error 且不为 nil 时在脚本中抛出错误，与 RegisterFunction 注册的函数一致。
+type NativeModule struct {
+	// Name 模块名
+	Name string
+
+	// Functions 模块的函数，值必须为 Go 函数
+	Functions map[string]any
+
+	// Constants 模块的常量，按引擎的 Go 值转换规则导出
+	Constants map[string]any
+
+	// Modules 子模块，作为模块中与子模块同名的成员，各引擎中也可以通过 require("模块名/子模块名") 加载
+	Modules []*NativeModule
+}
+
+// Validate 检查模块定义：模块名不能为空，子模块名不能包含 . 与 /，函数必须为非 nil 的 Go 函数，
+// 同一模块中的函数、常量与子模块不能重名。返回的错误可通过 errors.Is(err, ErrInvalidModule) 判断。
+func (m *NativeModule) Validate() error {
+	return m.validate("")
+}
+
+func (m *NativeModule) validate(parent string) error {
+	if m == nil {
+		return fmt.Errorf("%w: nil module in %q", ErrInvalidModule, parent)
+	}
+	if m.Name == "" {
+		return fmt.Errorf("%w: empty module name in %q", ErrInvalidModule, parent)
+	}
+	name := m.Name
+	if parent != "" {
+		if strings.ContainsAny(m.Name, "./") {
+			return fmt.Errorf("%w: submodule name %q must not contain '.' or '/'", ErrInvalidModule, m.Name)
+		}
+		name = parent + "." + m.Name
+	}
+
+	members := make(map[string]bool)
+	for key, fn := range m.Functions {
+		if rv := reflect.ValueOf(fn); rv.Kind() != reflect.Func || rv.IsNil() {
+			return fmt.Errorf("%w: %s.%s must be a function, got %T", ErrInvalidModule, name, key, fn)
+		}
+		members[key] = true
+	}
+	for key := range m.Constants {
+		if members[key] {
+			return fmt.Errorf("%w: duplicate member %s.%s", ErrInvalidModule, name, key)
+		}
+		members[key] = true
+	}
+	for _, sub := range m.Modules {
+		if sub != nil && members[sub.Name] {
+			return fmt.Errorf("%w: duplicate member %s.%s", ErrInvalidModule, name, sub.Name)
+		}
+		if err := sub.validate(name); err != nil {
+			return err
+		}
+		members[sub.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package script_engine
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// FieldNaming Go struct 的字段与方法在脚本中的命名方式。
+// 设置后两种引擎以相同的方式命名全局变量、函数参数与返回值、模块中的 struct，
+// 无论哪种方式，json 标签为 "-" 的字段都被忽略。
+type FieldNaming int
+
+const (
+	// FieldNamingDefault 保持各引擎原有的命名：Lua 复制为 table 时字段名优先使用 json 标签，
+	// gopher-luar 绑定的 userdata 同时接受 Go 名称与首字母小写的名称，JavaScript 使用 Go 名称。
+	FieldNamingDefault FieldNaming = iota
+	// FieldNamingGo 字段与方法使用 Go 名称，例如 UserID
+	FieldNamingGo
+	// FieldNamingJSON 字段优先使用 json 标签，没有标签时使用 Go 名称；方法使用 Go 名称
+	FieldNamingJSON
+	// FieldNamingLowerCamel 字段与方法使用首字母小写的驼峰名称，例如 userID、httpServer
+	FieldNamingLowerCamel
+	// FieldNamingSnakeCase 字段与方法使用下划线分隔的小写名称，例如 user_id、http_server
+	FieldNamingSnakeCase
+)
+
+// FieldName 返回字段在脚本中的名称，字段不可导出或被忽略时 ok 为 false。
+// FieldNamingDefault 与 FieldNamingJSON 相同。
+func (n FieldNaming) FieldName(field reflect.StructField) (name string, ok bool) {
+	if !field.IsExported() && !field.Anonymous {
+		return "", false
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	switch n {
+	case FieldNamingGo:
+		return field.Name, true
+	case FieldNamingLowerCamel:
+		return lowerCamel(field.Name), true
+	case FieldNamingSnakeCase:
+		return snakeCase(field.Name), true
+	}
+
+	if name, _, _ = strings.Cut(tag, ","); name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+// MethodName 返回方法在脚本中的名称
+func (n FieldNaming) MethodName(name string) string {
+	switch n {
+	case FieldNamingLowerCamel:
+		return lowerCamel(name)
+	case FieldNamingSnakeCase:
+		return snakeCase(name)
+	}
+	return name
+}
+
+// MatchFieldName 判断脚本对象的键 key 是否对应字段名 name：忽略大小写与下划线，
+// 因此 userId、user_id 与 UserID 都对应字段 UserID
+func MatchFieldName(key, name string) bool {
+	return strings.EqualFold(key, name) ||
+		strings.EqualFold(strings.ReplaceAll(key, "_", ""), strings.ReplaceAll(name, "_", ""))
+}
+
+// lowerCamel 将 Go 名称转换为首字母小写的驼峰名称，开头的缩写整体小写：ID → id，HTTPServer → httpServer
+func lowerCamel(name string) string {
+	runes := []rune(name)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		// 缩写之后紧跟小写字母时，缩写的最后一个字母属于下一个单词
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+// snakeCase 将 Go 名称转换为下划线分隔的小写名称：UserID → user_id，HTTPServer → http_server
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) && runes[i-1] != '_' {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
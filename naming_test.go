@@ -0,0 +1,81 @@
+package script_engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamingCase(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		lowerCamel string
+		snakeCase  string
+	}{
+		{"", "", ""},
+		{"X", "x", "x"},
+		{"ID", "id", "id"},
+		{"Name", "name", "name"},
+		{"userName", "userName", "user_name"},
+		{"UserID", "userID", "user_id"},
+		{"HTTPServer", "httpServer", "http_server"},
+		{"ServeHTTP", "serveHTTP", "serve_http"},
+		{"HTTP2Server", "http2Server", "http2_server"},
+		{"URL2Text", "url2Text", "url2_text"},
+		{"MD5Sum", "md5Sum", "md5_sum"},
+		{"Base64Data", "base64Data", "base64_data"},
+		{"Version2", "version2", "version2"},
+		{"A_B", "a_B", "a_b"},
+		{"ÄpfelBaum", "äpfelBaum", "äpfel_baum"},
+	} {
+		assert.Equal(t, tt.lowerCamel, lowerCamel(tt.name), tt.name)
+		assert.Equal(t, tt.snakeCase, snakeCase(tt.name), tt.name)
+	}
+}
+
+func TestFieldNaming(t *testing.T) {
+	type user struct {
+		UserID  string `json:"uid,omitempty"`
+		Name    string
+		Ignored string `json:"-"`
+	}
+	typ := reflect.TypeOf(user{})
+
+	for _, tt := range []struct {
+		naming FieldNaming
+		names  []string
+	}{
+		{FieldNamingDefault, []string{"uid", "Name", ""}},
+		{FieldNamingJSON, []string{"uid", "Name", ""}},
+		{FieldNamingGo, []string{"UserID", "Name", ""}},
+		{FieldNamingLowerCamel, []string{"userID", "name", ""}},
+		{FieldNamingSnakeCase, []string{"user_id", "name", ""}},
+	} {
+		for i, want := range tt.names {
+			name, ok := tt.naming.FieldName(typ.Field(i))
+			assert.Equal(t, want != "", ok, typ.Field(i).Name)
+			assert.Equal(t, want, name, typ.Field(i).Name)
+		}
+	}
+
+	assert.Equal(t, "serveHTTP", FieldNamingLowerCamel.MethodName("ServeHTTP"))
+	assert.Equal(t, "serve_http", FieldNamingSnakeCase.MethodName("ServeHTTP"))
+	assert.Equal(t, "ServeHTTP", FieldNamingJSON.MethodName("ServeHTTP"))
+}
+
+func TestMatchFieldName(t *testing.T) {
+	for _, tt := range []struct {
+		key, name string
+		match     bool
+	}{
+		{"UserID", "UserID", true},
+		{"userId", "UserID", true},
+		{"user_id", "UserID", true},
+		{"USER_ID", "user_id", true},
+		{"userName", "UserID", false},
+		{"user", "UserID", false},
+	} {
+		assert.Equal(t, tt.match, MatchFieldName(tt.key, tt.name), tt.key+"/"+tt.name)
+	}
+}
@@ -0,0 +1,252 @@
+package script_engine
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Options 引擎创建选项，由 Option 函数在创建引擎时设置。
+type Options struct {
+	// SpreadArrayResults 为 true 时，CallFunctionMulti 会将 JavaScript 函数返回的数组展开为多个返回值。
+	SpreadArrayResults bool
+	// Budget 每次执行的预算上限，<= 0 表示不限制。
+	// Lua 引擎按虚拟机指令计量，JavaScript 引擎按循环迭代与函数调用次数计量。
+	// JavaScript 引擎为编译的脚本与加载的模块插入计量代码，ExecuteOptions.Budget 对之前编译的代码同样生效。
+	Budget int64
+	// StructMode Lua 引擎将 Go struct 转换为 Lua 值的方式，默认复制为 table。
+	StructMode StructMode
+	// FieldNaming Go struct 的字段与方法在脚本中的命名方式，默认保持各引擎原有的命名。
+	FieldNaming FieldNaming
+	// Int64Mode int64 与 uint64 在脚本中的表示方式，默认转换为脚本的数字。
+	Int64Mode Int64Mode
+	// BytesMode Lua 引擎将 []byte 转换为 Lua 值的方式，默认转换为字符串。
+	BytesMode BytesMode
+	// ModuleLoader 脚本的 require 查找模块文件的来源，为 nil 时保持各引擎原有的查找方式。
+	// 以 ./ 或 ../ 开头的模块名相对于调用 require 的模块所在的目录，其他模块名在 ModulePaths 中依次查找。
+	ModuleLoader ModuleLoader
+	// ModulePaths 在 ModuleLoader 中查找非相对模块名的目录，为空时只在根目录查找
+	ModulePaths []string
+	// NodeModules JavaScript 引擎启用的 Node.js 兼容模块，默认只启用 require。
+	NodeModules NodeModule
+	// ESModules 为 true 时 JavaScript 引擎以 ES 模块方式加载脚本，脚本可以使用 import 与 export。
+	ESModules bool
+	// WorkDir LoadFile、ExecuteFile 解析相对路径的目录，ScriptRoots、LuaPath 与 LuaCPath 中的相对路径同样相对于它；
+	// 为空时使用进程的当前目录。
+	WorkDir string
+	// ScriptRoots 脚本根目录。LoadFile、ExecuteFile 的相对路径在 WorkDir 中不存在时依次在其中查找；
+	// Lua 的 require 在其中查找 ?.lua 与 ?/init.lua，JavaScript 的 require 在其中查找非相对的模块名。
+	ScriptRoots []string
+	// LuaPath Lua 的 package.path 模板，例如 ./?.lua，为空时使用 gopher-lua 的默认值
+	LuaPath []string
+	// LuaCPath Lua 的 package.cpath 模板，为空时使用 gopher-lua 的默认值
+	LuaCPath []string
+}
+
+// StructMode Lua 引擎将 Go struct 转换为 Lua 值的方式。
+type StructMode int
+
+const (
+	// StructAsTable 将 struct 的导出字段复制为 table，字段名优先使用 json 标签。
+	StructAsTable StructMode = iota
+	// StructAsUserData 通过 gopher-luar 将 struct 绑定为 userdata，脚本可以读写字段并调用方法。
+	StructAsUserData
+)
+
+// BytesMode Lua 引擎将 []byte 转换为 Lua 值的方式。
+// JavaScript 引擎总是将 []byte 转换为与其共享数据的 Uint8Array，传回 Go 时接受类型化数组、ArrayBuffer 与字符串。
+type BytesMode int
+
+const (
+	// BytesAsString 复制为 Lua 字符串，传回 Go 的 []byte 参数或字段时按字节复制。
+	BytesAsString BytesMode = iota
+	// BytesAsBuffer 转换为与 []byte 共享数据的 buffer userdata，脚本可通过 buffer(s) 创建，
+	// 支持 #buf、buf:get(i)、buf:set(i, b)、buf:sub(i, j)、buf:append(s)、tostring(buf) 等操作。
+	BytesAsBuffer
+)
+
+// Int64Mode int64 与 uint64 在脚本中的表示方式。
+// 两种脚本语言的数字都是 float64，绝对值超过 2^53 的整数转换为数字后会丢失精度。
+// Lua 引擎中 int、uint 与 uintptr 可能是 64 位，同样按 Int64Mode 转换。
+// Lua 引擎以 StructAsUserData 方式绑定的 struct 由 gopher-luar 转换，其字段不受 Int64Mode 影响。
+type Int64Mode int
+
+const (
+	// Int64AsNumber 转换为脚本的数字，超过 2^53 的整数会丢失精度。
+	Int64AsNumber Int64Mode = iota
+	// Int64AsObject Lua 中转换为支持算术与比较运算的 int64、uint64 userdata，
+	// 脚本可通过 int64(v)、uint64(v) 创建；JavaScript 中转换为 BigInt。
+	// 比较运算要求两侧都是 64 位整数，例如 Lua 中的 id == int64(1)、JavaScript 中的 id === 1n。
+	Int64AsObject
+	// Int64AsString 转换为十进制字符串，传回 Go 的整数参数或字段时按十进制解析。
+	Int64AsString
+)
+
+// NodeModule JavaScript 引擎启用的 Node.js 兼容模块，由 goja_nodejs 实现，可按位组合。
+// 未启用的模块既不会出现在全局命名空间中，也不能通过 require 加载。
+type NodeModule uint
+
+const (
+	// NodeRequire 全局 require 函数，未启用时 RegisterModule 注册的模块与 ModuleLoader 同样不可用
+	NodeRequire NodeModule = 1 << iota
+	// NodeConsole 全局 console 对象，输出到标准输出与标准错误
+	NodeConsole
+	// NodeProcess 全局 process 对象，脚本可以通过 process.env 读取宿主的环境变量
+	NodeProcess
+	// NodeBuffer 全局 Buffer 类
+	NodeBuffer
+	// NodeURL 全局 URL 与 URLSearchParams 类
+	NodeURL
+	// NodeUtil 可通过 require("util") 加载的 util 模块
+	NodeUtil
+
+	// NodeDefault 默认启用的模块
+	NodeDefault = NodeRequire
+	// NodeAll 全部模块
+	NodeAll = NodeRequire | NodeConsole | NodeProcess | NodeBuffer | NodeURL | NodeUtil
+)
+
+// ResolvePath 返回 WorkDir 中的相对路径 name 对应的路径，绝对路径与未设置 WorkDir 时原样返回
+func (o *Options) ResolvePath(name string) string {
+	if o.WorkDir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(o.WorkDir, name)
+}
+
+// ScriptPath 返回 LoadFile、ExecuteFile 读取的文件路径：相对路径先在 WorkDir 中查找，再依次在 ScriptRoots 中查找，
+// 都不存在时返回 WorkDir 中的路径。
+func (o *Options) ScriptPath(name string) string {
+	path := o.ResolvePath(name)
+	if filepath.IsAbs(name) || len(o.ScriptRoots) == 0 {
+		return path
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	for _, root := range o.ScriptRoots {
+		candidate := filepath.Join(o.ResolvePath(root), name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return path
+}
+
+// Option 用于设置 Options 的函数。
+type Option func(*Options)
+
+// NewOptions 创建默认 Options 并依次应用 opts。
+func NewOptions(opts ...Option) *Options {
+	o := &Options{NodeModules: NodeDefault}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// WithSpreadArrayResults 设置 CallFunctionMulti 是否展开 JavaScript 函数返回的数组。
+func WithSpreadArrayResults(spread bool) Option {
+	return func(o *Options) {
+		o.SpreadArrayResults = spread
+	}
+}
+
+// WithBudget 设置每次执行的预算上限，预算耗尽时执行以 ErrBudgetExceeded 中止。
+func WithBudget(budget int64) Option {
+	return func(o *Options) {
+		o.Budget = budget
+	}
+}
+
+// WithStructMode 设置 Lua 引擎将 Go struct 转换为 Lua 值的方式。
+func WithStructMode(mode StructMode) Option {
+	return func(o *Options) {
+		o.StructMode = mode
+	}
+}
+
+// WithFieldNaming 设置 Go struct 的字段与方法在脚本中的命名方式。
+func WithFieldNaming(naming FieldNaming) Option {
+	return func(o *Options) {
+		o.FieldNaming = naming
+	}
+}
+
+// WithInt64Mode 设置 int64 与 uint64 在脚本中的表示方式，用于精确传递雪花 ID 等 64 位整数。
+func WithInt64Mode(mode Int64Mode) Option {
+	return func(o *Options) {
+		o.Int64Mode = mode
+	}
+}
+
+// WithBytesMode 设置 Lua 引擎将 []byte 转换为 Lua 值的方式。
+func WithBytesMode(mode BytesMode) Option {
+	return func(o *Options) {
+		o.BytesMode = mode
+	}
+}
+
+// WithModuleLoader 设置脚本的 require 从 loader 加载模块文件，非相对的模块名在 paths 中依次查找。
+// Lua 模块名 a.b 对应 a/b.lua 或 a/b/init.lua；JavaScript 按 Node.js 的规则查找 .js、.json、index.js 与 node_modules。
+func WithModuleLoader(loader ModuleLoader, paths ...string) Option {
+	return func(o *Options) {
+		o.ModuleLoader = loader
+		o.ModulePaths = paths
+	}
+}
+
+// WithModuleFS 设置脚本的 require 从 fsys（例如 embed.FS）加载模块文件，规则与 WithModuleLoader 相同
+func WithModuleFS(fsys fs.FS, paths ...string) Option {
+	return WithModuleLoader(FSModuleLoader(fsys), paths...)
+}
+
+// WithNodeModules 设置 JavaScript 引擎启用的 Node.js 兼容模块，例如 NodeRequire | NodeConsole。
+func WithNodeModules(modules NodeModule) Option {
+	return func(o *Options) {
+		o.NodeModules = modules
+	}
+}
+
+// WithESModules 设置 JavaScript 引擎是否以 ES 模块方式加载脚本：
+//   - 字符串脚本作为匿名模块执行，执行结果为模块的命名空间；
+//   - ExecuteFile 与 LoadFile 通过 require 加载模块文件，模块只执行一次，结果为模块的命名空间；
+//   - 静态与动态 import 通过 require 解析，设置了 ModuleLoader 时从其加载；
+//   - CallFunction 可以通过 "模块路径#函数名" 调用模块导出的函数。
+//
+// 模块模式总是启用 NodeRequire。
+func WithESModules(enabled bool) Option {
+	return func(o *Options) {
+		o.ESModules = enabled
+	}
+}
+
+// WithWorkDir 设置 LoadFile、ExecuteFile 以及各搜索路径解析相对路径的目录，使脚本的加载与进程的当前目录无关
+func WithWorkDir(dir string) Option {
+	return func(o *Options) {
+		o.WorkDir = dir
+	}
+}
+
+// WithScriptRoots 设置脚本根目录，LoadFile、ExecuteFile 与 require 在其中查找脚本
+func WithScriptRoots(roots ...string) Option {
+	return func(o *Options) {
+		o.ScriptRoots = roots
+	}
+}
+
+// WithLuaPath 设置 Lua 的 package.path 模板，替换 gopher-lua 的默认值与 LUA_PATH 环境变量
+func WithLuaPath(paths ...string) Option {
+	return func(o *Options) {
+		o.LuaPath = paths
+	}
+}
+
+// WithLuaCPath 设置 Lua 的 package.cpath 模板，替换 gopher-lua 的默认值与 LUA_CPATH 环境变量
+func WithLuaCPath(paths ...string) Option {
+	return func(o *Options) {
+		o.LuaCPath = paths
+	}
+}
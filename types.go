@@ -1,6 +1,9 @@
 package script_engine
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type Type string
 
@@ -17,13 +20,68 @@ const (
 
 // CallResult 函数调用结果
 type CallResult struct {
+	// Values 函数的全部返回值
 	Values []any
-	Error  error
+	// Error 脚本按约定返回的错误，例如 Lua 函数以 `return nil, err` 报告的失败
+	Error error
+}
+
+// FunctionSignature 脚本函数的参数信息
+type FunctionSignature struct {
+	// Params 函数声明的参数个数，不含 Lua 的 `...` 与 JavaScript 的剩余参数
+	Params int
+	// Optional Params 中末尾可以省略的参数个数，例如 JavaScript 带默认值的参数
+	Optional int
+	// Variadic 函数是否接受任意个数的参数，例如 Lua 的 `...` 参数与 Go 实现的函数
+	Variadic bool
 }
 
-// ExecuteOptions 执行选项
+// ExecuteOptions 执行选项，仅对单次执行生效，执行结束后引擎恢复原先的状态
 type ExecuteOptions struct {
-	Timeout  time.Duration
-	Globals  map[string]any
+	// Timeout 执行超时时间，转换为 ctx 的截止时间，脚本通过 ctx.deadline() 读取截止时间的 Unix 毫秒数；<= 0 表示不限制
+	Timeout time.Duration
+	// Globals 仅在本次执行期间注入的全局变量
+	Globals map[string]any
+	// MaxStack 本次执行的最大调用栈深度；<= 0 表示使用引擎默认值
 	MaxStack int
+	// Budget 本次执行的预算上限，覆盖引擎创建时设置的预算；<= 0 表示使用引擎默认值
+	Budget int64
+	// Values 本次执行的请求级数据（如 trace ID、租户），脚本通过 ctx.value(key) 读取，
+	// 第一个参数为 context.Context 的宿主函数通过 ctx.Value(key) 读取
+	Values map[string]any
+}
+
+// BudgetOr 返回本次执行生效的预算上限：Budget > 0 时使用 Budget，否则使用 def
+func (o ExecuteOptions) BudgetOr(def int64) int64 {
+	if o.Budget > 0 {
+		return o.Budget
+	}
+	return def
+}
+
+// Context 按 Timeout 为 ctx 设置截止时间，并使 Values 中的值可以通过 ctx.Value 读取。
+// 调用方需在执行结束后调用返回的 cancel。
+func (o ExecuteOptions) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if len(o.Values) > 0 {
+		ctx = valuesContext{Context: ctx, values: o.Values}
+	}
+	if o.Timeout > 0 {
+		return context.WithTimeout(ctx, o.Timeout)
+	}
+	return ctx, func() {}
+}
+
+// valuesContext 以字符串为键提供 ExecuteOptions.Values 中的值，其他键交给父 context
+type valuesContext struct {
+	context.Context
+	values map[string]any
+}
+
+func (c valuesContext) Value(key any) any {
+	if k, ok := key.(string); ok {
+		if v, ok := c.values[k]; ok {
+			return v
+		}
+	}
+	return c.Context.Value(key)
 }